@@ -0,0 +1,180 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A MapBuilder assembles an EDN map entry by entry, with calls that
+// can be chained:
+//
+//	m, err := edn.NewMap().
+//		KW("name", "widget").
+//		Vec("sizes", 1, 2, 3).
+//		Set("tags", edn.K("new")).
+//		KW("owner", edn.NewMap().KW("id", 7)).
+//		Build()
+//
+// Each call checks its entry as it is added: keyword names must be
+// valid keywords, keys and set elements must be usable as Go map keys,
+// and no key may be added twice, where keys are compared with Equal.
+// The first problem found is reported by Build, and the calls after it
+// do nothing. Values that are MapBuilders or VecBuilders are built when
+// they are added, so later changes to them are not seen.
+type MapBuilder struct {
+	m   map[interface{}]interface{}
+	err error
+}
+
+var builtMapType = reflect.TypeOf(map[interface{}]interface{}(nil))
+
+// NewMap returns a MapBuilder for an empty map.
+func NewMap() *MapBuilder {
+	return &MapBuilder{m: make(map[interface{}]interface{})}
+}
+
+// Put adds the entry k v.
+func (b *MapBuilder) Put(k, v interface{}) *MapBuilder {
+	if b.err != nil {
+		return b
+	}
+	if b.err = checkElem(k, "map key"); b.err != nil {
+		return b
+	}
+	if _, ok := findKey(b.m, k); ok {
+		text, _ := MarshalCanonical(k)
+		b.err = &DuplicateKeyError{builtMapType, string(text)}
+		return b
+	}
+	if v, b.err = build(v); b.err == nil {
+		b.m[k] = v
+	}
+	return b
+}
+
+// KW adds the entry with the keyword key :name and value v. The name
+// may have a namespace, as in "db/id", and a leading colon.
+func (b *MapBuilder) KW(name string, v interface{}) *MapBuilder {
+	if b.err != nil {
+		return b
+	}
+	k, err := ParseKeyword(name)
+	if err != nil {
+		b.err = err
+		return b
+	}
+	return b.Put(k, v)
+}
+
+// Vec adds the entry with the keyword key :name and a vector of elems.
+func (b *MapBuilder) Vec(name string, elems ...interface{}) *MapBuilder {
+	return b.KW(name, NewVec(elems...))
+}
+
+// Set adds the entry with the keyword key :name and a set of elems.
+func (b *MapBuilder) Set(name string, elems ...interface{}) *MapBuilder {
+	if b.err != nil {
+		return b
+	}
+	s := make(Set, len(elems))
+	for _, x := range elems {
+		var err error
+		if x, err = build(x); err == nil {
+			err = checkElem(x, "set element")
+		}
+		if err != nil {
+			b.err = err
+			return b
+		}
+		s[x] = struct{}{}
+	}
+	return b.KW(name, s)
+}
+
+// Build returns the map, or the first problem found while adding to it.
+func (b *MapBuilder) Build() (map[interface{}]interface{}, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.m, nil
+}
+
+// MustBuild is like Build but panics if there was a problem. It
+// simplifies the initialization of global variables holding fixed
+// values.
+func (b *MapBuilder) MustBuild() map[interface{}]interface{} {
+	m, err := b.Build()
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// A VecBuilder assembles an EDN vector element by element. Like a
+// MapBuilder, it builds MapBuilder and VecBuilder elements as they are
+// added, and reports the first problem with them from Build.
+type VecBuilder struct {
+	v   Vec
+	err error
+}
+
+// NewVec returns a VecBuilder for a vector of elems.
+func NewVec(elems ...interface{}) *VecBuilder {
+	return new(VecBuilder).Add(elems...)
+}
+
+// Add appends elems to the vector.
+func (b *VecBuilder) Add(elems ...interface{}) *VecBuilder {
+	for _, x := range elems {
+		if b.err != nil {
+			break
+		}
+		if x, b.err = build(x); b.err == nil {
+			b.v = append(b.v, x)
+		}
+	}
+	return b
+}
+
+// Build returns the vector, or the first problem found while adding to
+// it. A vector with no elements is empty, not nil.
+func (b *VecBuilder) Build() (Vec, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.v == nil {
+		return Vec{}, nil
+	}
+	return b.v, nil
+}
+
+// MustBuild is like Build but panics if there was a problem.
+func (b *VecBuilder) MustBuild() Vec {
+	v, err := b.Build()
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// build returns the value built by x if it is a builder, or else x.
+func build(x interface{}) (interface{}, error) {
+	switch x := x.(type) {
+	case *MapBuilder:
+		return x.Build()
+	case *VecBuilder:
+		return x.Build()
+	}
+	return x, nil
+}
+
+// checkElem checks that x can be a map key or set element.
+func checkElem(x interface{}, what string) error {
+	if !isComparable(x) {
+		return fmt.Errorf("edn: cannot use %#v as a %s", x, what)
+	}
+	return nil
+}
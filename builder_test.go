@@ -0,0 +1,59 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type BuilderTests struct{}
+
+func init() { check.Suite(&BuilderTests{}) }
+
+func (*BuilderTests) TestBuild(c *check.C) {
+	m, err := NewMap().
+		KW("name", "widget").
+		KW(":db/id", 7).
+		Vec("sizes", 1, NewVec(2, 3)).
+		Set("tags", K("new"), K("new"), "x").
+		KW("owner", NewMap().KW("id", 8)).
+		Put(S("sym"), nil).
+		Build()
+	c.Assert(err, check.IsNil)
+	c.Check(m, check.DeepEquals, map[interface{}]interface{}{
+		K("name"):  "widget",
+		K("db/id"): 7,
+		K("sizes"): Vec{1, Vec{2, 3}},
+		K("tags"):  NewSet(K("new"), "x"),
+		K("owner"): map[interface{}]interface{}{K("id"): 8},
+		S("sym"):   nil,
+	})
+	c.Check(NewVec().MustBuild(), check.DeepEquals, Vec{})
+	c.Check(NewMap().MustBuild(), check.DeepEquals, map[interface{}]interface{}{})
+
+	b, err := Marshal(NewMap().Vec("v", "a").MustBuild())
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, `{:v ["a"]}`)
+}
+
+func (*BuilderTests) TestBuildErrors(c *check.C) {
+	for _, t := range []struct {
+		err  error
+		want string
+	}{
+		{buildErr(NewMap().KW("no good", 1).Build()), `edn: invalid keyword "no good"`},
+		{buildErr(NewMap().KW("a", 1).KW(":a", 2).Build()), `edn: duplicate map key :a in map\[interface {}\]interface {}`},
+		{buildErr(NewMap().Put(Vec{1}, 1).Build()), `edn: cannot use edn.Vec\{1\} as a map key`},
+		{buildErr(NewMap().Set("s", []int{1}).Build()), `edn: cannot use \[\]int\{1\} as a set element`},
+		{buildErr(NewMap().KW("m", NewMap().KW("", 1)).KW("ok", 2).Build()), `edn: invalid keyword ""`},
+		{buildErr(NewVec(1, NewVec(NewMap().Put(Vec{}, 1)), 2).Build()), `edn: cannot use edn.Vec\{\} as a map key`},
+	} {
+		c.Check(t.err, check.ErrorMatches, t.want)
+	}
+	c.Check(func() { NewMap().KW("", 1).MustBuild() }, check.PanicMatches, `edn: invalid keyword ""`)
+}
+
+func buildErr(_ interface{}, err error) error {
+	return err
+}
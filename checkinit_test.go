@@ -3,9 +3,13 @@
 
 package edn
 
+// The tests import gocheck by name rather than with a dot import, which
+// would clash with this package's List type: gocheck exports a List
+// function, and a package may not declare a name that one of its files
+// dot-imports.
 import (
-	. "gopkg.in/check.v1"
+	"gopkg.in/check.v1"
 	"testing"
 )
 
-func TestCheckInit(t *testing.T) { TestingT(t) }
+func TestCheckInit(t *testing.T) { check.TestingT(t) }
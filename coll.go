@@ -0,0 +1,258 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// GetIn returns the value found by following path from v, in the
+// manner of Clojure's get-in, and whether there is one. Each step of
+// the path is a map key, a vector or list index, or a set element.
+//
+// The collections that can be stepped into are []interface{}, Vec,
+// List, Set, HashMap, OrderedMap, and maps with interface{} or string
+// keys, including KMap and SMap, whose keys may be given as Keywords
+// and Symbols respectively. Keys of map[interface{}]interface{} are
+// found by Equal, so K(":a") finds the key K("a").
+func GetIn(v interface{}, path ...interface{}) (interface{}, bool) {
+	for _, k := range path {
+		var ok bool
+		if v, ok = get(v, k); !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// AssocIn returns a copy of v with the value at path set to x, in the
+// manner of Clojure's assoc-in. Missing or nil maps along the path are
+// created as map[interface{}]interface{}, and an index equal to the
+// length of a vector or list appends to it.
+//
+// Only the collections along the path are copied, so v itself is left
+// unchanged, while the result shares everything else with it. AssocIn
+// fails if a step of the path is not a collection that can hold the
+// step's key.
+func AssocIn(v interface{}, path []interface{}, x interface{}) (interface{}, error) {
+	return UpdateIn(v, path, func(interface{}) interface{} { return x })
+}
+
+// UpdateIn is like AssocIn, but sets the value at path to f applied to
+// the value there, or to nil if there is none.
+func UpdateIn(v interface{}, path []interface{}, f func(interface{}) interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return f(v), nil
+	}
+	child, _ := get(v, path[0])
+	child, err := UpdateIn(child, path[1:], f)
+	if err != nil {
+		return nil, err
+	}
+	return assoc(v, path[0], child)
+}
+
+// stringKeyOf returns the string key k names in a map with string keys,
+// where keyAs is the type of key the strings stand for, if any.
+func stringKeyOf(k interface{}, keyAs reflect.Type) (string, bool) {
+	switch k := k.(type) {
+	case string:
+		return k, true
+	case Keyword:
+		if keyAs == keywordType {
+			return strings.TrimPrefix(string(k), ":"), true
+		}
+	case Symbol:
+		if keyAs == symbolType {
+			return string(k), true
+		}
+	}
+	return "", false
+}
+
+// seqIndex returns k as an index of a sequence.
+func seqIndex(k interface{}) (int, bool) {
+	v := reflect.ValueOf(k)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return int(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int(v.Uint()), true
+	}
+	return 0, false
+}
+
+// isComparable reports whether k can be used as a Go map key.
+func isComparable(k interface{}) bool {
+	return k == nil || reflect.ValueOf(k).Comparable()
+}
+
+// findKey returns the key of m that is Equal to k.
+func findKey(m map[interface{}]interface{}, k interface{}) (interface{}, bool) {
+	if isComparable(k) {
+		if _, ok := m[k]; ok {
+			return k, true
+		}
+	}
+	for mk := range m {
+		if Equal(mk, k) {
+			return mk, true
+		}
+	}
+	return nil, false
+}
+
+// get returns the element of coll at key k, and whether there is one.
+func get(coll, k interface{}) (interface{}, bool) {
+	switch c := coll.(type) {
+	case map[interface{}]interface{}:
+		if mk, ok := findKey(c, k); ok {
+			return c[mk], true
+		}
+	case KMap:
+		if s, ok := stringKeyOf(k, keywordType); ok {
+			x, ok := c[s]
+			return x, ok
+		}
+	case SMap:
+		if s, ok := stringKeyOf(k, symbolType); ok {
+			x, ok := c[s]
+			return x, ok
+		}
+	case map[string]interface{}:
+		if s, ok := stringKeyOf(k, nil); ok {
+			x, ok := c[s]
+			return x, ok
+		}
+	case HashMap:
+		return c.Get(k)
+	case *HashMap:
+		return c.Get(k)
+	case OrderedMap:
+		if isComparable(k) {
+			return c.Get(k)
+		}
+	case *OrderedMap:
+		if isComparable(k) {
+			return c.Get(k)
+		}
+	case Set:
+		for x := range c {
+			if Equal(x, k) {
+				return x, true
+			}
+		}
+	case []interface{}:
+		return getIndex(c, k)
+	case Vec:
+		return getIndex(c, k)
+	case List:
+		return getIndex(c, k)
+	}
+	return nil, false
+}
+
+func getIndex(s []interface{}, k interface{}) (interface{}, bool) {
+	if i, ok := seqIndex(k); ok && 0 <= i && i < len(s) {
+		return s[i], true
+	}
+	return nil, false
+}
+
+// assoc returns a copy of coll with the element at key k set to x.
+func assoc(coll, k, x interface{}) (interface{}, error) {
+	switch c := coll.(type) {
+	case nil:
+		if !isComparable(k) {
+			return nil, fmt.Errorf("edn: cannot use %#v as a map key", k)
+		}
+		return map[interface{}]interface{}{k: x}, nil
+	case map[interface{}]interface{}:
+		if !isComparable(k) {
+			return nil, fmt.Errorf("edn: cannot use %#v as a map key", k)
+		}
+		m := make(map[interface{}]interface{}, len(c)+1)
+		for mk, mx := range c {
+			m[mk] = mx
+		}
+		if mk, ok := findKey(c, k); ok {
+			k = mk
+		}
+		m[k] = x
+		return m, nil
+	case KMap:
+		if s, ok := stringKeyOf(k, keywordType); ok {
+			return KMap(assocString(c, s, x)), nil
+		}
+	case SMap:
+		if s, ok := stringKeyOf(k, symbolType); ok {
+			return SMap(assocString(c, s, x)), nil
+		}
+	case map[string]interface{}:
+		if s, ok := stringKeyOf(k, nil); ok {
+			return assocString(c, s, x), nil
+		}
+	case HashMap:
+		m := c.clone()
+		if err := m.Set(k, x); err != nil {
+			return nil, err
+		}
+		return m, nil
+	case *HashMap:
+		m := c.clone()
+		if err := m.Set(k, x); err != nil {
+			return nil, err
+		}
+		return &m, nil
+	case OrderedMap:
+		if isComparable(k) {
+			m := c.clone()
+			m.Set(k, x)
+			return m, nil
+		}
+	case *OrderedMap:
+		if isComparable(k) {
+			m := c.clone()
+			m.Set(k, x)
+			return &m, nil
+		}
+	case []interface{}:
+		return assocIndex(c, k, x)
+	case Vec:
+		s, err := assocIndex(c, k, x)
+		return Vec(s), err
+	case List:
+		s, err := assocIndex(c, k, x)
+		return List(s), err
+	}
+	return nil, fmt.Errorf("edn: cannot associate key %#v in %T", k, coll)
+}
+
+func assocString(c map[string]interface{}, k string, x interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(c)+1)
+	for mk, mx := range c {
+		m[mk] = mx
+	}
+	m[k] = x
+	return m
+}
+
+func assocIndex(c []interface{}, k, x interface{}) ([]interface{}, error) {
+	i, ok := seqIndex(k)
+	if !ok {
+		return nil, fmt.Errorf("edn: cannot use %#v as an index", k)
+	}
+	if i < 0 || i > len(c) {
+		return nil, fmt.Errorf("edn: index %d out of range for length %d", i, len(c))
+	}
+	s := make([]interface{}, len(c), len(c)+1)
+	copy(s, c)
+	if i == len(c) {
+		return append(s, x), nil
+	}
+	s[i] = x
+	return s, nil
+}
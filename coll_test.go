@@ -0,0 +1,103 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type CollTests struct{}
+
+func init() { check.Suite(&CollTests{}) }
+
+func (*CollTests) TestGetIn(c *check.C) {
+	var hm HashMap
+	hm.Set(Vec{1, 2}, "pair")
+	v := map[interface{}]interface{}{
+		K("users"): Vec{
+			KMap{"name": "ann", "roles": NewSet(K("admin"))},
+		},
+		"hm":  &hm,
+		"sym": SMap{"?e": List{1, 2}},
+	}
+	for _, t := range []struct {
+		path []interface{}
+		want interface{}
+	}{
+		{nil, v},
+		{[]interface{}{K(":users"), 0, K("name")}, "ann"},
+		{[]interface{}{K("users"), int64(0), "roles", K("admin")}, K("admin")},
+		{[]interface{}{"hm", []int{1, 2}}, "pair"},
+		{[]interface{}{"sym", S("?e"), 1}, 2},
+	} {
+		got, ok := GetIn(v, t.path...)
+		c.Check(ok, check.Equals, true, check.Commentf("%v", t.path))
+		c.Check(got, check.DeepEquals, t.want, check.Commentf("%v", t.path))
+	}
+	for _, path := range [][]interface{}{
+		{"users"},
+		{K("users"), 1},
+		{K("users"), -1},
+		{K("users"), "0"},
+		{K("users"), 0, S("name")},
+		{"sym", "?e", 0, 0},
+		{[]int{1}},
+	} {
+		_, ok := GetIn(v, path...)
+		c.Check(ok, check.Equals, false, check.Commentf("%v", path))
+	}
+}
+
+func (*CollTests) TestAssocIn(c *check.C) {
+	v := KMap{"db": map[interface{}]interface{}{K("hosts"): Vec{"a"}}}
+	got, err := AssocIn(v, []interface{}{K("db"), K(":hosts"), 1}, "b")
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, KMap{"db": map[interface{}]interface{}{K("hosts"): Vec{"a", "b"}}})
+	c.Check(v, check.DeepEquals, KMap{"db": map[interface{}]interface{}{K("hosts"): Vec{"a"}}})
+
+	got, err = AssocIn(v, []interface{}{K("log"), K("level")}, K("debug"))
+	c.Assert(err, check.IsNil)
+	c.Check(got.(KMap)["log"], check.DeepEquals, map[interface{}]interface{}{K("level"): K("debug")})
+
+	got, err = AssocIn(nil, nil, 1)
+	c.Check(got, check.Equals, 1)
+
+	var om OrderedMap
+	om.Set("a", 1)
+	got, err = AssocIn(&om, []interface{}{"b"}, 2)
+	c.Assert(err, check.IsNil)
+	c.Check(got.(*OrderedMap).Keys(), check.DeepEquals, []interface{}{"a", "b"})
+	c.Check(om.Len(), check.Equals, 1)
+
+	for _, t := range []struct {
+		v    interface{}
+		path []interface{}
+		err  string
+	}{
+		{Vec{1}, []interface{}{2}, "edn: index 2 out of range for length 1"},
+		{Vec{1}, []interface{}{"a"}, `edn: cannot use "a" as an index`},
+		{"s", []interface{}{0}, `edn: cannot associate key 0 in string`},
+		{KMap{}, []interface{}{S("a")}, `edn: cannot associate key "a" in edn.KMap`},
+		{nil, []interface{}{[]int{1}}, `edn: cannot use \[\]int\{1\} as a map key`},
+		{KMap{"a": 1}, []interface{}{"a", "b"}, `edn: cannot associate key "b" in int`},
+	} {
+		_, err := AssocIn(t.v, t.path, 0)
+		c.Check(err, check.ErrorMatches, t.err)
+	}
+}
+
+func (*CollTests) TestUpdateIn(c *check.C) {
+	inc := func(x interface{}) interface{} {
+		n, _ := x.(int)
+		return n + 1
+	}
+	v := Vec{KMap{"n": 1}}
+	got, err := UpdateIn(v, []interface{}{0, K("n")}, inc)
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, Vec{KMap{"n": 2}})
+	got, err = UpdateIn(got, []interface{}{0, "m"}, inc)
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, Vec{KMap{"n": 2, "m": 1}})
+	c.Check(v, check.DeepEquals, Vec{KMap{"n": 1}})
+}
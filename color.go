@@ -0,0 +1,118 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"io"
+)
+
+// A ColorScheme holds the ANSI escape sequences Colorize writes before
+// each kind of EDN element. Elements whose sequence is empty, as well
+// as symbols and delimiters, are written uncolored.
+type ColorScheme struct {
+	Keyword string
+	String  string
+	Char    string
+	Number  string
+	Literal string // nil, true, false and ##Inf, ##-Inf, ##NaN
+	Tag     string
+}
+
+// DefaultColorScheme is the ColorScheme used by NewColorWriter.
+var DefaultColorScheme = ColorScheme{
+	Keyword: "\x1b[36m", // cyan
+	String:  "\x1b[32m", // green
+	Char:    "\x1b[32m", // green
+	Number:  "\x1b[33m", // yellow
+	Literal: "\x1b[35m", // magenta
+	Tag:     "\x1b[34m", // blue
+}
+
+const colorReset = "\x1b[0m"
+
+// Colorize appends to dst the EDN-encoded src with its elements colored
+// by the ANSI escape sequences of cs, for display on a terminal. The
+// whitespace and comments of src are kept.
+func Colorize(dst *bytes.Buffer, src []byte, cs *ColorScheme) error {
+	off := 0
+	for {
+		tok, next, err := nextToken(src, off)
+		if err == io.EOF {
+			dst.Write(src[off:])
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		dst.Write(src[off:tok.off])
+		if color := cs.colorOf(tok); color != "" {
+			dst.WriteString(color)
+			dst.Write(tok.text)
+			dst.WriteString(colorReset)
+		} else {
+			dst.Write(tok.text)
+		}
+		off = next
+	}
+}
+
+// colorOf returns the escape sequence for tok.
+func (cs *ColorScheme) colorOf(tok token) string {
+	switch tok.kind {
+	case tokTag:
+		return cs.Tag
+	case tokAtom:
+	default:
+		return ""
+	}
+	switch s := string(tok.text); {
+	case s[0] == '"':
+		return cs.String
+	case s[0] == '\\':
+		return cs.Char
+	case s[0] == ':':
+		return cs.Keyword
+	case s == "nil" || s == "true" || s == "false" || s[0] == '#':
+		return cs.Literal
+	case numberLike(s):
+		return cs.Number
+	}
+	return ""
+}
+
+// A ColorWriter colors the EDN written to it with Colorize before
+// passing it on, so that an Encoder writing to it produces output for
+// display on a terminal:
+//
+//	enc := edn.NewEncoder(edn.NewColorWriter(os.Stdout))
+//
+// Each call to Write must hold whole EDN elements, as an Encoder's
+// calls do; a Write that ends inside a string is passed on uncolored.
+type ColorWriter struct {
+	Scheme ColorScheme
+
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// NewColorWriter returns a ColorWriter that writes to w using
+// DefaultColorScheme.
+func NewColorWriter(w io.Writer) *ColorWriter {
+	return &ColorWriter{Scheme: DefaultColorScheme, w: w}
+}
+
+// Write colors p and writes the result to the underlying writer. It
+// returns len(p) if the whole result was written.
+func (cw *ColorWriter) Write(p []byte) (int, error) {
+	cw.buf.Reset()
+	out := p
+	if Colorize(&cw.buf, p, &cw.Scheme) == nil {
+		out = cw.buf.Bytes()
+	}
+	if _, err := cw.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,46 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"gopkg.in/check.v1"
+	"strings"
+)
+
+type ColorTests struct{}
+
+func init() { check.Suite(&ColorTests{}) }
+
+// brackets marks each colored element with the first letter of its
+// kind, to keep the expected outputs readable.
+var brackets = ColorScheme{
+	Keyword: "<k>", String: "<s>", Char: "<c>", Number: "<n>", Literal: "<l>", Tag: "<t>",
+}
+
+func (*ColorTests) TestColorize(c *check.C) {
+	src := "{:a \"x y\", b [1 -2.5 \\c nil ##Inf]} ; note\n#inst \"t\" ^:m sym"
+	var buf bytes.Buffer
+	c.Assert(Colorize(&buf, []byte(src), &brackets), check.IsNil)
+	want := "{<k>:a$ <s>\"x y\"$, b [<n>1$ <n>-2.5$ <c>\\c$ <l>nil$ <l>##Inf$]} ; note\n<t>#inst$ <s>\"t\"$ ^<k>:m$ sym"
+	c.Check(buf.String(), check.Equals, strings.Replace(want, "$", colorReset, -1))
+
+	buf.Reset()
+	c.Check(Colorize(&buf, []byte(`"open`), &brackets), check.ErrorMatches, "edn: unterminated string")
+}
+
+func (*ColorTests) TestColorWriter(c *check.C) {
+	var buf bytes.Buffer
+	cw := NewColorWriter(&buf)
+	cw.Scheme = ColorScheme{Keyword: "<k>"}
+	enc := NewEncoder(cw)
+	c.Assert(enc.Encode(KMap{"a": "b"}), check.IsNil)
+	c.Check(buf.String(), check.Equals, "{<k>:a"+colorReset+" \"b\"}\n")
+
+	buf.Reset()
+	n, err := cw.Write([]byte(`"open`))
+	c.Check(n, check.Equals, 5)
+	c.Check(err, check.IsNil)
+	c.Check(buf.String(), check.Equals, `"open`)
+}
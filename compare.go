@@ -0,0 +1,243 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Compare returns -1, 0 or +1 as a is less than, equal to, or greater
+// than b in a total order over EDN values. Within a kind of value, the
+// order follows Clojure's compare:
+//
+//   - Numbers of any Go type are ordered by value; NaN sorts after
+//     every other number. Equal integers, floats and decimals are
+//     ordered in that sequence, so that only Equal numbers compare 0.
+//   - Strings, and the text of UUIDs, are ordered bytewise.
+//   - Keywords and symbols are ordered by namespace, no namespace
+//     first, and then by name.
+//   - Vectors and lists are ordered by length, and then element by
+//     element.
+//   - Instants are ordered in time, and false comes before true.
+//
+// Values of different kinds, which Clojure cannot compare, are ordered
+// nil, booleans, numbers, characters, strings, symbols, keywords,
+// instants, UUIDs, byte strings, vectors and lists, sets, maps, tagged
+// elements, and then everything else. Sets and maps are ordered by
+// size, and then by their elements or entries in sorted order. Other
+// values are ordered by type name and then by their EDN encoding.
+//
+// Compare returns 0 for values that are Equal. Apart from NaN, which
+// compares equal to itself, and values of other kinds with the same
+// encoding, it returns 0 for no others.
+func Compare(a, b interface{}) int {
+	return compareValues(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+// SortValues sorts vs in place in the order of Compare.
+func SortValues(vs []interface{}) {
+	sort.SliceStable(vs, func(i, j int) bool { return Compare(vs[i], vs[j]) < 0 })
+}
+
+// compareRank orders the classes of values that Compare cannot compare
+// by content. Numbers share a rank.
+func compareRank(v reflect.Value) int {
+	if !v.IsValid() {
+		return 0
+	}
+	switch classOf(v) {
+	case classBool:
+		return 1
+	case classInt, classFloat, classDecimal:
+		return 2
+	case classChar:
+		return 3
+	case classString:
+		return 4
+	case classSymbol:
+		return 5
+	case classKeyword:
+		return 6
+	case classInst:
+		return 7
+	case classUUID:
+		return 8
+	case classBytes:
+		return 9
+	case classSeq:
+		return 10
+	case classSet:
+		return 11
+	case classMap:
+		return 12
+	case classTagged:
+		return 13
+	}
+	return 14
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func compareValues(a, b reflect.Value) int {
+	a, b = ednValue(a), ednValue(b)
+	ra, rb := compareRank(a), compareRank(b)
+	if ra != rb {
+		return sign(ra - rb)
+	}
+	if !a.IsValid() {
+		return 0
+	}
+	switch ca, cb := classOf(a), classOf(b); ca {
+	case classBool:
+		return sign(boolInt(a.Bool()) - boolInt(b.Bool()))
+	case classInt, classFloat, classDecimal:
+		if c := compareNumbers(a, ca, b, cb); c != 0 {
+			return c
+		}
+		return sign(int(ca) - int(cb))
+	case classChar:
+		return sign(int(a.Int() - b.Int()))
+	case classString:
+		return strings.Compare(a.String(), b.String())
+	case classSymbol:
+		return compareNamed(a.String(), b.String())
+	case classKeyword:
+		return compareNamed(strings.TrimPrefix(a.String(), ":"), strings.TrimPrefix(b.String(), ":"))
+	case classInst:
+		return instTime(a).Compare(instTime(b))
+	case classUUID:
+		return strings.Compare(a.Interface().(fmt.Stringer).String(), b.Interface().(fmt.Stringer).String())
+	case classBytes:
+		return bytes.Compare(a.Bytes(), b.Bytes())
+	case classSeq:
+		if a.Len() != b.Len() {
+			return sign(a.Len() - b.Len())
+		}
+		for i := 0; i < a.Len(); i++ {
+			if c := compareValues(a.Index(i), b.Index(i)); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case classSet:
+		if a.Len() != b.Len() {
+			return sign(a.Len() - b.Len())
+		}
+		return compareSorted(sortedValues(a.MapKeys()), sortedValues(b.MapKeys()))
+	case classMap:
+		ea, eb := sortedEntries(a), sortedEntries(b)
+		if len(ea) != len(eb) {
+			return sign(len(ea) - len(eb))
+		}
+		for i := range ea {
+			if c := compareValues(ea[i].k, eb[i].k); c != 0 {
+				return c
+			}
+			if c := compareValues(ea[i].v, eb[i].v); c != 0 {
+				return c
+			}
+		}
+		return 0
+	case classTagged:
+		ta, tb := a.Interface().(Tagged), b.Interface().(Tagged)
+		if c := strings.Compare(strings.TrimPrefix(ta.Tag, "#"), strings.TrimPrefix(tb.Tag, "#")); c != 0 {
+			return c
+		}
+		return Compare(ta.Value, tb.Value)
+	}
+	if c := strings.Compare(a.Type().String(), b.Type().String()); c != 0 {
+		return c
+	}
+	if a.Type() == b.Type() && a.CanInterface() && b.CanInterface() && reflect.DeepEqual(a.Interface(), b.Interface()) {
+		return 0
+	}
+	return strings.Compare(otherText(a), otherText(b))
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// compareNumbers compares two numbers of classes ca and cb by value.
+func compareNumbers(a reflect.Value, ca valueClass, b reflect.Value, cb valueClass) int {
+	if ca == classInt && cb == classInt {
+		return bigIntOf(a).Cmp(bigIntOf(b))
+	}
+	nanA := ca == classFloat && math.IsNaN(a.Float())
+	nanB := cb == classFloat && math.IsNaN(b.Float())
+	if nanA || nanB {
+		return sign(boolInt(nanA) - boolInt(nanB))
+	}
+	return bigFloatOfNumber(a, ca).Cmp(bigFloatOfNumber(b, cb))
+}
+
+// bigFloatOfNumber returns the exact value of a number as a big.Float.
+func bigFloatOfNumber(v reflect.Value, class valueClass) *big.Float {
+	switch class {
+	case classInt:
+		x := bigIntOf(v)
+		return new(big.Float).SetPrec(uint(max(x.BitLen(), 1))).SetInt(x)
+	case classFloat:
+		return big.NewFloat(v.Float())
+	}
+	return bigFloatOf(v)
+}
+
+// compareNamed compares keywords or symbols, without colons, by
+// namespace and then name.
+func compareNamed(a, b string) int {
+	nsa, namea := splitKeyword(a)
+	nsb, nameb := splitKeyword(b)
+	if c := strings.Compare(nsa, nsb); c != 0 {
+		return c
+	}
+	return strings.Compare(namea, nameb)
+}
+
+func sortedValues(vs []reflect.Value) []reflect.Value {
+	sort.SliceStable(vs, func(i, j int) bool { return compareValues(vs[i], vs[j]) < 0 })
+	return vs
+}
+
+func compareSorted(a, b []reflect.Value) int {
+	for i := range a {
+		if c := compareValues(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func sortedEntries(v reflect.Value) []mapEntry {
+	ents := mapEntries(v)
+	sort.SliceStable(ents, func(i, j int) bool { return compareValues(ents[i].k, ents[j].k) < 0 })
+	return ents
+}
+
+// otherText returns the text Compare orders values of other kinds by.
+func otherText(v reflect.Value) string {
+	if v.CanInterface() {
+		if b, err := MarshalCanonical(v.Interface()); err == nil {
+			return string(b)
+		}
+	}
+	return fmt.Sprintf("%#v", v)
+}
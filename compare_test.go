@@ -0,0 +1,60 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+	"math"
+	"math/big"
+	"time"
+)
+
+type CompareTests struct{}
+
+func init() { check.Suite(&CompareTests{}) }
+
+func (*CompareTests) TestCompare(c *check.C) {
+	t := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	// Each value is less than the ones after it.
+	ordered := []interface{}{
+		nil,
+		false, true,
+		math.Inf(-1), -2, big.NewInt(-1), 0, 1, 1.0, big.NewFloat(1), 1.5, uint64(math.MaxUint64), math.Inf(1), math.NaN(),
+		Char('a'), Char('b'),
+		"", "a", "ab", "b",
+		S("b"), S("a/a"), S("a/b"),
+		K("z"), K(":a/z"), K("b/a"),
+		t, t.Add(time.Second),
+		UUID{1}, UUID{2},
+		[]byte{1}, []byte{1, 0},
+		[]int{9}, Vec{1, 2}, List{1, 3},
+		Set{}, NewSet(3), NewSet(1, 2), NewSet(1, 3),
+		KMap{"a": 2}, map[Keyword]int{"b": 1}, KMap{"a": 1, "b": 1},
+		Tagged{"a", 2}, Tagged{"b", 1},
+		Point{1, 2}, Point{2, 1},
+	}
+	for i, a := range ordered {
+		c.Check(Compare(a, a), check.Equals, 0, check.Commentf("%#v", a))
+		for _, b := range ordered[i+1:] {
+			c.Check(Compare(a, b), check.Equals, -1, check.Commentf("%#v < %#v", a, b))
+			c.Check(Compare(b, a), check.Equals, 1, check.Commentf("%#v > %#v", b, a))
+		}
+	}
+	for _, p := range [][2]interface{}{
+		{1, uint8(1)},
+		{float32(0.5), 0.5},
+		{K("a"), K(":a")},
+		{[]int{1}, List{int64(1)}},
+		{NewSet(1, 2), NewSetOf(2, 1)},
+		{KMap{"a": 1}, map[interface{}]int{K("a"): 1}},
+	} {
+		c.Check(Compare(p[0], p[1]), check.Equals, 0, check.Commentf("%#v == %#v", p[0], p[1]))
+	}
+}
+
+func (*CompareTests) TestSortValues(c *check.C) {
+	vs := []interface{}{K("b"), "x", 2, nil, 1.5, K("a"), []int{1}, true}
+	SortValues(vs)
+	c.Check(vs, check.DeepEquals, []interface{}{nil, true, 1.5, 2, "x", K("a"), K("b"), []int{1}})
+}
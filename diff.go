@@ -0,0 +1,294 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Change is one step of the difference between two EDN values, as
+// Diff reports it. Path addresses the changed value from the root, as
+// in GetIn: a map key, a vector or list index, or a set element per
+// step. Changes marshal as maps such as
+//
+//	{:op :replace, :path [:db :port], :old 5432, :new 6543}
+//
+// Old and New are always written, as nil where the operation has none,
+// so that a change to or from nil is not mistaken for a missing value.
+type Change struct {
+	Op   Keyword       `edn:"op"` // OpAdd, OpRemove or OpReplace
+	Path []interface{} `edn:"path"`
+	Old  interface{}   `edn:"old"`
+	New  interface{}   `edn:"new"`
+}
+
+// The operations of a Change.
+const (
+	OpAdd     = Keyword("add")     // New is added at Path
+	OpRemove  = Keyword("remove")  // Old is removed from Path
+	OpReplace = Keyword("replace") // Old at Path is replaced by New
+)
+
+// Diff returns the changes that turn a into b, or none if they are
+// Equal. Maps are compared key by key and sets element by element;
+// vectors and lists are compared index by index, with elements added
+// or removed at the end. Where a and b differ otherwise, the change
+// replaces the whole value.
+//
+// Changes are ordered so that ApplyPatch can apply them in turn: the
+// entries of maps are visited in the order of Compare on their keys,
+// and elements are removed from the end of a sequence first.
+func Diff(a, b interface{}) []Change {
+	return diff(nil, a, b, nil)
+}
+
+func diff(path []interface{}, a, b interface{}, changes []Change) []Change {
+	if Equal(a, b) {
+		return changes
+	}
+	switch {
+	case isDiffMap(a) && isDiffMap(b):
+		for _, k := range sortedKeys(a, b) {
+			x, inA := get(a, k)
+			y, inB := get(b, k)
+			p := appendPath(path, k)
+			switch {
+			case !inB:
+				changes = append(changes, Change{Op: OpRemove, Path: p, Old: x})
+			case !inA:
+				changes = append(changes, Change{Op: OpAdd, Path: p, New: y})
+			default:
+				changes = diff(p, x, y, changes)
+			}
+		}
+		return changes
+	case isDiffSeq(a) && isDiffSeq(b):
+		sa, sb := seqOf(a), seqOf(b)
+		n := min(len(sa), len(sb))
+		for i := 0; i < n; i++ {
+			changes = diff(appendPath(path, i), sa[i], sb[i], changes)
+		}
+		for i := len(sa) - 1; i >= n; i-- {
+			changes = append(changes, Change{Op: OpRemove, Path: appendPath(path, i), Old: sa[i]})
+		}
+		for i := n; i < len(sb); i++ {
+			changes = append(changes, Change{Op: OpAdd, Path: appendPath(path, i), New: sb[i]})
+		}
+		return changes
+	}
+	sa, okA := a.(Set)
+	sb, okB := b.(Set)
+	if okA && okB && sa != nil && sb != nil {
+		for _, x := range sa.Slice(true) {
+			if _, ok := get(sb, x); !ok {
+				changes = append(changes, Change{Op: OpRemove, Path: appendPath(path, x), Old: x})
+			}
+		}
+		for _, x := range sb.Slice(true) {
+			if _, ok := get(sa, x); !ok {
+				changes = append(changes, Change{Op: OpAdd, Path: appendPath(path, x), New: x})
+			}
+		}
+		return changes
+	}
+	return append(changes, Change{Op: OpReplace, Path: appendPath(path), Old: a, New: b})
+}
+
+// appendPath returns a copy of path with steps added, so that no two
+// changes share a path's backing array.
+func appendPath(path []interface{}, steps ...interface{}) []interface{} {
+	p := make([]interface{}, 0, len(path)+len(steps))
+	return append(append(p, path...), steps...)
+}
+
+// isDiffMap reports whether Diff compares v key by key.
+func isDiffMap(v interface{}) bool {
+	switch v := v.(type) {
+	case map[interface{}]interface{}, KMap, SMap, map[string]interface{}, HashMap, OrderedMap:
+		return !isNilMap(v)
+	case *HashMap, *OrderedMap:
+		return !isNilMap(v)
+	}
+	return false
+}
+
+// isDiffSeq reports whether Diff compares v index by index.
+func isDiffSeq(v interface{}) bool {
+	switch v := v.(type) {
+	case []interface{}:
+		return v != nil
+	case Vec:
+		return v != nil
+	case List:
+		return v != nil
+	}
+	return false
+}
+
+func seqOf(v interface{}) []interface{} {
+	switch v := v.(type) {
+	case Vec:
+		return v
+	case List:
+		return v
+	}
+	return v.([]interface{})
+}
+
+// sortedKeys returns the keys of maps a and b, the keys of a first
+// where both have Equal keys, in the order of Compare.
+func sortedKeys(a, b interface{}) []interface{} {
+	var keys []interface{}
+	for _, ent := range mergeEntries(a) {
+		keys = append(keys, ent.k)
+	}
+	for _, ent := range mergeEntries(b) {
+		if _, ok := get(a, ent.k); !ok {
+			keys = append(keys, ent.k)
+		}
+	}
+	sort.SliceStable(keys, func(i, j int) bool { return Compare(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+// ApplyPatch returns v with changes, as made by Diff, applied in turn.
+// Like AssocIn, it copies the collections along each change's path and
+// leaves v unchanged.
+//
+// ApplyPatch checks only that each path can be followed, so a patch
+// made against a different value applies where it fits. It fails if a
+// path cannot be followed, if an index to remove is out of range, or if
+// a change has an unknown Op.
+func ApplyPatch(v interface{}, changes []Change) (interface{}, error) {
+	for _, ch := range changes {
+		var err error
+		if v, err = applyChange(v, ch); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func applyChange(v interface{}, ch Change) (interface{}, error) {
+	op := Keyword(strings.TrimPrefix(string(ch.Op), ":"))
+	if len(ch.Path) == 0 {
+		switch op {
+		case OpAdd, OpReplace:
+			return ch.New, nil
+		case OpRemove:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("edn: unknown patch op %s", ch.Op)
+	}
+	dir, k := ch.Path[:len(ch.Path)-1], ch.Path[len(ch.Path)-1]
+	parent, ok := GetIn(v, dir...)
+	if !ok {
+		b, _ := MarshalCanonical(dir)
+		return nil, fmt.Errorf("edn: cannot follow patch path %s", b)
+	}
+	var err error
+	switch op {
+	case OpAdd, OpReplace:
+		if s, ok := parent.(Set); ok {
+			parent = s.Clone().Add(ch.New)
+		} else {
+			parent, err = assoc(parent, k, ch.New)
+		}
+	case OpRemove:
+		parent, err = dissoc(parent, k)
+	default:
+		return nil, fmt.Errorf("edn: unknown patch op %s", ch.Op)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return AssocIn(v, dir, parent)
+}
+
+// dissoc returns a copy of coll without the element at key k.
+func dissoc(coll, k interface{}) (interface{}, error) {
+	switch c := coll.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[interface{}]interface{}, len(c))
+		for mk, mx := range c {
+			m[mk] = mx
+		}
+		if mk, ok := findKey(c, k); ok {
+			delete(m, mk)
+		}
+		return m, nil
+	case KMap:
+		if s, ok := stringKeyOf(k, keywordType); ok {
+			return KMap(dissocString(c, s)), nil
+		}
+	case SMap:
+		if s, ok := stringKeyOf(k, symbolType); ok {
+			return SMap(dissocString(c, s)), nil
+		}
+	case map[string]interface{}:
+		if s, ok := stringKeyOf(k, nil); ok {
+			return dissocString(c, s), nil
+		}
+	case HashMap:
+		m := c.clone()
+		m.Delete(k)
+		return m, nil
+	case *HashMap:
+		m := c.clone()
+		m.Delete(k)
+		return &m, nil
+	case OrderedMap:
+		m := c.clone()
+		if isComparable(k) {
+			m.Delete(k)
+		}
+		return m, nil
+	case *OrderedMap:
+		m := c.clone()
+		if isComparable(k) {
+			m.Delete(k)
+		}
+		return &m, nil
+	case Set:
+		s := make(Set, len(c))
+		for x := range c {
+			if !Equal(x, k) {
+				s[x] = struct{}{}
+			}
+		}
+		return s, nil
+	case []interface{}:
+		return dissocIndex(c, k)
+	case Vec:
+		s, err := dissocIndex(c, k)
+		return Vec(s), err
+	case List:
+		s, err := dissocIndex(c, k)
+		return List(s), err
+	}
+	return nil, fmt.Errorf("edn: cannot remove key %#v from %T", k, coll)
+}
+
+func dissocString(c map[string]interface{}, k string) map[string]interface{} {
+	m := make(map[string]interface{}, len(c))
+	for mk, mx := range c {
+		m[mk] = mx
+	}
+	delete(m, k)
+	return m
+}
+
+func dissocIndex(c []interface{}, k interface{}) ([]interface{}, error) {
+	i, ok := seqIndex(k)
+	if !ok {
+		return nil, fmt.Errorf("edn: cannot use %#v as an index", k)
+	}
+	if i < 0 || i >= len(c) {
+		return nil, fmt.Errorf("edn: index %d out of range for length %d", i, len(c))
+	}
+	s := make([]interface{}, 0, len(c)-1)
+	return append(append(s, c[:i]...), c[i+1:]...), nil
+}
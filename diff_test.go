@@ -0,0 +1,103 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type DiffTests struct{}
+
+func init() { check.Suite(&DiffTests{}) }
+
+func (*DiffTests) TestDiff(c *check.C) {
+	a := KMap{
+		"db":    KMap{"host": "localhost", "port": 5432},
+		"tags":  NewSet(K("a"), K("b")),
+		"hosts": Vec{"x", "y", "z"},
+		"old":   true,
+	}
+	b := map[interface{}]interface{}{
+		K("db"):    KMap{"host": "localhost", "port": 6543},
+		K("tags"):  NewSet(K("b"), K("c")),
+		K("hosts"): Vec{"x", "w"},
+		K("new"):   nil,
+	}
+	c.Check(Diff(a, b), check.DeepEquals, []Change{
+		{Op: OpReplace, Path: []interface{}{K("db"), K("port")}, Old: 5432, New: 6543},
+		{Op: OpReplace, Path: []interface{}{K("hosts"), 1}, Old: "y", New: "w"},
+		{Op: OpRemove, Path: []interface{}{K("hosts"), 2}, Old: "z"},
+		{Op: OpAdd, Path: []interface{}{K("new")}},
+		{Op: OpRemove, Path: []interface{}{K("old")}, Old: true},
+		{Op: OpRemove, Path: []interface{}{K("tags"), K("a")}, Old: K("a")},
+		{Op: OpAdd, Path: []interface{}{K("tags"), K("c")}, New: K("c")},
+	})
+
+	c.Check(Diff(Vec{1, 2}, []int{1, 2}), check.HasLen, 0)
+	c.Check(Diff(List{1}, List{1, 2, 3}), check.DeepEquals, []Change{
+		{Op: OpAdd, Path: []interface{}{1}, New: 2},
+		{Op: OpAdd, Path: []interface{}{2}, New: 3},
+	})
+	c.Check(Diff(1, "1"), check.DeepEquals, []Change{
+		{Op: OpReplace, Path: []interface{}{}, Old: 1, New: "1"},
+	})
+	c.Check(Diff(KMap{"a": 1}, Vec{1}), check.DeepEquals, []Change{
+		{Op: OpReplace, Path: []interface{}{}, Old: KMap{"a": 1}, New: Vec{1}},
+	})
+}
+
+func (*DiffTests) TestDiffHashMap(c *check.C) {
+	var a, b HashMap
+	a.Set(Vec{1, 2}, "pair")
+	a.Set(K("x"), 1)
+	b.Set(Vec{1, 2}, "twin")
+	c.Check(Diff(&a, &b), check.DeepEquals, []Change{
+		{Op: OpRemove, Path: []interface{}{K("x")}, Old: 1},
+		{Op: OpReplace, Path: []interface{}{Vec{1, 2}}, Old: "pair", New: "twin"},
+	})
+}
+
+func (*DiffTests) TestMarshalChange(c *check.C) {
+	checkMarshal(
+		c,
+		pair{Change{Op: OpReplace, Path: []interface{}{K("db"), K("port")}, Old: 5432, New: 6543},
+			`{:op :replace, :path [:db :port], :old 5432, :new 6543}`},
+		pair{Change{Op: OpRemove, Path: []interface{}{0}, Old: "x"}, `{:op :remove, :path [0], :old "x", :new nil}`},
+		pair{Change{Op: OpAdd, Path: []interface{}{K("m"), K("y")}}, `{:op :add, :path [:m :y], :old nil, :new nil}`},
+		pair{Change{Op: OpReplace, Path: []interface{}{K("a")}, Old: 1}, `{:op :replace, :path [:a], :old 1, :new nil}`},
+	)
+}
+
+func (*DiffTests) TestApplyPatch(c *check.C) {
+	var hm HashMap
+	hm.Set(Vec{1, 2}, "pair")
+	for _, t := range []struct{ a, b interface{} }{
+		{
+			KMap{"db": KMap{"port": 5432}, "tags": NewSet(K("a")), "hosts": Vec{"x", "y", "z"}, "old": 1},
+			KMap{"db": KMap{"port": 6543}, "tags": NewSet(K("b")), "hosts": List{"w"}, "new": 2},
+		},
+		{Vec{1, Vec{2, 3}}, Vec{1, Vec{2}, 4}},
+		{&hm, map[interface{}]interface{}{"k": 1}},
+		{nil, KMap{"a": 1}},
+		{KMap{"a": 1}, nil},
+	} {
+		before, _ := MarshalCanonical(t.a)
+		got, err := ApplyPatch(t.a, Diff(t.a, t.b))
+		c.Assert(err, check.IsNil)
+		c.Check(Equal(got, t.b), check.Equals, true, check.Commentf("%#v", got))
+		after, _ := MarshalCanonical(t.a)
+		c.Check(string(after), check.Equals, string(before))
+	}
+
+	got, err := ApplyPatch(KMap{"a": 1}, []Change{{Op: K(":remove"), Path: []interface{}{K("a")}}})
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, KMap{})
+
+	_, err = ApplyPatch(Vec{1}, []Change{{Op: OpRemove, Path: []interface{}{3}}})
+	c.Check(err, check.ErrorMatches, `edn: index 3 out of range for length 1`)
+	_, err = ApplyPatch(Vec{1}, []Change{{Op: OpAdd, Path: []interface{}{K("x"), 0}}})
+	c.Check(err, check.ErrorMatches, `edn: cannot follow patch path \[:x\]`)
+	_, err = ApplyPatch(Vec{1}, []Change{{Op: K("move"), Path: []interface{}{0}}})
+	c.Check(err, check.ErrorMatches, `edn: unknown patch op move`)
+}
@@ -0,0 +1,278 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// A Document is EDN text that can be edited in place, such as a
+// hand-maintained .edn configuration file. Edits replace, add or remove
+// only the text of the values they touch; comments, whitespace,
+// discarded forms and the spelling of everything else are kept byte
+// for byte.
+//
+// Values are addressed by paths, as in GetIn, starting from the first
+// form of the text: a map key or set element per step, matched by its
+// EDN encoding, or a vector or list index. Keys in the text are matched
+// by their compacted spelling, so K("port") finds :port but 8080 does
+// not find 0x1F90.
+type Document struct {
+	src []byte
+}
+
+// ParseDocument returns a Document holding a copy of src. It fails if
+// src is not well-formed EDN.
+func ParseDocument(src []byte) (*Document, error) {
+	if err := Compact(new(bytes.Buffer), src); err != nil {
+		return nil, err
+	}
+	return &Document{append([]byte(nil), src...)}, nil
+}
+
+// Bytes returns the text of d. It is valid until the next edit.
+func (d *Document) Bytes() []byte {
+	return d.src
+}
+
+// Get returns the text of the value at path, as spelled in d, and
+// whether there is one.
+func (d *Document) Get(path ...interface{}) ([]byte, bool) {
+	sp, err := d.find(path)
+	if err != nil {
+		return nil, false
+	}
+	return d.src[sp.start:sp.end], true
+}
+
+// Set sets the value at path to v, written as Marshal writes it. If
+// the last step of the path is a key missing from its map, or an index
+// one past the end of its vector or list, the entry or element is
+// added at the end, on a line of its own if the previous one is on a
+// line of its own. Set fails if the rest of the path cannot be
+// followed, or v cannot be encoded.
+func (d *Document) Set(path []interface{}, v interface{}) error {
+	b, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	if sp, err := d.find(path); err == nil {
+		d.splice(sp.start, sp.end, b)
+		return nil
+	}
+	if len(path) == 0 {
+		return fmt.Errorf("edn: document is empty")
+	}
+	coll, err := d.find(path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	elems, err := d.elems(coll)
+	if err != nil {
+		return err
+	}
+	k := path[len(path)-1]
+	if isMapOpen(coll.open) {
+		kb, err := Marshal(k)
+		if err != nil {
+			return err
+		}
+		b = append(append(kb, ' '), b...)
+	} else if i, ok := seqIndex(k); !ok || i != len(elems) || string(coll.open) == "#{" {
+		return fmt.Errorf("edn: cannot add %s to %s", docKey(k), coll.open)
+	}
+	d.insert(coll, elems, b)
+	return nil
+}
+
+// Delete removes the value at path, along with its key if it is in a
+// map, and the whitespace before it. It does nothing if there is no
+// such value.
+func (d *Document) Delete(path ...interface{}) error {
+	if len(path) == 0 {
+		return fmt.Errorf("edn: cannot delete the whole document")
+	}
+	coll, err := d.find(path[:len(path)-1])
+	if err != nil {
+		return nil
+	}
+	elems, err := d.elems(coll)
+	if err != nil {
+		return nil
+	}
+	i, n, ok := d.lookup(coll, elems, path[len(path)-1])
+	if !ok {
+		return nil
+	}
+	start, end := elems[i].start, elems[i+n-1].end
+	switch {
+	case i > 0:
+		start = elems[i-1].end
+	case i+n < len(elems):
+		end = elems[i+n].start
+	}
+	d.splice(start, end, nil)
+	return nil
+}
+
+// A docSpan is the extent of a form in a Document's text.
+type docSpan struct {
+	start, end int
+	open       []byte // opening delimiter, if the form is a collection
+}
+
+func (d *Document) skipper() *compactor {
+	return &compactor{dst: new(bytes.Buffer), src: d.src, skip: 1}
+}
+
+// span returns the extent of the form at or after src[off], skipping
+// discarded forms before it.
+func (d *Document) span(off int) (docSpan, error) {
+	c := d.skipper()
+	tok, _, err := c.token(off)
+	if err != nil {
+		return docSpan{}, err
+	}
+	end, err := c.form(off)
+	if err != nil {
+		return docSpan{}, err
+	}
+	sp := docSpan{start: tok.off, end: end}
+	if tok.kind == tokOpen {
+		sp.open = tok.text
+	}
+	return sp, nil
+}
+
+// elems returns the extents of the elements of the collection coll.
+func (d *Document) elems(coll docSpan) ([]docSpan, error) {
+	if coll.open == nil {
+		return nil, fmt.Errorf("edn: %s is not a collection", d.src[coll.start:coll.end])
+	}
+	var elems []docSpan
+	c := d.skipper()
+	for off := coll.start + len(coll.open); ; {
+		tok, _, err := c.token(off)
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind == tokClose {
+			return elems, nil
+		}
+		sp, err := d.span(off)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, sp)
+		off = sp.end
+	}
+}
+
+// find returns the extent of the value at path.
+func (d *Document) find(path []interface{}) (docSpan, error) {
+	sp, err := d.span(0)
+	if err == io.EOF {
+		return sp, fmt.Errorf("edn: document is empty")
+	}
+	for _, k := range path {
+		if err != nil {
+			break
+		}
+		var elems []docSpan
+		if elems, err = d.elems(sp); err != nil {
+			break
+		}
+		i, n, ok := d.lookup(sp, elems, k)
+		if !ok {
+			return sp, fmt.Errorf("edn: no element %s in %s", docKey(k), sp.open)
+		}
+		sp = elems[i+n-1]
+	}
+	return sp, err
+}
+
+// lookup returns the index of the element of coll that key k names,
+// the number of elements it spans, which is 2 for a map entry, and
+// whether there is one.
+func (d *Document) lookup(coll docSpan, elems []docSpan, k interface{}) (i, n int, ok bool) {
+	if o := string(coll.open); o == "(" || o == "[" {
+		i, ok := seqIndex(k)
+		return i, 1, ok && 0 <= i && i < len(elems)
+	}
+	want, err := compactText(Marshal(k))
+	if err != nil {
+		return 0, 0, false
+	}
+	n = 1
+	if isMapOpen(coll.open) {
+		n = 2
+	}
+	for i := 0; i+n <= len(elems); i += n {
+		if text, err := compactText(d.src[elems[i].start:elems[i].end], nil); err == nil && text == want {
+			return i, n, true
+		}
+	}
+	return 0, 0, false
+}
+
+func compactText(b []byte, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := Compact(&buf, b); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// docKey returns k as written in error messages.
+func docKey(k interface{}) string {
+	if b, err := Marshal(k); err == nil {
+		return string(b)
+	}
+	return fmt.Sprintf("%#v", k)
+}
+
+func isMapOpen(open []byte) bool {
+	return bytes.HasSuffix(open, []byte("{")) && string(open) != "#{"
+}
+
+// insert adds text b as the last element of the collection coll,
+// laid out like the element before it. Map entries are separated by
+// commas unless the entries already there are not.
+func (d *Document) insert(coll docSpan, elems []docSpan, b []byte) {
+	if len(elems) == 0 {
+		at := coll.start + len(coll.open)
+		d.splice(at, at, b)
+		return
+	}
+	isMap := isMapOpen(coll.open)
+	prev := elems[len(elems)-1]
+	if isMap {
+		prev = elems[len(elems)-2] // the key of the last entry
+	}
+	sep := []byte(" ")
+	if nl := bytes.LastIndexByte(d.src[:prev.start], '\n'); nl > coll.start {
+		indent := d.src[nl+1 : prev.start]
+		if len(bytes.Trim(indent, " \t,")) == 0 {
+			sep = append([]byte("\n"), bytes.ReplaceAll(indent, []byte(","), nil)...)
+		}
+	}
+	if isMap && (len(elems) == 2 || bytes.Contains(d.src[elems[len(elems)-3].end:prev.start], []byte(","))) {
+		sep = append([]byte(","), sep...)
+	}
+	at := elems[len(elems)-1].end
+	d.splice(at, at, append(sep, b...))
+}
+
+// splice replaces src[start:end] with b.
+func (d *Document) splice(start, end int, b []byte) {
+	src := make([]byte, 0, len(d.src)-(end-start)+len(b))
+	src = append(src, d.src[:start]...)
+	src = append(src, b...)
+	d.src = append(src, d.src[end:]...)
+}
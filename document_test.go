@@ -0,0 +1,105 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type DocumentTests struct{}
+
+func init() { check.Suite(&DocumentTests{}) }
+
+const docConfig = `;; service settings
+{:db {:host "localhost" ; the primary
+      :port 0x1538}
+ #_ :old #_ 1
+ :workers [1 2 3]
+ :tags #{:a :b}}
+`
+
+func (*DocumentTests) TestGet(c *check.C) {
+	d, err := ParseDocument([]byte(docConfig))
+	c.Assert(err, check.IsNil)
+	for _, t := range []struct {
+		path []interface{}
+		want string
+	}{
+		{[]interface{}{K("db"), K(":port")}, `0x1538`},
+		{[]interface{}{K("workers"), 2}, `3`},
+		{[]interface{}{K("tags"), K("b")}, `:b`},
+		{[]interface{}{K("db")}, "{:host \"localhost\" ; the primary\n      :port 0x1538}"},
+	} {
+		got, ok := d.Get(t.path...)
+		c.Check(ok, check.Equals, true, check.Commentf("%v", t.path))
+		c.Check(string(got), check.Equals, t.want, check.Commentf("%v", t.path))
+	}
+	for _, path := range [][]interface{}{
+		{K("old")},
+		{K("workers"), 3},
+		{K("db"), K("host"), 0},
+		{"db"},
+	} {
+		_, ok := d.Get(path...)
+		c.Check(ok, check.Equals, false, check.Commentf("%v", path))
+	}
+}
+
+func (*DocumentTests) TestSet(c *check.C) {
+	d, err := ParseDocument([]byte(docConfig))
+	c.Assert(err, check.IsNil)
+	c.Assert(d.Set([]interface{}{K("db"), K("port")}, 6543), check.IsNil)
+	c.Assert(d.Set([]interface{}{K("db"), K("user")}, "app"), check.IsNil)
+	c.Assert(d.Set([]interface{}{K("workers"), 0}, 10), check.IsNil)
+	c.Assert(d.Set([]interface{}{K("workers"), 3}, 4), check.IsNil)
+	c.Assert(d.Set([]interface{}{K("limits")}, KMap{"cpu": 2}), check.IsNil)
+	c.Check(string(d.Bytes()), check.Equals, `;; service settings
+{:db {:host "localhost" ; the primary
+      :port 6543
+      :user "app"}
+ #_ :old #_ 1
+ :workers [10 2 3 4]
+ :tags #{:a :b}
+ :limits {:cpu 2}}
+`)
+
+	c.Check(d.Set([]interface{}{K("workers"), 9}, 1), check.ErrorMatches, `edn: cannot add 9 to \[`)
+	c.Check(d.Set([]interface{}{K("nope"), K("x")}, 1), check.ErrorMatches, `edn: no element :nope in \{`)
+	c.Check(d.Set([]interface{}{K("tags"), K("c")}, K("c")), check.ErrorMatches, `edn: cannot add :c to #\{`)
+
+	d, err = ParseDocument([]byte(`{:a 1, :b 2}`))
+	c.Assert(err, check.IsNil)
+	c.Assert(d.Set([]interface{}{"c"}, nil), check.IsNil)
+	c.Check(string(d.Bytes()), check.Equals, `{:a 1, :b 2, "c" nil}`)
+
+	d, err = ParseDocument([]byte(`{}`))
+	c.Assert(err, check.IsNil)
+	c.Assert(d.Set([]interface{}{K("a")}, 1), check.IsNil)
+	c.Assert(d.Set([]interface{}{K("b")}, 2), check.IsNil)
+	c.Check(string(d.Bytes()), check.Equals, `{:a 1, :b 2}`)
+
+	d, err = ParseDocument([]byte(" ; nothing\n"))
+	c.Assert(err, check.IsNil)
+	c.Check(d.Set(nil, 1), check.ErrorMatches, `edn: document is empty`)
+}
+
+func (*DocumentTests) TestDelete(c *check.C) {
+	d, err := ParseDocument([]byte(docConfig))
+	c.Assert(err, check.IsNil)
+	c.Assert(d.Delete(K("db"), K("host")), check.IsNil)
+	c.Assert(d.Delete(K("workers"), 1), check.IsNil)
+	c.Assert(d.Delete(K("tags")), check.IsNil)
+	c.Assert(d.Delete(K("missing")), check.IsNil)
+	c.Check(string(d.Bytes()), check.Equals, `;; service settings
+{:db {:port 0x1538}
+ #_ :old #_ 1
+ :workers [1 3]}
+`)
+	c.Check(d.Delete(), check.ErrorMatches, `edn: cannot delete the whole document`)
+}
+
+func (*DocumentTests) TestParseDocumentError(c *check.C) {
+	_, err := ParseDocument([]byte(`{:a [1}`))
+	c.Check(err, check.ErrorMatches, `edn: \[ closed by }`)
+}
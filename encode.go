@@ -10,18 +10,25 @@ package edn
 
 import (
 	"bytes"
-	"code.google.com/p/go-uuid/uuid"
 	"container/list"
+	"container/ring"
 	"encoding"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/big"
 	"reflect"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf16"
 	"unicode/utf8"
 )
 
@@ -30,15 +37,141 @@ import (
 // Marshal traverses the value v recursively, using the following
 // type-dependent default encodings:
 // (see https://github.com/edn-format/edn)
+//
+// If an encountered value implements the Marshaler interface and is
+// not a nil pointer, Marshal calls its MarshalEDN method and writes the
+// result verbatim. Otherwise, a value implementing
+// encoding.TextMarshaler is encoded as the EDN string of its MarshalText
+// result, and failing that, a value implementing
+// encoding.BinaryMarshaler is encoded like a []byte holding its
+// MarshalBinary result.
+//
+// Struct values encode as EDN maps. Each exported struct field becomes
+// a map entry keyed by a keyword derived from the field name, so that
+// FirstName is written as :first-name. Fields of anonymous struct
+// fields are promoted as if they belonged to the outer struct.
+//
+// The encoding of each struct field can be customized by the format
+// string stored under the "edn" key in the struct field's tag, which
+// gives the keyword name, possibly followed by comma-separated options.
+// The name may be empty to keep the default while specifying options:
+//
+//	// Field appears in EDN as key :my-name.
+//	Field int `edn:"my-name"`
+//
+//	// Field appears in EDN as key :field and is omitted from
+//	// the map if its value is empty.
+//	Field int `edn:",omitempty"`
+//
+//	// Field is ignored by this package.
+//	Field int `edn:"-"`
+//
+// The "omitempty" option omits the field if it has an empty value,
+// defined as false, 0, a nil pointer, a nil interface value, and any
+// empty array, slice, map, or string.
+//
+// The "omitzero" option omits the field if it is the zero value of its
+// type, such as an empty struct, or, if the field's type has an
+// IsZero() bool method, as time.Time does, if that returns true. It can
+// be combined with "omitempty", and omits fields either would.
+//
+// Nil maps and slices are written as empty collections, unless the
+// Encoder's SetNilCollections option is on. The "emitnil" and
+// "emitempty" options override that choice for a single field.
+//
+// The "key" option chooses how the field's key is written: "keyword"
+// (the default), "string", or "symbol". Tagging a blank field sets the
+// default for every field of its struct:
+//
+//	// Keys of this struct appear in EDN as strings.
+//	_ struct{} `edn:",key=string"`
+//
+// Likewise, the "tag" option on a blank field makes the struct a tagged
+// element, the idiomatic EDN way of transmitting typed records:
+//
+//	// This struct appears in EDN as #myapp/Widget {:id 1}.
+//	_ struct{} `edn:",tag=myapp/Widget"`
+//
+// The "vector" option on a blank field writes the struct as a vector of
+// its field values in field order, such as [1 "x" true], which spares
+// high-volume records from repeating their keys. Every field takes its
+// place in the vector, so "omitempty" and "omitzero" are ignored:
+//
+//	// This struct appears in EDN as [1 "x" true].
+//	_ struct{} `edn:",vector"`
+//
+// UUIDs, such as this package's UUID type, are written as #uuid tagged
+// strings. A type is recognized as a UUID if it is named UUID, is a
+// [16]byte array or a []byte slice, and has a String method, as in
+// github.com/google/uuid and github.com/gofrs/uuid. Other UUID types
+// can be given the same encoding with RegisterTagWriter.
 func Marshal(v interface{}) ([]byte, error) {
-	e := &encodeState{}
+	e := newEncodeState()
+	defer putEncodeState(e)
+	e.opts = defaultEncOpts
 	err := e.marshal(v)
 	if err != nil {
 		return nil, err
 	}
+	return append([]byte(nil), e.Bytes()...), nil
+}
+
+// MarshalString is like Marshal but returns the encoding as a string.
+// It copies the encoding straight out of a reused internal buffer, so
+// it costs the same single allocation as Marshal, where converting
+// Marshal's result to a string would cost a second one.
+func MarshalString(v interface{}) (string, error) {
+	e := newEncodeState()
+	defer putEncodeState(e)
+	e.opts = defaultEncOpts
+	err := e.marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return e.String(), nil
+}
+
+// MarshalAppend appends the EDN encoding of v to dst and returns the
+// extended slice, like Marshal but without allocating a new result when
+// dst has enough spare capacity. Reusing the returned slice across
+// calls, as in
+//
+//	buf, err = edn.MarshalAppend(buf[:0], v)
+//
+// avoids most allocations on hot paths. On error dst is returned
+// unchanged, although bytes beyond its length may have been written.
+func MarshalAppend(dst []byte, v interface{}) ([]byte, error) {
+	e := &encodeState{Buffer: *bytes.NewBuffer(dst), opts: defaultEncOpts}
+	err := e.marshal(v)
+	if err != nil {
+		return dst, err
+	}
 	return e.Bytes(), nil
 }
 
+// MarshalCanonical returns the canonical EDN encoding of v, suitable for
+// hashing, signing and deduplication: equal values always produce the
+// same bytes.
+//
+// It differs from Marshal in that map entries, struct fields and set
+// elements are sorted by their encoded text, map entries are separated by a single
+// space rather than ", ", and floating-point numbers always have a
+// fractional part and an unpadded exponent (2.0, 1.0e6, 1.5e-7).
+func MarshalCanonical(v interface{}) ([]byte, error) {
+	opts := defaultEncOpts
+	opts.sortMapKeys = true
+	opts.sortSets = true
+	opts.canonical = true
+	e := newEncodeState()
+	defer putEncodeState(e)
+	e.opts = opts
+	err := e.marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), e.Bytes()...), nil
+}
+
 // MustMarshal is a panicky version of Marshal.
 func MustMarshal(v interface{}) []byte {
 	if data, err := Marshal(v); err == nil {
@@ -48,6 +181,12 @@ func MustMarshal(v interface{}) []byte {
 	}
 }
 
+// Marshaler is the interface implemented by objects that
+// can marshal themselves into valid EDN.
+type Marshaler interface {
+	MarshalEDN() ([]byte, error)
+}
+
 // An UnsupportedTypeError is returned by Marshal when attempting
 // to encode an unsupported value type.
 type UnsupportedTypeError struct {
@@ -67,19 +206,155 @@ func (e *UnsupportedValueError) Error() string {
 	return "edn: unsupported value: " + e.Str
 }
 
+// A DepthError is returned by an Encoder when a value is nested more
+// deeply than the Encoder's maximum depth allows.
+type DepthError struct {
+	MaxDepth int
+}
+
+func (e *DepthError) Error() string {
+	return "edn: exceeded max depth of " + strconv.Itoa(e.MaxDepth)
+}
+
+// A DuplicateKeyError is returned when distinct Go map keys or set
+// elements have the same EDN encoding, such as int 1 and uint 1 or the
+// KMap keys "a" and ":a", which would make the map or set invalid.
+type DuplicateKeyError struct {
+	Type reflect.Type // type of the map or set
+	Key  string       // the duplicated encoding
+}
+
+func (e *DuplicateKeyError) Error() string {
+	what := "map"
+	if isSetType(e.Type) {
+		what = "set"
+	}
+	return "edn: duplicate " + what + " key " + e.Key + " in " + e.Type.String()
+}
+
+// A MarshalerError is returned by Marshal when a method or function
+// producing a value's EDN form fails.
 type MarshalerError struct {
-	Type reflect.Type
-	Err  error
+	Type       reflect.Type
+	Err        error
+	sourceFunc string
 }
 
 func (e *MarshalerError) Error() string {
-	return "edn: error calling MarshalEDN for type " + e.Type.String() + ": " + e.Err.Error()
+	srcFunc := e.sourceFunc
+	if srcFunc == "" {
+		srcFunc = "MarshalEDN"
+	}
+	return "edn: error calling " + srcFunc + " for type " + e.Type.String() + ": " + e.Err.Error()
 }
 
+// Unwrap returns the underlying error.
+func (e *MarshalerError) Unwrap() error { return e.Err }
+
+// DefaultDurationTag is the tag used for time.Duration values unless
+// an Encoder is configured otherwise.
+const DefaultDurationTag = "go/duration"
+
+// DefaultBytesTag is the tag used for the base64 strings []byte values
+// are written as unless an Encoder is configured otherwise.
+const DefaultBytesTag = "base64"
+
 // An encodeState encodes EDN into a bytes.Buffer.
 type encodeState struct {
 	bytes.Buffer // accumulated output
 	scratch      [64]byte
+	opts         encOpts
+	depth        int // number of collections being encoded
+
+	// w, if not nil, receives the accumulated output whenever it grows
+	// past flushSize, so that large values are not held in memory.
+	w       io.Writer
+	flushed bool // whether any output has been written to w
+}
+
+// flushSize is the amount of output a streaming encodeState buffers
+// before writing it out.
+const flushSize = 32 << 10
+
+// encOpts holds the options that affect how values are encoded.
+type encOpts struct {
+	// durationTag is written before time.Duration values. Durations
+	// are written as integer nanoseconds when it is empty.
+	durationTag string
+
+	// bytesTag is written before the base64 strings of []byte values,
+	// which are written untagged when it is empty.
+	bytesTag string
+
+	// byteVectors writes []byte values as vectors of integers.
+	byteVectors bool
+
+	// parallelism is the number of goroutines that encode the elements
+	// of long slices and arrays; 0 or 1 means no concurrency.
+	parallelism int
+
+	// stringers writes values implementing fmt.Stringer, but no more
+	// specific interface, as the strings their String methods return.
+	stringers bool
+
+	// validateSymbols rejects keywords and symbols that cannot be read
+	// back as such.
+	validateSymbols bool
+
+	// nilCollections writes nil maps and slices as nil rather than as
+	// empty collections.
+	nilCollections bool
+
+	// sortMapKeys writes map entries ordered by the encoded text of
+	// their keys instead of in Go's map iteration order.
+	sortMapKeys bool
+
+	// sortSets does the same for the elements of sets.
+	sortSets bool
+
+	// checkKeys fails with a DuplicateKeyError when two map keys or set
+	// elements have the same encoding. Sorted keys are always checked.
+	checkKeys bool
+
+	// canonical selects the single normalized spelling of values used
+	// by MarshalCanonical.
+	canonical bool
+
+	// asciiOnly escapes every non-ASCII rune in strings as \uXXXX.
+	asciiOnly bool
+
+	// specialFloats writes infinities and NaN as ##Inf, ##-Inf and
+	// ##NaN rather than failing with an UnsupportedValueError.
+	specialFloats bool
+
+	// keywordizeKeys writes the string keys of maps as keywords, as is
+	// always done for KMap.
+	keywordizeKeys bool
+
+	// namespaceMaps writes maps whose keys are all keywords in one
+	// namespace in the #:ns{:k v} form.
+	namespaceMaps bool
+
+	// encodeErrors writes values implementing error as #error maps.
+	encodeErrors bool
+
+	// maxDepth limits how deeply collections may nest; 0 means no limit.
+	maxDepth int
+
+	// encs builds the encoders for values; nil means encoderCache.
+	encs *encoderSet
+}
+
+var defaultEncOpts = encOpts{
+	durationTag: DefaultDurationTag,
+	bytesTag:    DefaultBytesTag,
+}
+
+func (o *encOpts) encoders() *encoderSet {
+	if o.encs == nil {
+		return &encoderCache
+	}
+	return o.encs
 }
 
 func (e *encodeState) marshal(v interface{}) (err error) {
@@ -94,16 +369,146 @@ func (e *encodeState) marshal(v interface{}) (err error) {
 			err = r.(error)
 		}
 	}()
-	e.reflectValue(reflect.ValueOf(v))
+	e.depth = 0
+	e.value(v)
 	return nil
 }
 
+// value writes the encoding of v, using fastValue when possible.
+func (e *encodeState) value(v interface{}) {
+	if !e.fastValue(v) {
+		e.reflectValue(reflect.ValueOf(v))
+	}
+}
+
+// fastValue writes the common values of dynamic payloads, such as those
+// built from decoded JSON, without reflection, and reports whether it
+// did. Anything it does not handle exactly as the reflection-based
+// encoders would, it leaves to them.
+func (e *encodeState) fastValue(v interface{}) bool {
+	if !e.opts.encoders().fastPathOK() {
+		return false
+	}
+	switch v := v.(type) {
+	case nil:
+		e.WriteString("nil")
+	case bool:
+		if v {
+			e.WriteString("true")
+		} else {
+			e.WriteString("false")
+		}
+	case int:
+		e.Write(strconv.AppendInt(e.scratch[:0], int64(v), 10))
+	case int64:
+		e.Write(strconv.AppendInt(e.scratch[:0], v, 10))
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return false
+		}
+		e.float(v, 64)
+	case string:
+		e.string(v)
+	case Keyword:
+		if e.opts.validateSymbols {
+			return false
+		}
+		if !strings.HasPrefix(string(v), ":") {
+			e.WriteByte(':')
+		}
+		e.WriteString(string(v))
+	case []interface{}:
+		if v == nil || e.opts.parallelism > 1 && len(v) >= minParallelLen {
+			return false
+		}
+		e.enter()
+		e.WriteByte('[')
+		for i, x := range v {
+			if i > 0 {
+				e.WriteByte(' ')
+				e.flush()
+			}
+			e.value(x)
+		}
+		e.WriteByte(']')
+		e.leave()
+	case map[string]interface{}:
+		if e.opts.sortMapKeys || e.opts.namespaceMaps || (e.opts.checkKeys || e.opts.validateSymbols) && e.opts.keywordizeKeys {
+			return false
+		}
+		if v == nil {
+			return false
+		}
+		e.enter()
+		e.WriteByte('{')
+		first := true
+		for k, x := range v {
+			if !first {
+				e.WriteString(e.entrySep())
+				e.flush()
+			}
+			first = false
+			if e.opts.keywordizeKeys {
+				if !strings.HasPrefix(k, ":") {
+					e.WriteByte(':')
+				}
+				e.WriteString(k)
+			} else {
+				e.string(k)
+			}
+			e.WriteByte(' ')
+			e.value(x)
+		}
+		e.WriteByte('}')
+		e.leave()
+	default:
+		return false
+	}
+	return true
+}
+
+// enter records that a collection is being entered, and fails if that
+// nests it too deeply. Each call is paired with a call to leave.
+func (e *encodeState) enter() {
+	e.depth++
+	if e.opts.maxDepth > 0 && e.depth > e.opts.maxDepth {
+		e.error(&DepthError{e.opts.maxDepth})
+	}
+}
+
+func (e *encodeState) leave() {
+	e.depth--
+	e.flush()
+}
+
+// flush writes the accumulated output to e.w, if there is one and the
+// output has grown large enough. It is only called between elements, so
+// that the output is never split in the middle of an atom.
+func (e *encodeState) flush() {
+	if e.w == nil || e.Len() < flushSize {
+		return
+	}
+	e.flushed = true
+	if _, err := e.w.Write(e.Bytes()); err != nil {
+		e.error(err)
+	}
+	e.Reset()
+}
+
+// entrySep returns the separator written between map entries.
+func (e *encodeState) entrySep() string {
+	if e.opts.canonical {
+		return " "
+	}
+	return ", "
+}
+
 func (e *encodeState) error(err error) {
 	panic(err)
 }
 
 func (e *encodeState) reflectValue(v reflect.Value) {
-	valueEncoder(v)(e, v)
+	e.opts.encoders().valueEncoder(v)(e, v)
 }
 
 // ensureUtf8 produces a valid utf-8 encoded string. In case its input is
@@ -138,85 +543,266 @@ func ensureUtf8(s string) string {
 	return buf.String()
 }
 
+// string writes s as an EDN string literal. Only the double quote,
+// backslash, newline, tab and carriage return characters are escaped;
+// everything else is written as raw UTF-8, with invalid bytes replaced
+// by \ufffd. If the asciiOnly option is set, non-ASCII runes are written
+// as \uXXXX escapes instead.
 func (e *encodeState) string(s string) (int, error) {
 	len0 := e.Len()
-	// Quote approximates Java/Clojure string literal representation,
-	// but not quite. TODO: follow Java spec exactly.
-	e.WriteString(strconv.Quote(ensureUtf8(s)))
+	e.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if esc := stringEscape[b]; esc != 0 {
+				e.WriteString(s[start:i])
+				e.WriteByte('\\')
+				e.WriteByte(esc)
+				i++
+				start = i
+				continue
+			}
+			i++
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if c == utf8.RuneError && size == 1 || e.opts.asciiOnly {
+			e.WriteString(s[start:i])
+			if e.opts.asciiOnly {
+				e.unicodeEscape(c)
+			} else {
+				e.WriteString("\ufffd")
+			}
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	e.WriteString(s[start:])
+	e.WriteByte('"')
 	return e.Len() - len0, nil
 }
 
+// stringBytes is the same as string, but for a byte slice.
 func (e *encodeState) stringBytes(s []byte) (int, error) {
 	len0 := e.Len()
-	e.WriteString(fmt.Sprintf("%#v", string(s)))
+	e.WriteByte('"')
+	start := 0
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			if esc := stringEscape[b]; esc != 0 {
+				e.Write(s[start:i])
+				e.WriteByte('\\')
+				e.WriteByte(esc)
+				i++
+				start = i
+				continue
+			}
+			i++
+			continue
+		}
+		c, size := utf8.DecodeRune(s[i:])
+		if c == utf8.RuneError && size == 1 || e.opts.asciiOnly {
+			e.Write(s[start:i])
+			if e.opts.asciiOnly {
+				e.unicodeEscape(c)
+			} else {
+				e.WriteString("\ufffd")
+			}
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	e.Write(s[start:])
+	e.WriteByte('"')
 	return e.Len() - len0, nil
 }
 
+// unicodeEscape writes r as a \uXXXX escape, or as a UTF-16 surrogate
+// pair of them if r is outside the Basic Multilingual Plane.
+func (e *encodeState) unicodeEscape(r rune) {
+	const hex = "0123456789abcdef"
+	if r1, r2 := utf16.EncodeRune(r); r1 != unicode.ReplacementChar {
+		e.unicodeEscape(r1)
+		r = r2
+	}
+	e.WriteString(`\u`)
+	for shift := 12; shift >= 0; shift -= 4 {
+		e.WriteByte(hex[r>>uint(shift)&0xf])
+	}
+}
+
+// stringEscape maps each ASCII byte that must be escaped inside an EDN
+// string to the character following the backslash.
+var stringEscape = [utf8.RuneSelf]byte{
+	'"':  '"',
+	'\\': '\\',
+	'\n': 'n',
+	'\t': 't',
+	'\r': 'r',
+}
+
 type encoderFunc func(e *encodeState, v reflect.Value)
 
-var encoderCache struct {
-	sync.RWMutex
-	m map[reflect.Type]encoderFunc
+// An encoderSet builds encoderFuncs and caches them by type. The
+// encoders it builds for composite types look up their element encoders
+// in the same set, so its tag writers apply at any depth.
+type encoderSet struct {
+	m sync.Map // map[reflect.Type]encoderFunc
+
+	// writers holds tag writers that take precedence over the ones
+	// registered with RegisterTagWriter. It is not changed once the
+	// set is in use.
+	writers map[reflect.Type]*tagWriter
+
+	// fastTypeWriters is set if writers has a writer for any of
+	// fastTypes.
+	fastTypeWriters bool
 }
 
-func valueEncoder(v reflect.Value) encoderFunc {
+// encoderCache is the set used by Marshal and by Encoders without
+// tag writers of their own.
+var encoderCache encoderSet
+
+func (c *encoderSet) valueEncoder(v reflect.Value) encoderFunc {
 	if !v.IsValid() {
 		return invalidValueEncoder
 	}
-	return typeEncoder(v.Type())
+	return c.typeEncoder(v.Type())
 }
 
-func typeEncoder(t reflect.Type) encoderFunc {
-	encoderCache.RLock()
-	f := encoderCache.m[t]
-	encoderCache.RUnlock()
-	if f != nil {
-		return f
+func (c *encoderSet) typeEncoder(t reflect.Type) encoderFunc {
+	if fi, ok := c.m.Load(t); ok {
+		return fi.(encoderFunc)
 	}
+	return c.buildTypeEncoder(t)
+}
 
+// buildTypeEncoder constructs and caches the encoder for t. It is kept
+// apart from typeEncoder because the closure below would otherwise make
+// every cache hit allocate.
+func (c *encoderSet) buildTypeEncoder(t reflect.Type) encoderFunc {
 	// To deal with recursive types, populate the map with an
 	// indirect func before we build it. This type waits on the
 	// real func (f) to be ready and then calls it.  This indirect
 	// func is only used for recursive types.
-	encoderCache.Lock()
-	if encoderCache.m == nil {
-		encoderCache.m = make(map[reflect.Type]encoderFunc)
-	}
-	var wg sync.WaitGroup
+	var (
+		wg sync.WaitGroup
+		f  encoderFunc
+	)
 	wg.Add(1)
-	encoderCache.m[t] = func(e *encodeState, v reflect.Value) {
+	fi, loaded := c.m.LoadOrStore(t, encoderFunc(func(e *encodeState, v reflect.Value) {
 		wg.Wait()
 		f(e, v)
+	}))
+	if loaded {
+		return fi.(encoderFunc)
 	}
-	encoderCache.Unlock()
 
-	// Compute fields without lock.
-	// Might duplicate effort but won't hold other computations back.
-	f = newTypeEncoder(t, true)
+	// Compute the real encoder and replace the indirect func with it.
+	f = c.newTypeEncoder(t, true)
+	if t.Kind() != reflect.Interface && t.Implements(errorType) && !t.Implements(marshalerType) {
+		if _, ok := c.lookupTagWriter(t); !ok {
+			f = newErrorEncoder(f)
+		}
+	}
 	wg.Done()
-	encoderCache.Lock()
-	encoderCache.m[t] = f
-	encoderCache.Unlock()
+	c.m.Store(t, f)
 	return f
 }
 
 var (
 	timeType          = reflect.TypeOf(time.Time{})
+	durationType      = reflect.TypeOf(time.Duration(0))
+	stringType        = reflect.TypeOf("")
+	bigIntType        = reflect.TypeOf(big.Int{})
+	bigFloatType      = reflect.TypeOf(big.Float{})
+	jsonNumberType    = reflect.TypeOf(json.Number(""))
+	syncMapType       = reflect.TypeOf((*sync.Map)(nil)).Elem()
+	marshalerType     = reflect.TypeOf(new(Marshaler)).Elem()
+	errorType         = reflect.TypeOf(new(error)).Elem()
 	textMarshalerType = reflect.TypeOf(new(encoding.TextMarshaler)).Elem()
+	binMarshalerType  = reflect.TypeOf(new(encoding.BinaryMarshaler)).Elem()
 	listType          = reflect.TypeOf(list.List{})
-	uuidType          = reflect.TypeOf(uuid.UUID{})
+	ringType          = reflect.TypeOf(ring.Ring{})
+	stringerType      = reflect.TypeOf(new(fmt.Stringer)).Elem()
 )
 
 // newTypeEncoder constructs an encoderFunc for a type.
 // The returned encoder only checks CanAddr when allowAddr is true.
-func newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
+func (c *encoderSet) newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
+	if w, ok := c.lookupTagWriter(t); ok {
+		return w.encode
+	}
+	if t.Kind() == reflect.Ptr {
+		if _, ok := c.lookupTagWriter(t.Elem()); ok {
+			return c.newPtrEncoder(t)
+		}
+	}
+
+	// A type's own MarshalEDN wins over everything but a registered tag
+	// writer, even if the type looks like one of the special cases below.
+	if t.Implements(marshalerType) {
+		return marshalerEncoder
+	}
+	if t.Kind() != reflect.Ptr && allowAddr {
+		if reflect.PtrTo(t).Implements(marshalerType) {
+			return newCondAddrEncoder(addrMarshalerEncoder, c.newTypeEncoder(t, false))
+		}
+	}
+
 	// Special case for time.Time because it already implements
 	// TextMarshaler which is not what we want as EDN.
 	if t == timeType {
 		return timeEncoder
 	}
 	if t.Kind() == reflect.Ptr && t.Elem() == timeType {
-		return newPtrEncoder(t)
+		return c.newPtrEncoder(t)
+	}
+	if t == instantType {
+		return instantEncoder
+	}
+	// UUID types usually implement TextMarshaler as well.
+	if isUUIDType(t) {
+		return encodeUuid
+	}
+	if t.Kind() == reflect.Ptr && isUUIDType(t.Elem()) {
+		return c.newPtrEncoder(t)
+	}
+	if t == durationType {
+		return durationEncoder
+	}
+	if t == jsonNumberType {
+		return jsonNumberEncoder
+	}
+	if t == charType {
+		return charEncoder
+	}
+	if t == taggedType {
+		return taggedEncoder
+	}
+	if t == metaType {
+		return metaEncoder
+	}
+	if t == hashMapType {
+		return hashMapEncoder
+	}
+	if t == orderedMapType {
+		return orderedMapEncoder
+	}
+	// The math/big types are TextMarshalers too, but are written as
+	// arbitrary-precision number literals.
+	if t == bigIntType {
+		return bigIntEncoder
+	}
+	if t == bigFloatType {
+		return bigFloatEncoder
+	}
+	if t.Kind() == reflect.Ptr && (t.Elem() == bigIntType || t.Elem() == bigFloatType) {
+		return c.newPtrEncoder(t)
 	}
 
 	if t.Implements(textMarshalerType) {
@@ -224,10 +810,29 @@ func newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
 	}
 	if t.Kind() != reflect.Ptr && allowAddr {
 		if reflect.PtrTo(t).Implements(textMarshalerType) {
-			return newCondAddrEncoder(addrTextMarshalerEncoder, newTypeEncoder(t, false))
+			return newCondAddrEncoder(addrTextMarshalerEncoder, c.newTypeEncoder(t, false))
+		}
+	}
+
+	if t.Implements(binMarshalerType) {
+		return binaryMarshalerEncoder
+	}
+	if t.Kind() != reflect.Ptr && allowAddr {
+		if reflect.PtrTo(t).Implements(binMarshalerType) {
+			return newCondAddrEncoder(addrBinaryMarshalerEncoder, c.newTypeEncoder(t, false))
 		}
 	}
 
+	enc := c.newKindEncoder(t)
+	if t.Kind() != reflect.Interface && t.Implements(stringerType) {
+		enc = newStringerEncoder(enc)
+	}
+	return enc
+}
+
+// newKindEncoder constructs the default encoderFunc for a type, which
+// depends on its kind.
+func (c *encoderSet) newKindEncoder(t reflect.Type) encoderFunc {
 	switch t.Kind() {
 	case reflect.Bool:
 		return boolEncoder
@@ -243,19 +848,32 @@ func newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
 		return stringEncoder
 	case reflect.Interface:
 		return interfaceEncoder
+	case reflect.Struct:
+		if t == listType {
+			return listEncoder
+		}
+		if t == ringType {
+			return ringEncoder
+		}
+		if t == syncMapType {
+			return syncMapEncoder
+		}
+		return c.newStructEncoder(t)
 	case reflect.Map:
-		return newMapEncoder(t)
+		return c.newMapEncoder(t)
 	case reflect.Slice:
-		return newSliceEncoder(t)
+		return c.newSliceEncoder(t)
 	case reflect.Array:
-		return newArrayEncoder(t)
+		return c.newArrayEncoder(t)
 	case reflect.Ptr:
-		return newPtrEncoder(t)
-	default:
-		if t == listType {
-			return listEncoder
+		return c.newPtrEncoder(t)
+	case reflect.Func:
+		if enc := c.newSeqEncoder(t); enc != nil {
+			return enc
 		}
 		return unsupportedTypeEncoder
+	default:
+		return unsupportedTypeEncoder
 	}
 }
 
@@ -271,64 +889,352 @@ func timeEncoder(e *encodeState, v reflect.Value) {
 	}
 }
 
-func textMarshalerEncoder(e *encodeState, v reflect.Value) {
-	if v.Kind() == reflect.Ptr && v.IsNil() {
-		e.WriteString("nil")
+func orderedMapEncoder(e *encodeState, v reflect.Value) {
+	m := v.Interface().(OrderedMap)
+	if e.opts.sortMapKeys {
+		// Equal OrderedMaps must be written alike, whatever their order.
+		vals := m.vals
+		if vals == nil {
+			vals = map[interface{}]interface{}{}
+		}
+		e.reflectValue(reflect.ValueOf(vals))
 		return
 	}
-	m := v.Interface().(encoding.TextMarshaler)
-	b, err := m.MarshalText()
-	if err == nil {
-		_, err = e.stringBytes(b)
+	e.enter()
+	e.WriteByte('{')
+	for i, k := range m.keys {
+		if i > 0 {
+			e.WriteString(e.entrySep())
+			e.flush()
+		}
+		e.value(k)
+		e.WriteByte(' ')
+		e.value(m.vals[k])
 	}
-	if err != nil {
-		e.error(&MarshalerError{v.Type(), err})
+	e.WriteByte('}')
+	e.leave()
+}
+
+func instantEncoder(e *encodeState, v reflect.Value) {
+	e.WriteString("#inst ")
+	if _, err := e.string(v.Interface().(Instant).String()); err != nil {
+		e.error(err)
 	}
 }
 
-func addrTextMarshalerEncoder(e *encodeState, v reflect.Value) {
-	va := v.Addr()
-	if va.IsNil() {
-		e.WriteString("nil")
+func durationEncoder(e *encodeState, v reflect.Value) {
+	if e.opts.durationTag == "" {
+		intEncoder(e, v)
 		return
 	}
-	m := va.Interface().(encoding.TextMarshaler)
-	b, err := m.MarshalText()
-	if err == nil {
-		_, err = e.stringBytes(b)
+	d := time.Duration(v.Int())
+	e.WriteByte('#')
+	e.WriteString(e.opts.durationTag)
+	e.WriteByte(' ')
+	if _, err := e.string(d.String()); err != nil {
+		e.error(err)
 	}
-	if err != nil {
-		e.error(&MarshalerError{v.Type(), err})
+}
+
+func marshalerEncoder(e *encodeState, v reflect.Value) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		e.WriteString("nil")
+		return
 	}
+	m := v.Interface().(Marshaler)
+	e.marshalerBytes(v, m)
 }
 
-func boolEncoder(e *encodeState, v reflect.Value) {
-	if v.Bool() {
-		e.WriteString("true")
-	} else {
-		e.WriteString("false")
+func addrMarshalerEncoder(e *encodeState, v reflect.Value) {
+	va := v.Addr()
+	if va.IsNil() {
+		e.WriteString("nil")
+		return
 	}
+	m := va.Interface().(Marshaler)
+	e.marshalerBytes(v, m)
 }
 
-func intEncoder(e *encodeState, v reflect.Value) {
-	b := strconv.AppendInt(e.scratch[:0], v.Int(), 10)
+// marshalerBytes writes the output of m's MarshalEDN method verbatim.
+func (e *encodeState) marshalerBytes(v reflect.Value, m Marshaler) {
+	b, err := m.MarshalEDN()
+	if err == nil && len(bytes.TrimSpace(b)) == 0 {
+		err = errors.New("empty output")
+	}
+	if err != nil {
+		e.error(&MarshalerError{v.Type(), err, ""})
+	}
 	e.Write(b)
 }
 
-func uintEncoder(e *encodeState, v reflect.Value) {
-	b := strconv.AppendUint(e.scratch[:0], v.Uint(), 10)
-	e.Write(b)
+// charNames holds the named EDN character literals.
+var charNames = map[rune]string{
+	'\n': `\newline`,
+	'\r': `\return`,
+	' ':  `\space`,
+	'\t': `\tab`,
 }
 
-type floatEncoder int // number of bits
+func charEncoder(e *encodeState, v reflect.Value) {
+	r := rune(v.Int())
+	switch {
+	case charNames[r] != "":
+		e.WriteString(charNames[r])
+	case '!' <= r && r <= '~':
+		e.WriteByte('\\')
+		e.WriteByte(byte(r))
+	case 0 <= r && r <= 0xffff && !utf16.IsSurrogate(r):
+		e.unicodeEscape(r)
+	default:
+		// EDN characters are UTF-16 code units, as in Java.
+		e.error(&UnsupportedValueError{v, fmt.Sprintf("character %U", r)})
+	}
+}
 
-func (bits floatEncoder) encode(e *encodeState, v reflect.Value) {
-	f := v.Float()
-	if math.IsInf(f, 0) || math.IsNaN(f) {
-		e.error(&UnsupportedValueError{v, strconv.FormatFloat(f, 'g', -1, int(bits))})
+func taggedEncoder(e *encodeState, v reflect.Value) {
+	t := v.Interface().(Tagged)
+	tag := strings.TrimPrefix(t.Tag, "#")
+	if tag == "" {
+		e.error(&UnsupportedValueError{v, "empty tag"})
 	}
-	b := strconv.AppendFloat(e.scratch[:0], f, 'g', -1, int(bits))
-	e.Write(b)
+	// A tag is a symbol that begins with a letter.
+	if r, _ := utf8.DecodeRuneInString(tag); !unicode.IsLetter(r) || !validSymbol(tag) {
+		e.error(&UnsupportedValueError{v, "tag #" + tag})
+	}
+	e.WriteByte('#')
+	e.WriteString(tag)
+	e.WriteByte(' ')
+	e.reflectValue(reflect.ValueOf(t.Value))
+}
+
+func metaEncoder(e *encodeState, v reflect.Value) {
+	m := v.Interface().(Meta)
+	if len(m.Meta) > 0 {
+		e.WriteByte('^')
+		e.reflectValue(reflect.ValueOf(m.Meta))
+		e.WriteByte(' ')
+	}
+	e.value(m.Value)
+}
+
+// jsonNumberEncoder writes a json.Number as the numeric literal it
+// holds. JSON number syntax is a subset of EDN's.
+func jsonNumberEncoder(e *encodeState, v reflect.Value) {
+	n := v.String()
+	if n == "" {
+		n = "0" // as encoding/json does
+	}
+	if !isValidJSONNumber(n) {
+		e.error(&UnsupportedValueError{v, strconv.Quote(n)})
+	}
+	e.WriteString(n)
+}
+
+// isValidJSONNumber reports whether s is a valid JSON number literal.
+func isValidJSONNumber(s string) bool {
+	// See https://tools.ietf.org/html/rfc7159#section-6.
+	if s == "" {
+		return false
+	}
+	if s[0] == '-' {
+		s = s[1:]
+		if s == "" {
+			return false
+		}
+	}
+	switch {
+	default:
+		return false
+	case s[0] == '0':
+		s = s[1:]
+	case '1' <= s[0] && s[0] <= '9':
+		s = s[1:]
+		for len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+	if len(s) >= 2 && s[0] == '.' && '0' <= s[1] && s[1] <= '9' {
+		s = s[2:]
+		for len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+	if len(s) >= 2 && (s[0] == 'e' || s[0] == 'E') {
+		s = s[1:]
+		if s[0] == '+' || s[0] == '-' {
+			s = s[1:]
+			if s == "" {
+				return false
+			}
+		}
+		for len(s) > 0 && '0' <= s[0] && s[0] <= '9' {
+			s = s[1:]
+		}
+	}
+	return s == ""
+}
+
+// addrOf returns a pointer to v's value, copying it if v is not
+// addressable.
+func addrOf(v reflect.Value) reflect.Value {
+	if v.CanAddr() {
+		return v.Addr()
+	}
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p
+}
+
+func bigIntEncoder(e *encodeState, v reflect.Value) {
+	x := addrOf(v).Interface().(*big.Int)
+	e.Write(x.Append(e.scratch[:0], 10))
+	e.WriteByte('N')
+}
+
+func bigFloatEncoder(e *encodeState, v reflect.Value) {
+	x := addrOf(v).Interface().(*big.Float)
+	if x.IsInf() {
+		e.error(&UnsupportedValueError{v, x.String()})
+	}
+	e.Write(x.Append(e.scratch[:0], 'g', -1))
+	e.WriteByte('M')
+}
+
+func textMarshalerEncoder(e *encodeState, v reflect.Value) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		e.WriteString("nil")
+		return
+	}
+	m := v.Interface().(encoding.TextMarshaler)
+	b, err := m.MarshalText()
+	if err == nil {
+		_, err = e.stringBytes(b)
+	}
+	if err != nil {
+		e.error(&MarshalerError{v.Type(), err, "MarshalText"})
+	}
+}
+
+func addrTextMarshalerEncoder(e *encodeState, v reflect.Value) {
+	va := v.Addr()
+	if va.IsNil() {
+		e.WriteString("nil")
+		return
+	}
+	m := va.Interface().(encoding.TextMarshaler)
+	b, err := m.MarshalText()
+	if err == nil {
+		_, err = e.stringBytes(b)
+	}
+	if err != nil {
+		e.error(&MarshalerError{v.Type(), err, "MarshalText"})
+	}
+}
+
+func binaryMarshalerEncoder(e *encodeState, v reflect.Value) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		e.WriteString("nil")
+		return
+	}
+	m := v.Interface().(encoding.BinaryMarshaler)
+	b, err := m.MarshalBinary()
+	if err != nil {
+		e.error(&MarshalerError{v.Type(), err, "MarshalBinary"})
+	}
+	e.bytes(b)
+}
+
+func addrBinaryMarshalerEncoder(e *encodeState, v reflect.Value) {
+	va := v.Addr()
+	if va.IsNil() {
+		e.WriteString("nil")
+		return
+	}
+	m := va.Interface().(encoding.BinaryMarshaler)
+	b, err := m.MarshalBinary()
+	if err != nil {
+		e.error(&MarshalerError{v.Type(), err, "MarshalBinary"})
+	}
+	e.bytes(b)
+}
+
+func boolEncoder(e *encodeState, v reflect.Value) {
+	if v.Bool() {
+		e.WriteString("true")
+	} else {
+		e.WriteString("false")
+	}
+}
+
+func intEncoder(e *encodeState, v reflect.Value) {
+	b := strconv.AppendInt(e.scratch[:0], v.Int(), 10)
+	e.Write(b)
+}
+
+func uintEncoder(e *encodeState, v reflect.Value) {
+	b := strconv.AppendUint(e.scratch[:0], v.Uint(), 10)
+	e.Write(b)
+}
+
+type floatEncoder int // number of bits
+
+func (bits floatEncoder) encode(e *encodeState, v reflect.Value) {
+	f := v.Float()
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		if !e.opts.specialFloats {
+			e.error(&UnsupportedValueError{v, strconv.FormatFloat(f, 'g', -1, int(bits))})
+		}
+		switch {
+		case math.IsNaN(f):
+			e.WriteString("##NaN")
+		case f > 0:
+			e.WriteString("##Inf")
+		default:
+			e.WriteString("##-Inf")
+		}
+		return
+	}
+	e.float(f, int(bits))
+}
+
+// float writes the finite floating-point number f, which has the given
+// number of bits.
+func (e *encodeState) float(f float64, bits int) {
+	b := strconv.AppendFloat(e.scratch[:0], f, 'g', -1, bits)
+	if e.opts.canonical {
+		b = canonicalFloat(b)
+	}
+	e.Write(b)
+}
+
+// canonicalFloat rewrites the shortest 'g' formatting of a float so it
+// always reads back as a float and has a single spelling: the mantissa
+// always has a fractional part, and the exponent has no plus sign or
+// leading zeros. For example, 2 becomes 2.0 and 1e+06 becomes 1.0e6.
+func canonicalFloat(b []byte) []byte {
+	mant, exp := b, []byte(nil)
+	if i := bytes.IndexByte(b, 'e'); i >= 0 {
+		mant, exp = b[:i], b[i+1:]
+	}
+	out := make([]byte, 0, len(b)+2)
+	out = append(out, mant...)
+	if bytes.IndexByte(mant, '.') < 0 {
+		out = append(out, ".0"...)
+	}
+	if exp != nil {
+		out = append(out, 'e')
+		if exp[0] == '-' || exp[0] == '+' {
+			if exp[0] == '-' {
+				out = append(out, '-')
+			}
+			exp = exp[1:]
+		}
+		for len(exp) > 1 && exp[0] == '0' {
+			exp = exp[1:]
+		}
+		out = append(out, exp...)
+	}
+	return out
 }
 
 var (
@@ -340,6 +1246,12 @@ func stringEncoder(e *encodeState, v reflect.Value) {
 	s := v.String()
 	switch t := v.Type(); t {
 	case symbolType, keywordType:
+		if t == symbolType && symbolReadsAsNumber(s) {
+			e.error(&UnsupportedValueError{v, s})
+		}
+		if e.opts.validateSymbols {
+			e.validateSymbol(v, t == keywordType, s)
+		}
 		if t == keywordType && !strings.HasPrefix(s, ":") {
 			e.WriteByte(':')
 		}
@@ -350,11 +1262,25 @@ func stringEncoder(e *encodeState, v reflect.Value) {
 	}
 }
 
+// validateSymbol fails unless s, the string of keyword or symbol v, can
+// be read back as one.
+func (e *encodeState) validateSymbol(v reflect.Value, keyword bool, s string) {
+	switch {
+	case keyword && !validKeyword(strings.TrimPrefix(s, ":")):
+		e.error(&UnsupportedValueError{v, fmt.Sprintf("invalid keyword %q", s)})
+	case !keyword && !validSymbol(s):
+		e.error(&UnsupportedValueError{v, fmt.Sprintf("invalid symbol %q", s)})
+	}
+}
+
 func interfaceEncoder(e *encodeState, v reflect.Value) {
 	if v.IsNil() {
 		e.WriteString("nil")
 		return
 	}
+	if v.CanInterface() && e.fastValue(v.Interface()) {
+		return
+	}
 	e.reflectValue(v.Elem())
 }
 
@@ -362,15 +1288,240 @@ func unsupportedTypeEncoder(e *encodeState, v reflect.Value) {
 	e.error(&UnsupportedTypeError{v.Type()})
 }
 
+type structEncoder struct {
+	fields    []field
+	fieldEncs []encoderFunc
+	ns        string // namespace shared by all keys, if any
+	tag       string // tag written before the map, if any
+	vector    bool   // write field values positionally as a vector
+
+	// keys holds each field's encoded key followed by a space, and
+	// nsKeys the same for the #:ns{} form, so that they are not
+	// rebuilt for every value.
+	keys, nsKeys [][]byte
+
+	// sorted holds the indices of fields in the order of their keys,
+	// for the sortMapKeys option.
+	sorted []int
+}
+
+func (se *structEncoder) encode(e *encodeState, v reflect.Value) {
+	e.enter()
+	if se.tag != "" {
+		e.WriteByte('#')
+		e.WriteString(se.tag)
+		e.WriteByte(' ')
+	}
+	if se.vector {
+		se.encodeVector(e, v)
+		e.leave()
+		return
+	}
+	nsMap := e.opts.namespaceMaps && se.ns != ""
+	if nsMap {
+		e.WriteString("#:")
+		e.WriteString(se.ns)
+	}
+	e.WriteByte('{')
+	first := true
+	for n := range se.fields {
+		i := n
+		if e.opts.sortMapKeys {
+			i = se.sorted[n]
+		}
+		f := se.fields[i]
+		fv := fieldByIndex(v, f.index)
+		if !fv.IsValid() || f.omitEmpty && isEmptyValue(fv) || f.omitZero && isZeroValue(fv) {
+			continue
+		}
+		if first {
+			first = false
+		} else {
+			e.WriteString(e.entrySep())
+			e.flush()
+		}
+		if e.opts.validateSymbols && f.keyStyle != stringKey {
+			e.validateSymbol(reflect.ValueOf(f.name), f.keyStyle == keywordKey, f.name)
+		}
+		switch {
+		case nsMap:
+			e.Write(se.nsKeys[i])
+		case f.keyStyle == stringKey && e.opts.asciiOnly:
+			// Only the non-escaping spelling is precomputed.
+			e.string(f.name)
+			e.WriteByte(' ')
+		default:
+			e.Write(se.keys[i])
+		}
+		switch {
+		case f.emitNil && isNilCollection(fv):
+			e.WriteString("nil")
+		case f.emitEmpty && isNilCollection(fv):
+			se.fieldEncs[i](e, emptyCollection(fv.Type()))
+		default:
+			se.fieldEncs[i](e, fv)
+		}
+	}
+	e.WriteByte('}')
+	e.leave()
+}
+
+// encodeVector writes the fields of v as the elements of a vector. A
+// field behind a nil embedded pointer is written as nil.
+func (se *structEncoder) encodeVector(e *encodeState, v reflect.Value) {
+	e.WriteByte('[')
+	for i, f := range se.fields {
+		if i > 0 {
+			e.WriteByte(' ')
+			e.flush()
+		}
+		fv := fieldByIndex(v, f.index)
+		switch {
+		case !fv.IsValid(), f.emitNil && isNilCollection(fv):
+			e.WriteString("nil")
+		case f.emitEmpty && isNilCollection(fv):
+			se.fieldEncs[i](e, emptyCollection(fv.Type()))
+		default:
+			se.fieldEncs[i](e, fv)
+		}
+	}
+	e.WriteByte(']')
+}
+
+func (c *encoderSet) newStructEncoder(t reflect.Type) encoderFunc {
+	fields := typeFields(t)
+	opts := structOptions(t)
+	tag, _ := opts.Get("tag")
+	se := &structEncoder{
+		fields:    fields,
+		fieldEncs: make([]encoderFunc, len(fields)),
+		tag:       strings.TrimPrefix(tag, "#"),
+		vector:    opts.Contains("vector"),
+	}
+	for i, f := range fields {
+		se.fieldEncs[i] = c.typeEncoder(f.typ)
+	}
+	for i, f := range fields {
+		ns, _ := splitKeyword(f.name)
+		if f.keyStyle != keywordKey || ns == "" || i > 0 && ns != se.ns {
+			se.ns = ""
+			break
+		}
+		se.ns = ns
+	}
+	se.keys = make([][]byte, len(fields))
+	for i, f := range fields {
+		var ke encodeState
+		switch f.keyStyle {
+		case stringKey:
+			ke.string(f.name)
+		case symbolKey:
+			ke.WriteString(f.name)
+		default:
+			ke.WriteByte(':')
+			ke.WriteString(f.name)
+		}
+		ke.WriteByte(' ')
+		se.keys[i] = ke.Bytes()
+	}
+	se.sorted = make([]int, len(fields))
+	for i := range se.sorted {
+		se.sorted[i] = i
+	}
+	sort.SliceStable(se.sorted, func(i, j int) bool {
+		a, b := se.keys[se.sorted[i]], se.keys[se.sorted[j]]
+		return bytes.Compare(a[:len(a)-1], b[:len(b)-1]) < 0
+	})
+	if se.ns != "" {
+		se.nsKeys = make([][]byte, len(fields))
+		for i, f := range fields {
+			se.nsKeys[i] = []byte(":" + f.name[len(se.ns)+1:] + " ")
+		}
+	}
+	return se.encode
+}
+
+// An isZeroer reports whether it is a zero value, as time.Time does,
+// for the "omitzero" option.
+type isZeroer interface {
+	IsZero() bool
+}
+
+var isZeroerType = reflect.TypeOf(new(isZeroer)).Elem()
+
+// isZeroValue reports whether v should be omitted by the "omitzero"
+// option: whether its IsZero method, if it has one, returns true, or
+// otherwise whether it is its type's zero value.
+func isZeroValue(v reflect.Value) bool {
+	if v.Type().Implements(isZeroerType) {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return true
+		}
+		return v.Interface().(isZeroer).IsZero()
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(isZeroerType) {
+		return v.Addr().Interface().(isZeroer).IsZero()
+	}
+	return v.IsZero()
+}
+
+func isNilCollection(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+// emptyCollection returns an empty, non-nil map or slice of type t.
+func emptyCollection(t reflect.Type) reflect.Value {
+	if t.Kind() == reflect.Map {
+		return reflect.MakeMap(t)
+	}
+	return reflect.MakeSlice(t, 0, 0)
+}
+
+// fieldByIndex returns the nested field of v at index, or an invalid
+// Value if reaching it requires following a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+	return v
+}
+
 type mapEncoder struct {
 	keyEnc  encoderFunc
 	elemEnc encoderFunc
+
+	// stringKeys is set if the keys are of type string, which the
+	// keywordizeKeys option writes as keywords.
+	stringKeys bool
+
+	// set is set if the map is written as a set of its keys.
+	set bool
 }
 
 func (me *mapEncoder) encode(e *encodeState, v reflect.Value) {
-	isSet := v.Type() == setType
-	isKMap := v.Type() == keywordMapType
-	sep := ", "
+	isSet := me.set
+	var keyAs reflect.Type // type string keys are converted to, if any
+	switch {
+	case v.Type() == symbolMapType:
+		keyAs = symbolType
+	case v.Type() == keywordMapType, e.opts.keywordizeKeys && me.stringKeys:
+		keyAs = keywordType
+	}
+	if v.IsNil() && e.opts.nilCollections {
+		e.WriteString("nil")
+		return
+	}
+	sep := e.entrySep()
 	if isSet {
 		e.WriteByte('#')
 		sep = " "
@@ -379,15 +1530,50 @@ func (me *mapEncoder) encode(e *encodeState, v reflect.Value) {
 		e.WriteString("{}")
 		return
 	}
+	e.enter()
+	keys := v.MapKeys()
+	var ns string
+	if !isSet && e.opts.namespaceMaps {
+		ns = commonNamespace(keys, keyAs)
+	}
+	if ns != "" {
+		e.WriteString("#:")
+		e.WriteString(ns)
+	}
 	e.WriteByte('{')
-	for i, k := range v.MapKeys() {
+	var texts [][]byte
+	if isSet && e.opts.sortSets || !isSet && e.opts.sortMapKeys {
+		keys, texts = me.sortKeys(e, keys, keyAs)
+		for i := 1; i < len(texts); i++ {
+			if bytes.Equal(texts[i-1], texts[i]) {
+				e.error(&DuplicateKeyError{v.Type(), string(texts[i])})
+			}
+		}
+	} else if e.opts.checkKeys {
+		texts = me.keyTexts(e, keys, keyAs)
+		seen := make(map[string]bool, len(texts))
+		for _, text := range texts {
+			if seen[string(text)] {
+				e.error(&DuplicateKeyError{v.Type(), string(text)})
+			}
+			seen[string(text)] = true
+		}
+	}
+	for i, k := range keys {
 		if i > 0 {
 			e.WriteString(sep)
+			e.flush()
 		}
-		if !isKMap {
-			me.keyEnc(e, k)
-		} else {
-			me.keyEnc(e, reflect.ValueOf(Keyword(k.String())))
+		switch {
+		case ns != "":
+			kw, _ := keywordKeyOf(k, keyAs)
+			_, name := splitKeyword(kw)
+			e.WriteByte(':')
+			e.WriteString(name)
+		case texts != nil:
+			e.Write(texts[i])
+		default:
+			me.writeKey(e, k, keyAs)
 		}
 		if !isSet {
 			e.WriteByte(' ')
@@ -395,10 +1581,109 @@ func (me *mapEncoder) encode(e *encodeState, v reflect.Value) {
 		}
 	}
 	e.WriteByte('}')
+	e.leave()
+}
+
+// writeKey writes map key k, converting it to keyAs first if that is
+// not nil.
+func (me *mapEncoder) writeKey(e *encodeState, k reflect.Value, keyAs reflect.Type) {
+	switch keyAs {
+	case keywordType:
+		stringEncoder(e, reflect.ValueOf(Keyword(k.String())))
+	case symbolType:
+		stringEncoder(e, reflect.ValueOf(Symbol(k.String())))
+	default:
+		me.keyEnc(e, k)
+	}
+}
+
+// keywordKeyOf returns the keyword that map key k is written as, without
+// its leading colon, and whether k is written as a keyword at all.
+func keywordKeyOf(k reflect.Value, keyAs reflect.Type) (string, bool) {
+	switch keyAs {
+	case keywordType:
+		return strings.TrimPrefix(k.String(), ":"), true
+	case symbolType:
+		return "", false
+	}
+	if k.Kind() == reflect.Interface {
+		if k.IsNil() {
+			return "", false
+		}
+		k = k.Elem()
+	}
+	if k.Type() != keywordType {
+		return "", false
+	}
+	return strings.TrimPrefix(k.String(), ":"), true
+}
+
+// commonNamespace returns the namespace shared by all of keys, or "" if
+// they are not all keywords in one namespace.
+func commonNamespace(keys []reflect.Value, keyAs reflect.Type) string {
+	var common string
+	for i, k := range keys {
+		kw, ok := keywordKeyOf(k, keyAs)
+		if !ok {
+			return ""
+		}
+		ns, _ := splitKeyword(kw)
+		if ns == "" || i > 0 && ns != common {
+			return ""
+		}
+		common = ns
+	}
+	return common
+}
+
+// keyTexts returns the encoded text of each of keys.
+func (me *mapEncoder) keyTexts(e *encodeState, keys []reflect.Value, keyAs reflect.Type) [][]byte {
+	ke := &encodeState{opts: e.opts}
+	ends := make([]int, len(keys))
+	for i, k := range keys {
+		me.writeKey(ke, k, keyAs)
+		ends[i] = ke.Len()
+	}
+	buf := ke.Bytes()
+	texts := make([][]byte, len(keys))
+	start := 0
+	for i := range keys {
+		texts[i] = buf[start:ends[i]]
+		start = ends[i]
+	}
+	return texts
+}
+
+// sortKeys encodes keys and returns them ordered by their encoded text,
+// along with that text.
+func (me *mapEncoder) sortKeys(e *encodeState, keys []reflect.Value, keyAs reflect.Type) ([]reflect.Value, [][]byte) {
+	unsorted := me.keyTexts(e, keys, keyAs)
+	sorted := make([]struct {
+		k    reflect.Value
+		text []byte
+	}, len(keys))
+	for i, k := range keys {
+		sorted[i].k = k
+		sorted[i].text = unsorted[i]
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].text, sorted[j].text) < 0
+	})
+	texts := make([][]byte, len(keys))
+	for i := range sorted {
+		keys[i] = sorted[i].k
+		texts[i] = sorted[i].text
+	}
+	return keys, texts
 }
 
-func newMapEncoder(t reflect.Type) encoderFunc {
-	me := &mapEncoder{typeEncoder(t.Key()), typeEncoder(t.Elem())}
+func (c *encoderSet) newMapEncoder(t reflect.Type) encoderFunc {
+	me := &mapEncoder{
+		keyEnc:     c.typeEncoder(t.Key()),
+		elemEnc:    c.typeEncoder(t.Elem()),
+		stringKeys: t.Key() == stringType,
+		set:        isSetType(t),
+	}
 	return me.encode
 }
 
@@ -407,8 +1692,28 @@ func encodeByteSlice(e *encodeState, v reflect.Value) {
 		e.WriteString("nil")
 		return
 	}
-	s := v.Bytes()
-	e.WriteString("#base64 ")
+	e.bytes(v.Bytes())
+}
+
+// bytes writes s as a base64 string tagged with the bytes tag, or as a
+// vector of numbers if byteVectors is set.
+func (e *encodeState) bytes(s []byte) {
+	if e.opts.byteVectors {
+		e.WriteByte('[')
+		for i, b := range s {
+			if i > 0 {
+				e.WriteByte(' ')
+			}
+			e.Write(strconv.AppendUint(e.scratch[:0], uint64(b), 10))
+		}
+		e.WriteByte(']')
+		return
+	}
+	if e.opts.bytesTag != "" {
+		e.WriteByte('#')
+		e.WriteString(e.opts.bytesTag)
+		e.WriteByte(' ')
+	}
 	e.WriteByte('"')
 	if len(s) < 1024 {
 		// for small buffers, using Encode directly is much faster.
@@ -425,9 +1730,24 @@ func encodeByteSlice(e *encodeState, v reflect.Value) {
 	e.WriteByte('"')
 }
 
+// isUUIDType reports whether t looks like the UUID type of one of the
+// common UUID packages.
+func isUUIDType(t reflect.Type) bool {
+	if t.Name() != "UUID" || !t.Implements(stringerType) {
+		return false
+	}
+	switch t.Kind() {
+	case reflect.Array:
+		return t.Len() == 16 && t.Elem().Kind() == reflect.Uint8
+	case reflect.Slice:
+		return t.Elem().Kind() == reflect.Uint8
+	}
+	return false
+}
+
 func encodeUuid(e *encodeState, v reflect.Value) {
 	e.WriteString("#uuid ")
-	e.string(uuid.UUID(v.Bytes()).String())
+	e.string(v.Interface().(fmt.Stringer).String())
 }
 
 // sliceEncoder just wraps an arrayEncoder, checking to make sure the value isn't nil.
@@ -437,42 +1757,302 @@ type sliceEncoder struct {
 
 func (se *sliceEncoder) encode(e *encodeState, v reflect.Value) {
 	if v.IsNil() {
-		e.WriteString("[]")
+		if e.opts.nilCollections {
+			e.WriteString("nil")
+		} else {
+			e.WriteString("[]")
+		}
 		return
 	}
 	se.arrayEnc(e, v)
 }
 
-func newSliceEncoder(t reflect.Type) encoderFunc {
+func (c *encoderSet) newSliceEncoder(t reflect.Type) encoderFunc {
 	// Byte slices get special treatment; arrays don't.
 	if t.Elem().Kind() == reflect.Uint8 {
-		if t == uuidType {
-			return encodeUuid
-		}
 		return encodeByteSlice
 	}
-	enc := &sliceEncoder{newArrayEncoder(t)}
+	if t == listSliceType {
+		// A nil List is simply an empty one.
+		enc := &arrayEncoder{c.typeEncoder(t.Elem()), '(', ')'}
+		return enc.encode
+	}
+	enc := &sliceEncoder{c.newArrayEncoder(t)}
 	return enc.encode
 }
 
 type arrayEncoder struct {
-	elemEnc encoderFunc
+	elemEnc     encoderFunc
+	open, close byte // brackets for vectors, parentheses for lists
 }
 
 func (ae *arrayEncoder) encode(e *encodeState, v reflect.Value) {
-	e.WriteByte('[')
+	e.enter()
+	e.WriteByte(ae.open)
 	n := v.Len()
-	for i := 0; i < n; i++ {
+	if p := e.opts.parallelism; p > 1 && n >= minParallelLen {
+		ae.encodeParallel(e, v, p)
+	} else {
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				e.WriteByte(' ')
+				e.flush()
+			}
+			ae.elemEnc(e, v.Index(i))
+		}
+	}
+	e.WriteByte(ae.close)
+	e.leave()
+}
+
+// minParallelLen is the shortest slice or array whose elements are
+// encoded concurrently when the parallelism option is set.
+const minParallelLen = 4096
+
+// encodeParallel encodes the elements of v in p chunks concurrently,
+// each into its own buffer, and then writes the buffers to e in order.
+func (ae *arrayEncoder) encodeParallel(e *encodeState, v reflect.Value, p int) {
+	n := v.Len()
+	size := (n + p - 1) / p
+	parts := make([]*encodeState, 0, p)
+	panics := make([]interface{}, p)
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += size {
+		pe := newEncodeState()
+		pe.opts = e.opts
+		pe.depth = e.depth
+		parts = append(parts, pe)
+		wg.Add(1)
+		go func(i int, pe *encodeState, start, end int) {
+			defer wg.Done()
+			// Errors are panics; hand them to the calling goroutine.
+			defer func() { panics[i] = recover() }()
+			for j := start; j < end; j++ {
+				if j > start {
+					pe.WriteByte(' ')
+				}
+				ae.elemEnc(pe, v.Index(j))
+			}
+		}(len(parts)-1, pe, start, min(start+size, n))
+	}
+	wg.Wait()
+	for i := range parts {
+		if panics[i] != nil {
+			panic(panics[i])
+		}
+	}
+	for i, pe := range parts {
 		if i > 0 {
 			e.WriteByte(' ')
 		}
-		ae.elemEnc(e, v.Index(i))
+		e.Write(pe.Bytes())
+		putEncodeState(pe)
+		e.flush()
 	}
-	e.WriteByte(']')
 }
 
-func newArrayEncoder(t reflect.Type) encoderFunc {
-	enc := &arrayEncoder{typeEncoder(t.Elem())}
+func (c *encoderSet) newArrayEncoder(t reflect.Type) encoderFunc {
+	enc := &arrayEncoder{c.typeEncoder(t.Elem()), '[', ']'}
+	return enc.encode
+}
+
+// seqEncoder encodes range-over-func iterators: an iter.Seq as a list of
+// the values it yields, and an iter.Seq2 as a map of the pairs it
+// yields. Values are encoded as they are produced, without collecting
+// them first.
+type seqEncoder struct {
+	yieldType          reflect.Type
+	keyEnc, elemEnc    encoderFunc // keyEnc is nil for iter.Seq
+	open, sep, closing string      // sep separates the values of an iter.Seq
+}
+
+func (se *seqEncoder) encode(e *encodeState, v reflect.Value) {
+	if v.IsNil() {
+		e.WriteString("nil")
+		return
+	}
+	e.enter()
+	e.WriteString(se.open)
+	if se.keyEnc != nil && (e.opts.sortMapKeys || e.opts.checkKeys) {
+		for i, text := range se.entries(e, v) {
+			if i > 0 {
+				e.WriteString(e.entrySep())
+				e.flush()
+			}
+			e.Write(text)
+		}
+		e.WriteString(se.closing)
+		e.leave()
+		return
+	}
+	sep := se.sep
+	if se.keyEnc != nil {
+		sep = e.entrySep()
+	}
+	first := true
+	yield := reflect.MakeFunc(se.yieldType, func(args []reflect.Value) []reflect.Value {
+		if first {
+			first = false
+		} else {
+			e.WriteString(sep)
+			e.flush()
+		}
+		if se.keyEnc != nil {
+			se.keyEnc(e, args[0])
+			e.WriteByte(' ')
+			se.elemEnc(e, args[1])
+		} else {
+			se.elemEnc(e, args[0])
+		}
+		return []reflect.Value{reflect.ValueOf(true)}
+	})
+	v.Call([]reflect.Value{yield})
+	e.WriteString(se.closing)
+	e.leave()
+}
+
+// entries encodes the pairs yielded by the iter.Seq2 v, and returns the
+// text of each, ordered by the text of their keys if the sortMapKeys
+// option is set. As with maps, it fails if two keys encode the same.
+func (se *seqEncoder) entries(e *encodeState, v reflect.Value) [][]byte {
+	ee := &encodeState{opts: e.opts, depth: e.depth}
+	var keyEnds, ends []int
+	yield := reflect.MakeFunc(se.yieldType, func(args []reflect.Value) []reflect.Value {
+		se.keyEnc(ee, args[0])
+		keyEnds = append(keyEnds, ee.Len())
+		ee.WriteByte(' ')
+		se.elemEnc(ee, args[1])
+		ends = append(ends, ee.Len())
+		return []reflect.Value{reflect.ValueOf(true)}
+	})
+	v.Call([]reflect.Value{yield})
+	buf := ee.Bytes()
+	texts := make([][]byte, len(ends))
+	keys := make([][]byte, len(ends))
+	start := 0
+	for i, end := range ends {
+		texts[i], keys[i] = buf[start:end], buf[start:keyEnds[i]]
+		start = end
+	}
+	order := make([]int, len(texts))
+	for i := range order {
+		order[i] = i
+	}
+	if e.opts.sortMapKeys {
+		sort.SliceStable(order, func(i, j int) bool {
+			return bytes.Compare(keys[order[i]], keys[order[j]]) < 0
+		})
+	}
+	seen := make(map[string]bool, len(keys))
+	sorted := make([][]byte, len(texts))
+	for i, n := range order {
+		if seen[string(keys[n])] {
+			e.error(&DuplicateKeyError{v.Type(), string(keys[n])})
+		}
+		seen[string(keys[n])] = true
+		sorted[i] = texts[n]
+	}
+	return sorted
+}
+
+// newSeqEncoder returns an encoder for t if it has the shape of
+// iter.Seq[V] or iter.Seq2[K, V], and nil otherwise.
+func (c *encoderSet) newSeqEncoder(t reflect.Type) encoderFunc {
+	if t.NumIn() != 1 || t.NumOut() != 0 || t.IsVariadic() {
+		return nil
+	}
+	yt := t.In(0)
+	if yt.Kind() != reflect.Func || yt.NumOut() != 1 || yt.Out(0).Kind() != reflect.Bool || yt.IsVariadic() {
+		return nil
+	}
+	switch yt.NumIn() {
+	case 1:
+		se := &seqEncoder{yieldType: yt, elemEnc: c.typeEncoder(yt.In(0)), open: "(", sep: " ", closing: ")"}
+		return se.encode
+	case 2:
+		se := &seqEncoder{yieldType: yt, keyEnc: c.typeEncoder(yt.In(0)), elemEnc: c.typeEncoder(yt.In(1)), open: "{", closing: "}"}
+		return se.encode
+	}
+	return nil
+}
+
+// syncMapEncoder writes a sync.Map as an EDN map of the entries seen by
+// its Range method.
+func syncMapEncoder(e *encodeState, v reflect.Value) {
+	m := make(map[interface{}]interface{})
+	addrOf(v).Interface().(*sync.Map).Range(func(k, v interface{}) bool {
+		m[k] = v
+		return true
+	})
+	e.reflectValue(reflect.ValueOf(m))
+}
+
+// errorEncoder writes values implementing error as #error maps when the
+// encodeErrors option is set, and with elseEnc otherwise.
+type errorEncoder struct {
+	elseEnc encoderFunc
+}
+
+func (ee *errorEncoder) encode(e *encodeState, v reflect.Value) {
+	if !e.opts.encodeErrors {
+		ee.elseEnc(e, v)
+		return
+	}
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		e.WriteString("nil")
+		return
+	}
+	e.errorMap(v.Interface().(error))
+}
+
+// errorMap writes err in the style of Clojure's Throwable->map, as
+// #error {:message "...", :type "...", :cause #error {...}}, where the
+// cause is present if err wraps another error.
+func (e *encodeState) errorMap(err error) {
+	e.enter()
+	sep := e.entrySep()
+	e.WriteString("#error {:message ")
+	e.string(err.Error())
+	e.WriteString(sep)
+	e.flush()
+	e.WriteString(":type ")
+	e.string(reflect.TypeOf(err).String())
+	if cause := errors.Unwrap(err); cause != nil {
+		e.WriteString(sep)
+		e.flush()
+		e.WriteString(":cause ")
+		e.errorMap(cause)
+	}
+	e.WriteByte('}')
+	e.leave()
+}
+
+func newErrorEncoder(elseEnc encoderFunc) encoderFunc {
+	enc := &errorEncoder{elseEnc}
+	return enc.encode
+}
+
+// stringerEncoder writes values implementing fmt.Stringer as the string
+// their String method returns when the stringers option is set, and
+// with elseEnc otherwise.
+type stringerEncoder struct {
+	elseEnc encoderFunc
+}
+
+func (se *stringerEncoder) encode(e *encodeState, v reflect.Value) {
+	if !e.opts.stringers {
+		se.elseEnc(e, v)
+		return
+	}
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		e.WriteString("nil")
+		return
+	}
+	e.string(v.Interface().(fmt.Stringer).String())
+}
+
+func newStringerEncoder(elseEnc encoderFunc) encoderFunc {
+	enc := &stringerEncoder{elseEnc}
 	return enc.encode
 }
 
@@ -488,8 +2068,8 @@ func (pe *ptrEncoder) encode(e *encodeState, v reflect.Value) {
 	pe.elemEnc(e, v.Elem())
 }
 
-func newPtrEncoder(t reflect.Type) encoderFunc {
-	enc := &ptrEncoder{typeEncoder(t.Elem())}
+func (c *encoderSet) newPtrEncoder(t reflect.Type) encoderFunc {
+	enc := &ptrEncoder{c.typeEncoder(t.Elem())}
 	return enc.encode
 }
 
@@ -513,15 +2093,276 @@ func newCondAddrEncoder(canAddrEnc, elseEnc encoderFunc) encoderFunc {
 }
 
 func listEncoder(e *encodeState, v reflect.Value) {
-	l := v.Interface().(list.List)
-	i := 0
+	var l *list.List
+	if v.CanAddr() {
+		l = v.Addr().Interface().(*list.List)
+	} else {
+		// Elements of a copied list still link to each other and to
+		// the original list, so the copy can be walked like it.
+		cp := v.Interface().(list.List)
+		l = &cp
+	}
+	e.enter()
 	e.WriteByte('(')
 	for node := l.Front(); node != nil; node = node.Next() {
-		if i > 0 {
+		if node.Prev() != nil {
 			e.WriteByte(' ')
+			e.flush()
+		}
+		e.value(node.Value)
+	}
+	e.WriteByte(')')
+	e.leave()
+}
+
+// ringEncoder writes a ring.Ring as a list of the values in the ring,
+// starting with the given element.
+func ringEncoder(e *encodeState, v reflect.Value) {
+	var r *ring.Ring
+	if v.CanAddr() {
+		r = v.Addr().Interface().(*ring.Ring)
+	} else {
+		// A copy of a ring element links to the ring's other elements
+		// but is not itself part of the ring; find the original through
+		// its predecessor.
+		cp := v.Interface().(ring.Ring)
+		r = cp.Prev().Next()
+	}
+	e.enter()
+	e.WriteByte('(')
+	for p := r; ; {
+		e.value(p.Value)
+		if p = p.Next(); p == r {
+			break
 		}
-		e.reflectValue(reflect.ValueOf(node.Value))
-		i++
+		e.WriteByte(' ')
+		e.flush()
 	}
 	e.WriteByte(')')
+	e.leave()
+}
+
+// A field represents a single field found in a struct.
+type field struct {
+	name      string // key name, without the leading colon
+	tag       bool   // whether name came from the edn tag
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+	omitZero  bool
+	emitNil   bool // write a nil map or slice as nil
+	emitEmpty bool // write a nil map or slice as an empty one
+	keyStyle  keyStyle
+}
+
+// A keyStyle selects how a struct field's key is written: as a keyword
+// (the default), a string, or a symbol. It is set with the "key" tag
+// option, either on the field itself or, for all fields of a struct,
+// on a blank field:
+//
+//	_ struct{} `edn:",key=string"`
+type keyStyle int
+
+const (
+	keywordKey keyStyle = iota
+	stringKey
+	symbolKey
+)
+
+// parseKeyStyle returns the key style named by the "key" option in
+// opts, or def if there is none or it is not recognized.
+func parseKeyStyle(opts tagOptions, def keyStyle) keyStyle {
+	s, _ := opts.Get("key")
+	switch s {
+	case "keyword":
+		return keywordKey
+	case "string":
+		return stringKey
+	case "symbol":
+		return symbolKey
+	}
+	return def
+}
+
+// byIndex sorts fields by their index sequence.
+type byIndex []field
+
+func (x byIndex) Len() int      { return len(x) }
+func (x byIndex) Swap(i, j int) { x[i], x[j] = x[j], x[i] }
+func (x byIndex) Less(i, j int) bool {
+	for k, xik := range x[i].index {
+		if k >= len(x[j].index) {
+			return false
+		}
+		if xik != x[j].index[k] {
+			return xik < x[j].index[k]
+		}
+	}
+	return len(x[i].index) < len(x[j].index)
+}
+
+// typeFields returns a list of fields that EDN should recognize for the
+// given type. Fields of embedded structs are promoted, following the
+// same visibility rules as Go itself: a shallower field hides deeper
+// ones with the same name, and fields that conflict at the same depth
+// are dropped unless exactly one of them is named by an edn tag.
+func typeFields(t reflect.Type) []field {
+	var fields []field
+	current := []field{}
+	next := []field{{typ: t}}
+	visited := map[reflect.Type]bool{}
+
+	for len(next) > 0 {
+		current, next = next, current[:0]
+		for _, f := range current {
+			if visited[f.typ] {
+				continue
+			}
+			visited[f.typ] = true
+
+			style := parseKeyStyle(structOptions(f.typ), keywordKey)
+
+			for i := 0; i < f.typ.NumField(); i++ {
+				sf := f.typ.Field(i)
+				if sf.PkgPath != "" && !sf.Anonymous { // unexported
+					continue
+				}
+				tag := sf.Tag.Get("edn")
+				if tag == "-" {
+					continue
+				}
+				name, opts := parseTag(tag)
+				name = strings.TrimPrefix(name, ":")
+				index := make([]int, len(f.index)+1)
+				copy(index, f.index)
+				index[len(f.index)] = i
+
+				ft := sf.Type
+				if ft.Name() == "" && ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+
+				// Record found field and index sequence.
+				if name != "" || !sf.Anonymous || ft.Kind() != reflect.Struct || ft == listType || ft == ringType {
+					if sf.PkgPath != "" {
+						continue
+					}
+					tagged := name != ""
+					if name == "" {
+						name = keywordName(sf.Name)
+					}
+					fields = append(fields, field{
+						name:      name,
+						tag:       tagged,
+						index:     index,
+						typ:       sf.Type,
+						omitEmpty: opts.Contains("omitempty"),
+						omitZero:  opts.Contains("omitzero"),
+						emitNil:   opts.Contains("emitnil"),
+						emitEmpty: opts.Contains("emitempty"),
+						keyStyle:  parseKeyStyle(opts, style),
+					})
+					continue
+				}
+
+				// Record new anonymous struct to explore in next round.
+				next = append(next, field{typ: ft, index: index})
+			}
+		}
+	}
+
+	// Fields were appended in breadth-first order, so the first fields
+	// with a given name are the shallowest. If there are several at that
+	// depth, a single tagged one wins; otherwise the name is ambiguous
+	// and all of them are dropped.
+	byName := map[string][]field{}
+	var names []string
+	for _, f := range fields {
+		fs := byName[f.name]
+		if len(fs) > 0 && len(fs[0].index) < len(f.index) {
+			continue
+		}
+		if fs == nil {
+			names = append(names, f.name)
+		}
+		byName[f.name] = append(fs, f)
+	}
+	fields = fields[:0]
+	for _, name := range names {
+		if f, ok := dominantField(byName[name]); ok {
+			fields = append(fields, f)
+		}
+	}
+	sort.Sort(byIndex(fields))
+	return fields
+}
+
+// structOptions returns the options given in the edn tags of t's blank
+// (_) fields, which apply to the struct as a whole.
+func structOptions(t reflect.Type) tagOptions {
+	var opts []string
+	for i := 0; i < t.NumField(); i++ {
+		if sf := t.Field(i); sf.Name == "_" {
+			if _, o := parseTag(sf.Tag.Get("edn")); o != "" {
+				opts = append(opts, string(o))
+			}
+		}
+	}
+	return tagOptions(strings.Join(opts, ","))
+}
+
+// dominantField picks the field that wins among fields sharing a name
+// at the same depth. The bool is false if no single field wins.
+func dominantField(fields []field) (field, bool) {
+	if len(fields) == 1 {
+		return fields[0], true
+	}
+	var winner field
+	found := false
+	for _, f := range fields {
+		if f.tag {
+			if found {
+				return field{}, false
+			}
+			winner, found = f, true
+		}
+	}
+	return winner, found
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// keywordName converts a Go field name to the conventional lower-case,
+// hyphenated keyword name: FirstName becomes first-name and HTTPPort
+// becomes http-port.
+func keywordName(s string) string {
+	rs := []rune(s)
+	buf := make([]rune, 0, len(rs)+4)
+	for i, r := range rs {
+		if unicode.IsUpper(r) {
+			if i > 0 && (!unicode.IsUpper(rs[i-1]) ||
+				i+1 < len(rs) && unicode.IsLower(rs[i+1])) {
+				buf = append(buf, '-')
+			}
+			r = unicode.ToLower(r)
+		}
+		buf = append(buf, r)
+	}
+	return string(buf)
 }
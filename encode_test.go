@@ -4,10 +4,23 @@
 package edn
 
 import (
-	"code.google.com/p/go-uuid/uuid"
+	"bytes"
+	"container/heap"
 	"container/list"
+	"container/ring"
+	"encoding/json"
+	"errors"
 	"fmt"
-	. "gopkg.in/check.v1"
+	"gopkg.in/check.v1"
+	"iter"
+	"maps"
+	"math"
+	"math/big"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
 	"testing/quick"
 	"time"
 	"unicode/utf8"
@@ -15,9 +28,7 @@ import (
 
 type EncodeTests struct{}
 
-func init() { Suite(&EncodeTests{}) }
-
-type vector []interface{}
+func init() { check.Suite(&EncodeTests{}) }
 
 type ednMap map[interface{}]interface{}
 
@@ -26,31 +37,32 @@ type pair struct {
 	want  string
 }
 
-func checkMarshal(c *C, pairs ...pair) {
+func checkMarshal(c *check.C, pairs ...pair) {
 	for _, p := range pairs {
 		if b, err := Marshal(p.input); err == nil {
-			c.Check(string(b), Equals, p.want, Commentf("%v didn't marshal to %q", p.input, p.want))
+			c.Check(string(b), check.Equals, p.want, check.Commentf("%v didn't marshal to %q", p.input, p.want))
 		} else {
 			c.Error(err)
 		}
 	}
 }
 
-func (*EncodeTests) TestUnsupportedType(c *C) {
+func (*EncodeTests) TestUnsupportedType(c *check.C) {
 	_, err := Marshal(make(chan int))
 	c.Log(err.(*UnsupportedTypeError))
 }
 
-func (*EncodeTests) TestPrimitives(c *C) {
+func (*EncodeTests) TestPrimitives(c *check.C) {
 	anInt := int(33)
 	ptrToInt := &anInt
 	utc, _ := time.LoadLocation("UTC")
 	aTime := time.Date(2014, 3, 14, 15, 59, 59, 123456789, utc)
 	aTimePtr := &aTime
 	var nilTimePtr *time.Time
-	aUuid := uuid.Parse("7594599c-2df6-412f-8ca0-8ef31448d923")
+	aUuid := UUID{0x75, 0x94, 0x59, 0x9c, 0x2d, 0xf6, 0x41, 0x2f, 0x8c, 0xa0, 0x8e, 0xf3, 0x14, 0x48, 0xd9, 0x23}
 	aUuidPtr := &aUuid
-	var nilUuidPtr *uuid.UUID
+	var nilUuidPtr *UUID
+	aDuration := 90 * time.Minute
 	checkMarshal(
 		c,
 		// literal nil
@@ -73,7 +85,7 @@ func (*EncodeTests) TestPrimitives(c *C) {
 		// reflect.Float32
 		pair{float32(3.14), "3.14"},
 		// reflect.Float64
-		pair{float64(3.14E+250), "3.14e+250"},
+		pair{float64(3.14e+250), "3.14e+250"},
 		// reflect.String
 		pair{`Russian for hello is "привет".`, `"Russian for hello is \"привет\"."`},
 		pair{"Not really UTF-8: Espa\xf1a", "\"Not really UTF-8: Espa\ufffda\""},
@@ -89,14 +101,39 @@ func (*EncodeTests) TestPrimitives(c *C) {
 		pair{aTime, `#inst "2014-03-14T15:59:59.123456789Z"`},
 		pair{aTimePtr, `#inst "2014-03-14T15:59:59.123456789Z"`},
 		pair{nilTimePtr, "nil"},
-		// uuid.UUID
+		// UUID types
 		pair{aUuid, `#uuid "7594599c-2df6-412f-8ca0-8ef31448d923"`},
 		pair{aUuidPtr, `#uuid "7594599c-2df6-412f-8ca0-8ef31448d923"`},
 		pair{nilUuidPtr, "nil"},
+		// time.Duration
+		pair{aDuration, `#go/duration "1h30m0s"`},
+		pair{&aDuration, `#go/duration "1h30m0s"`},
+		pair{-time.Millisecond, `#go/duration "-1ms"`},
 	)
 }
 
-func (*EncodeTests) TestEnsureUtf8(c *C) {
+func (*EncodeTests) TestSpecialSymbols(c *check.C) {
+	checkMarshal(
+		c,
+		pair{S("/"), "/"},
+		pair{S("foo//"), "foo//"},
+		pair{S("clojure.core//"), "clojure.core//"},
+		pair{S("-"), "-"},
+		pair{S("+"), "+"},
+		pair{S("."), "."},
+		pair{S("-foo"), "-foo"},
+		pair{S(".foo"), ".foo"},
+		pair{S("->x"), "->x"},
+		pair{S("foo/-bar"), "foo/-bar"},
+		pair{K("-1"), ":-1"},
+	)
+	for _, s := range []Symbol{"1", "1a", "-1", "+2x", ".5", "foo/-1", "9ns/foo"} {
+		_, err := Marshal(s)
+		c.Check(err, check.FitsTypeOf, &UnsupportedValueError{}, check.Commentf("symbol %q", s))
+	}
+}
+
+func (*EncodeTests) TestEnsureUtf8(c *check.C) {
 	f1 := func(x string) bool {
 		r := ensureUtf8(x)
 		if utf8.ValidString(x) {
@@ -114,11 +151,11 @@ func (*EncodeTests) TestEnsureUtf8(c *C) {
 			return utf8.ValidString(r)
 		}
 	}
-	c.Check(quick.Check(f1, nil), IsNil)
-	c.Check(quick.Check(f2, nil), IsNil)
+	c.Check(quick.Check(f1, nil), check.IsNil)
+	c.Check(quick.Check(f2, nil), check.IsNil)
 }
 
-func (*EncodeTests) TestMaps(c *C) {
+func (*EncodeTests) TestMaps(c *check.C) {
 	checkMarshal(
 		c,
 		pair{map[int]string(nil), "{}"},
@@ -136,24 +173,24 @@ func (*EncodeTests) TestMaps(c *C) {
 	)
 }
 
-func (*EncodeTests) TestSlicesAndArrays(c *C) {
+func (*EncodeTests) TestSlicesAndArrays(c *check.C) {
 	checkMarshal(
 		c,
 		// reflect.Slice
 		pair{[]string(nil), "[]"},
 		pair{[]string{}, "[]"},
 		pair{[]int{-1111}, "[-1111]"},
-		pair{[]vector{vector{-1, vector{0}, ednMap{K("answer"): 42}}}, "[[-1 [0] {:answer 42}]]"},
+		pair{[]Vec{Vec{-1, Vec{0}, ednMap{K("answer"): 42}}}, "[[-1 [0] {:answer 42}]]"},
 		pair{[]string{"a", "b", "ц"}, `["a" "b" "ц"]`},
 		pair{[]byte("any + old & data"), `#base64 "YW55ICsgb2xkICYgZGF0YQ=="`},
 		// reflect.Array
-		pair{[4]interface{}{"a", vector{nil, nil}, 3.14E-33, 5}, `["a" [nil nil] 3.14e-33 5]`},
+		pair{[4]interface{}{"a", Vec{nil, nil}, 3.14e-33, 5}, `["a" [nil nil] 3.14e-33 5]`},
 		pair{[1]int16{32767}, "[32767]"},
 		pair{[3]byte{65, 66, 67}, "[65 66 67]"},
 	)
 }
 
-func (*EncodeTests) TestSets(c *C) {
+func (*EncodeTests) TestSets(c *check.C) {
 	checkMarshal(
 		c,
 		pair{Set(nil), "#{}"},
@@ -162,7 +199,7 @@ func (*EncodeTests) TestSets(c *C) {
 	)
 }
 
-func (*EncodeTests) TestLists(c *C) {
+func (*EncodeTests) TestLists(c *check.C) {
 	var nilPtr *list.List
 	l1 := list.List{}
 	l1.PushBack(1)
@@ -171,14 +208,68 @@ func (*EncodeTests) TestLists(c *C) {
 	l2.PushFront("a")
 	l2.PushFront(Set{}.Add("b"))
 	l2.PushFront(S("c"))
-	l2.PushFront(vector{"d", "e"})
+	l2.PushFront(Vec{"d", "e"})
 	l2.PushFront(K("f"))
+	nested := list.New()
+	nested.PushBack(&l1)
+	inner := list.New()
+	inner.PushBack(list.New())
+	inner.Front().Value.(*list.List).PushBack(l1)
+	nested.PushBack(inner)
 	checkMarshal(
 		c,
 		pair{nilPtr, "nil"},
 		pair{list.List{}, "()"},
 		pair{l1, "(1 :two)"},
 		pair{l2, `(:f ["d" "e"] c #{"b"} "a")`},
+		pair{[]interface{}{l2.Front().Next().Value, &l1, l1}, `[["d" "e"] (1 :two) (1 :two)]`},
+		pair{map[string]interface{}{"l": nested}, `{"l" ((1 :two) (((1 :two))))}`},
+		// edn.List
+		pair{List(nil), "()"},
+		pair{List{}, "()"},
+		pair{List{S("+"), 1, List{K("a"), "b"}, Vec{2}}, `(+ 1 (:a "b") [2])`},
+		pair{&List{nil}, "(nil)"},
+	)
+}
+
+type intHeap []int
+
+func (h intHeap) Len() int            { return len(h) }
+func (h intHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h intHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *intHeap) Push(x interface{}) { *h = append(*h, x.(int)) }
+func (h *intHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+func (*EncodeTests) TestRingsAndHeaps(c *check.C) {
+	var nilPtr *ring.Ring
+	r := ring.New(3)
+	for i := 0; i < 3; i++ {
+		r.Value = i
+		r = r.Next()
+	}
+	type holder struct {
+		R ring.Ring
+		P *ring.Ring
+	}
+	h := &intHeap{5, 2, 8}
+	heap.Init(h)
+	heap.Push(h, 1)
+	checkMarshal(
+		c,
+		pair{nilPtr, "nil"},
+		pair{ring.Ring{}, "(nil)"},
+		pair{r, "(0 1 2)"},
+		pair{r.Next(), "(1 2 0)"},
+		pair{*r.Prev(), "(2 0 1)"},
+		pair{[]interface{}{ring.New(1)}, "[(nil)]"},
+		pair{holder{P: r}, "{:r (nil), :p (0 1 2)}"},
+		// A heap is written in its backing slice's order.
+		pair{h, "[1 2 8 5]"},
 	)
 }
 
@@ -191,6 +282,619 @@ func (cool coolness) MarshalText() (data []byte, err error) {
 	return
 }
 
-func (*EncodeTests) TestCustomTextMarshal(c *C) {
+func (*EncodeTests) TestCustomTextMarshal(c *check.C) {
 	checkMarshal(c, pair{coolness{true}, `"cool=true"`})
 }
+
+type Point struct {
+	X, Y int
+}
+
+type Named struct {
+	Name string
+}
+
+type shape struct {
+	Named
+	*Point
+	Kind      Keyword
+	Vertices  []Point
+	HTTPPort  int
+	UserID    string
+	Origin    *Point
+	hidden    bool
+	Extra     interface{}
+	Timestamp time.Time
+}
+
+type alias struct {
+	Name string
+}
+
+type conflicting struct {
+	Named
+	alias
+	Point
+	X string
+}
+
+func (*EncodeTests) TestStructs(c *check.C) {
+	var nilShape *shape
+	aTime := time.Date(2014, 3, 14, 15, 59, 59, 0, time.UTC)
+	checkMarshal(
+		c,
+		pair{struct{}{}, "{}"},
+		pair{Point{1, -2}, "{:x 1, :y -2}"},
+		pair{&Point{3, 4}, "{:x 3, :y 4}"},
+		pair{nilShape, "nil"},
+		pair{
+			shape{
+				Named:     Named{"tri"},
+				Kind:      K("polygon"),
+				Vertices:  []Point{{0, 0}, {1, 0}, {0, 1}},
+				HTTPPort:  80,
+				UserID:    "u1",
+				hidden:    true,
+				Extra:     Point{5, 6},
+				Timestamp: aTime,
+			},
+			`{:name "tri", :kind :polygon, :vertices [{:x 0, :y 0} {:x 1, :y 0} {:x 0, :y 1}], ` +
+				`:http-port 80, :user-id "u1", :origin nil, :extra {:x 5, :y 6}, ` +
+				`:timestamp #inst "2014-03-14T15:59:59Z"}`,
+		},
+		pair{
+			shape{Point: &Point{7, 8}},
+			`{:name "", :x 7, :y 8, :kind :, :vertices [], :http-port 0, :user-id "", ` +
+				`:origin nil, :extra nil, :timestamp #inst "0001-01-01T00:00:00Z"}`,
+		},
+		// Name is ambiguous between two embedded structs and is dropped;
+		// the outer X hides the one promoted from Point.
+		pair{conflicting{Point: Point{1, 2}, X: "x"}, `{:y 2, :x "x"}`},
+	)
+}
+
+type tagged struct {
+	Renamed   string            `edn:"my-name"`
+	Colon     int               `edn:":ns/colon"`
+	Skipped   string            `edn:"-"`
+	Dash      string            `edn:"-,"`
+	Empty     string            `edn:",omitempty"`
+	Zero      int               `edn:"zero,omitempty"`
+	NilSlice  []int             `edn:",omitempty"`
+	NilMap    map[string]int    `edn:",omitempty"`
+	NilPtr    *Point            `edn:",omitempty"`
+	False     bool              `edn:",omitempty"`
+	Kept      float64           `edn:",omitempty"`
+	Embedded  Named             `edn:"embedded"`
+	Untouched map[string]string `edn:""`
+}
+
+type nick struct {
+	Nick string `edn:"name"`
+}
+
+type taggedEmbed struct {
+	Named
+	nick
+	Point `edn:"point"`
+}
+
+func (*EncodeTests) TestStructTags(c *check.C) {
+	checkMarshal(
+		c,
+		pair{
+			tagged{Renamed: "r", Colon: 1, Skipped: "s", Dash: "d", Kept: 0.5},
+			`{:my-name "r", :ns/colon 1, :- "d", :kept 0.5, :embedded {:name ""}, :untouched {}}`,
+		},
+		pair{
+			tagged{Empty: "e", Zero: 2, NilSlice: []int{}, NilPtr: &Point{}, False: true},
+			`{:my-name "", :ns/colon 0, :- "", :empty "e", :zero 2, :nil-ptr {:x 0, :y 0}, ` +
+				`:false true, :embedded {:name ""}, :untouched {}}`,
+		},
+		// A tagged embedded struct is not promoted, and a tagged field
+		// wins over an untagged one at the same depth.
+		pair{taggedEmbed{Named{"n"}, nick{"nick"}, Point{1, 2}}, `{:name "nick", :point {:x 1, :y 2}}`},
+	)
+}
+
+type zeroable struct {
+	N  int       `edn:",omitzero"`
+	P  Point     `edn:",omitzero"`
+	T  time.Time `edn:",omitzero"`
+	S  []int     `edn:",omitzero"`
+	A  [2]int    `edn:",omitzero"`
+	Pp *Point    `edn:",omitzero,omitempty"`
+}
+
+func (*EncodeTests) TestStructOmitZero(c *check.C) {
+	aTime := time.Date(2014, 3, 14, 15, 59, 59, 0, time.UTC)
+	checkMarshal(
+		c,
+		pair{zeroable{}, "{}"},
+		// time.Time's IsZero ignores the location.
+		pair{zeroable{T: time.Time{}.In(time.FixedZone("x", 3600))}, "{}"},
+		// An empty but non-nil slice is not the zero value.
+		pair{zeroable{S: []int{}, A: [2]int{0, 1}}, "{:s [], :a [0 1]}"},
+		pair{zeroable{N: 1, P: Point{X: 1}, T: aTime, Pp: &Point{}}, `{:n 1, :p {:x 1, :y 0}, :t #inst "2014-03-14T15:59:59Z", :pp {:x 0, :y 0}}`},
+	)
+}
+
+type keyStyles struct {
+	Default int
+	Str     int `edn:",key=string"`
+	Sym     int `edn:"?sym,key=symbol"`
+	Kw      int `edn:"kw,omitempty,key=keyword"`
+	Bogus   int `edn:",key=bogus"`
+}
+
+type stringKeyed struct {
+	_     struct{} `edn:",key=string"`
+	Name  string
+	Kind  Keyword `edn:"kind,key=keyword"`
+	Point         // promoted fields keep Point's own (keyword) style
+}
+
+func (*EncodeTests) TestStructKeyStyles(c *check.C) {
+	checkMarshal(
+		c,
+		pair{keyStyles{1, 2, 3, 4, 5}, `{:default 1, "str" 2, ?sym 3, :kw 4, :bogus 5}`},
+		pair{stringKeyed{Name: "n", Kind: K("k"), Point: Point{1, 2}}, `{"name" "n", :kind :k, :x 1, :y 2}`},
+	)
+}
+
+type accented struct {
+	Café int `edn:"café,key=string"`
+}
+
+func (*EncodeTests) TestStructKeysASCIIOnly(c *check.C) {
+	checkMarshal(c, pair{accented{1}, `{"café" 1}`})
+	var buf bytes.Buffer
+	c.Assert(NewEncoder(&buf, ASCIIOnly(true)).Encode(accented{1}), check.IsNil)
+	c.Check(buf.String(), check.Equals, `{"caf\u00e9" 1}`+"\n")
+}
+
+func BenchmarkMarshalStruct(b *testing.B) {
+	b.ReportAllocs()
+	v := stringKeyed{Name: "n", Kind: K("k"), Point: Point{1, 2}}
+	for i := 0; i < b.N; i++ {
+		Marshal(v)
+	}
+}
+
+type money struct {
+	cents int64
+}
+
+func (m money) MarshalEDN() ([]byte, error) {
+	return []byte(fmt.Sprintf("#money/usd %dM", m.cents)), nil
+}
+
+// MarshalText must lose to MarshalEDN.
+func (m money) MarshalText() ([]byte, error) {
+	return []byte("text"), nil
+}
+
+type ptrMarshaler struct {
+	n int
+}
+
+func (p *ptrMarshaler) MarshalEDN() ([]byte, error) {
+	if p.n < 0 {
+		return nil, fmt.Errorf("negative: %d", p.n)
+	}
+	return []byte(fmt.Sprintf("#{%d}", p.n)), nil
+}
+
+type emptyMarshaler struct{}
+
+func (emptyMarshaler) MarshalEDN() ([]byte, error) {
+	return nil, nil
+}
+
+func (*EncodeTests) TestMarshaler(c *check.C) {
+	var nilPtr *ptrMarshaler
+	checkMarshal(
+		c,
+		pair{money{150}, "#money/usd 150M"},
+		pair{&money{7}, "#money/usd 7M"},
+		pair{&ptrMarshaler{3}, "#{3}"},
+		pair{nilPtr, "nil"},
+		pair{[]interface{}{money{1}, &ptrMarshaler{2}}, "[#money/usd 1M #{2}]"},
+		// Addressable values use the pointer method.
+		pair{&struct{ P ptrMarshaler }{ptrMarshaler{4}}, "{:p #{4}}"},
+	)
+	_, err := Marshal(&ptrMarshaler{-1})
+	c.Check(err, check.ErrorMatches, "edn: error calling MarshalEDN for type \\*edn.ptrMarshaler: negative: -1")
+	_, err = Marshal(emptyMarshaler{})
+	c.Check(err, check.FitsTypeOf, &MarshalerError{})
+}
+
+type digest [4]byte
+
+func (d digest) MarshalBinary() ([]byte, error) {
+	return d[:], nil
+}
+
+type ptrBinary struct {
+	b []byte
+}
+
+func (p *ptrBinary) MarshalBinary() ([]byte, error) {
+	if p.b == nil {
+		return nil, errors.New("empty")
+	}
+	return p.b, nil
+}
+
+// MarshalBinary must lose to MarshalText.
+func (cool coolness) MarshalBinary() ([]byte, error) {
+	return []byte("binary"), nil
+}
+
+func (*EncodeTests) TestBinaryMarshaler(c *check.C) {
+	var nilPtr *ptrBinary
+	checkMarshal(
+		c,
+		pair{digest{1, 2, 3, 4}, `#base64 "AQIDBA=="`},
+		pair{&ptrBinary{[]byte("hi")}, `#base64 "aGk="`},
+		pair{nilPtr, "nil"},
+		pair{&struct{ P ptrBinary }{ptrBinary{[]byte{}}}, `{:p #base64 ""}`},
+		pair{coolness{false}, `"cool=false"`},
+	)
+	_, err := Marshal(&ptrBinary{})
+	c.Check(err, check.ErrorMatches, "edn: error calling MarshalBinary for type \\*edn.ptrBinary: empty")
+}
+
+func (*EncodeTests) TestMarshalCanonical(c *check.C) {
+	for _, p := range []pair{
+		{2.0, "2.0"},
+		{float32(-0.5), "-0.5"},
+		{1e6, "1.0e6"},
+		{1e21, "1.0e21"},
+		{1.5e-7, "1.5e-7"},
+		{3.14e+250, "3.14e250"},
+		{42, "42"},
+		{Set{}.Add(3, 1, 2), "#{1 2 3}"},
+		{KMap{"b": 1.0, "a": Set{}.Add("y", "x")}, `{:a #{"x" "y"} :b 1.0}`},
+		{Point{1, 2}, "{:x 1 :y 2}"},
+		{struct{ B, A int }{1, 2}, "{:a 2 :b 1}"},
+		{KMap{"b": 1, "a": 2}, "{:a 2 :b 1}"},
+		{struct {
+			Z string `edn:"z,key=string"`
+			Y int
+		}{"s", 1}, `{"z" "s" :y 1}`},
+		{[]interface{}{map[int]bool{2: true, 1: false}, 90 * time.Second}, `[{1 false 2 true} #go/duration "1m30s"]`},
+	} {
+		for i := 0; i < 3; i++ {
+			b, err := MarshalCanonical(p.input)
+			c.Assert(err, check.IsNil)
+			c.Check(string(b), check.Equals, p.want)
+		}
+	}
+}
+
+func (*EncodeTests) TestStringEscaping(c *check.C) {
+	checkMarshal(
+		c,
+		pair{"", `""`},
+		pair{"quote \" backslash \\", `"quote \" backslash \\"`},
+		pair{"tab\tnewline\ncr\r", `"tab\tnewline\ncr\r"`},
+		// Everything else, including non-ASCII and other control
+		// characters, is written raw rather than with Go escapes.
+		pair{"bell\a nul\x00 nbsp  ß 😀", "\"bell\a nul\x00 nbsp  ß 😀\""},
+		pair{"bad \xff\xfe utf-8", "\"bad �� utf-8\""},
+		pair{coolness{true}, `"cool=true"`},
+		pair{textBytes("a\"b\n\xffß"), "\"a\\\"b\\n�ß\""},
+	)
+}
+
+type textBytes string
+
+func (t textBytes) MarshalText() ([]byte, error) {
+	return []byte(t), nil
+}
+
+var benchString = strings.Repeat(`Lorem ipsum "dolor" sit amet, привет	мир.`+"\n", 16)
+
+func BenchmarkMarshalString(b *testing.B) {
+	b.ReportAllocs()
+	e := &encodeState{}
+	for i := 0; i < b.N; i++ {
+		e.Reset()
+		e.string(benchString)
+	}
+}
+
+func BenchmarkMarshalTextMarshaler(b *testing.B) {
+	b.ReportAllocs()
+	e := &encodeState{}
+	v := reflect.ValueOf(textBytes(benchString))
+	for i := 0; i < b.N; i++ {
+		e.Reset()
+		textMarshalerEncoder(e, v)
+	}
+}
+
+func (*EncodeTests) TestMarshalString(c *check.C) {
+	s, err := MarshalString(KMap{"a": []int{1}})
+	c.Check(err, check.IsNil)
+	c.Check(s, check.Equals, "{:a [1]}")
+	s, err = MarshalString(math.NaN())
+	c.Check(err, check.NotNil)
+	c.Check(s, check.Equals, "")
+}
+
+func BenchmarkMarshalToString(b *testing.B) {
+	b.ReportAllocs()
+	v := []interface{}{"hello", 42, K("k")}
+	for i := 0; i < b.N; i++ {
+		MarshalString(v)
+	}
+}
+
+func (*EncodeTests) TestMarshalAppend(c *check.C) {
+	buf := make([]byte, 0, 64)
+	out, err := MarshalAppend(buf, []int{1, 2})
+	c.Assert(err, check.IsNil)
+	c.Check(string(out), check.Equals, "[1 2]")
+	c.Check(&out[0], check.Equals, &buf[:1][0])
+
+	out, err = MarshalAppend(append(out, ' '), K("k"))
+	c.Assert(err, check.IsNil)
+	c.Check(string(out), check.Equals, "[1 2] :k")
+
+	kept, err := MarshalAppend(out, math.Inf(1))
+	c.Check(err, check.NotNil)
+	c.Check(string(kept), check.Equals, "[1 2] :k")
+}
+
+func (*EncodeTests) TestDynamicValues(c *check.C) {
+	v := []interface{}{
+		true, 1, int64(-2), 2.5, "s\n", K("k"), nil, []interface{}(nil),
+		map[string]interface{}{"a": []interface{}{map[string]interface{}{}}},
+	}
+	checkMarshal(c, pair{v, `[true 1 -2 2.5 "s\n" :k nil [] {"a" [{}]}]`})
+
+	// Options and tag writers apply as they do to other values.
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, KeywordizeKeys(true), SpecialFloats(true), MaxDepth(3))
+	c.Assert(enc.Encode([]interface{}{map[string]interface{}{"a": math.Inf(1)}}), check.IsNil)
+	c.Check(buf.String(), check.Equals, "[{:a ##Inf}]\n")
+	c.Check(enc.Encode([]interface{}{[]interface{}{[]interface{}{[]interface{}{}}}}), check.ErrorMatches, ".*max depth of 3")
+
+	buf.Reset()
+	enc = NewEncoder(&buf, TagWriter(stringType, "str", func(v interface{}) (interface{}, error) {
+		return len(v.(string)), nil
+	}))
+	c.Assert(enc.Encode([]interface{}{"abc"}), check.IsNil)
+	c.Check(buf.String(), check.Equals, "[#str 3]\n")
+	checkMarshal(c, pair{[]interface{}{"abc"}, `["abc"]`})
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	b.ReportAllocs()
+	v := []interface{}{"hello", 42, K("k")}
+	for i := 0; i < b.N; i++ {
+		Marshal(v)
+	}
+}
+
+func BenchmarkMarshalParallel(b *testing.B) {
+	b.ReportAllocs()
+	v := []interface{}{"hello", 42, K("k")}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			Marshal(v)
+		}
+	})
+}
+
+func BenchmarkMarshalList(b *testing.B) {
+	b.ReportAllocs()
+	l := list.New()
+	for i := 0; i < 100; i++ {
+		l.PushBack(i)
+	}
+	for i := 0; i < b.N; i++ {
+		Marshal(l)
+	}
+}
+
+func BenchmarkMarshalAppend(b *testing.B) {
+	b.ReportAllocs()
+	var buf []byte
+	v := []interface{}{"hello", 42, K("k")}
+	for i := 0; i < b.N; i++ {
+		buf, _ = MarshalAppend(buf[:0], v)
+	}
+}
+
+func (*EncodeTests) TestBigNumbers(c *check.C) {
+	huge, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	var nilInt *big.Int
+	checkMarshal(
+		c,
+		pair{big.NewInt(42), "42N"},
+		pair{*big.NewInt(0), "0N"},
+		pair{huge, "-123456789012345678901234567890N"},
+		pair{nilInt, "nil"},
+		pair{big.NewFloat(3.25), "3.25M"},
+		pair{new(big.Float).SetPrec(200).SetInt64(1000000), "1e+06M"},
+		pair{struct{ N big.Int }{*big.NewInt(7)}, "{:n 7N}"},
+		pair{[]*big.Float{big.NewFloat(-0.5)}, "[-0.5M]"},
+	)
+	_, err := Marshal(new(big.Float).SetInf(false))
+	c.Check(err, check.FitsTypeOf, &UnsupportedValueError{})
+}
+
+func (*EncodeTests) TestJSONNumber(c *check.C) {
+	var nilNum *json.Number
+	checkMarshal(
+		c,
+		pair{json.Number("42"), "42"},
+		pair{json.Number("-0.5"), "-0.5"},
+		pair{json.Number("1E+400"), "1E+400"},
+		pair{json.Number("123456789012345678901234567890"), "123456789012345678901234567890"},
+		pair{json.Number(""), "0"},
+		pair{nilNum, "nil"},
+		pair{map[string]interface{}{"n": json.Number("3.14")}, `{"n" 3.14}`},
+	)
+	for _, n := range []json.Number{"abc", "01", "1.", ".5", "1e", "-", "1 2", "+1", "0x10"} {
+		_, err := Marshal(n)
+		c.Check(err, check.FitsTypeOf, &UnsupportedValueError{}, check.Commentf("json.Number(%q)", string(n)))
+	}
+}
+
+func (*EncodeTests) TestJSONNumberFromDecoder(c *check.C) {
+	dec := json.NewDecoder(strings.NewReader(`{"price": 19.990, "qty": 3, "big": 18446744073709551616}`))
+	dec.UseNumber()
+	var v map[string]interface{}
+	c.Assert(dec.Decode(&v), check.IsNil)
+	b, err := MarshalCanonical(v)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, `{"big" 18446744073709551616 "price" 19.990 "qty" 3}`)
+}
+
+func (*EncodeTests) TestChars(c *check.C) {
+	checkMarshal(
+		c,
+		pair{Char('a'), `\a`},
+		pair{Char('\\'), `\\`},
+		pair{Char('"'), `\"`},
+		pair{Char('\n'), `\newline`},
+		pair{Char('\r'), `\return`},
+		pair{Char(' '), `\space`},
+		pair{Char('\t'), `\tab`},
+		pair{Char(0), `\u0000`},
+		pair{Char('ß'), `\u00df`},
+		pair{Char('\uffff'), `\uffff`},
+		pair{[]Char("hi!"), `[\h \i \!]`},
+	)
+	for _, r := range []Char{-1, 0xd800, 0x1f600, 0x110000} {
+		_, err := Marshal(r)
+		c.Check(err, check.FitsTypeOf, &UnsupportedValueError{}, check.Commentf("%U", rune(r)))
+	}
+}
+
+func (*EncodeTests) TestTagged(c *check.C) {
+	checkMarshal(
+		c,
+		pair{Tagged{"myapp/Point", []int{1, 2}}, "#myapp/Point [1 2]"},
+		pair{&Tagged{"#a/b", nil}, "#a/b nil"},
+		pair{Tagged{"x/y", Tagged{"z/w", K("k")}}, "#x/y #z/w :k"},
+		pair{[]interface{}{Tagged{"t/s", "s"}, 1}, `[#t/s "s" 1]`},
+		pair{Tagged{"inst", "1985-04-12T23:20:50.52Z"}, `#inst "1985-04-12T23:20:50.52Z"`},
+		pair{Tagged{"my.app-v2/Point*", 1}, "#my.app-v2/Point* 1"},
+	)
+	_, err := Marshal(Tagged{Value: 1})
+	c.Check(err, check.FitsTypeOf, &UnsupportedValueError{})
+	for _, tag := range []string{"1bad", "_x", "-x", "a b", "a/", "/", "a/b/c", "#"} {
+		_, err = Marshal(Tagged{tag, 1})
+		c.Check(err, check.FitsTypeOf, &UnsupportedValueError{}, check.Commentf("%q", tag))
+	}
+	_, err = Marshal(Tagged{"1bad", 1})
+	c.Check(err, check.ErrorMatches, `edn: unsupported value: tag #1bad`)
+}
+
+func (*EncodeTests) TestIterators(c *check.C) {
+	var nilSeq iter.Seq[int]
+	countTo := func(n int) iter.Seq[int] {
+		return func(yield func(int) bool) {
+			for i := 1; i <= n; i++ {
+				if !yield(i) {
+					return
+				}
+			}
+		}
+	}
+	pairs := func(yield func(Keyword, interface{}) bool) {
+		_ = yield(K("a"), 1) && yield(K("b"), countTo(2))
+	}
+	checkMarshal(
+		c,
+		pair{countTo(0), "()"},
+		pair{countTo(3), "(1 2 3)"},
+		pair{nilSeq, "nil"},
+		pair{slices.Values([]string{"x", "y"}), `("x" "y")`},
+		pair{iter.Seq2[Keyword, interface{}](pairs), "{:a 1, :b (1 2)}"},
+		pair{Vec{maps.Keys(map[int]bool{7: true})}, "[(7)]"},
+	)
+	b, err := MarshalCanonical(maps.All(map[string]int{"b": 1, "a": 2, "c": 3}))
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, `{"a" 2 "b" 1 "c" 3}`)
+	twice := func(yield func(Keyword, int) bool) {
+		_ = yield(K("a"), 1) && yield(K("a"), 2)
+	}
+	_, err = MarshalCanonical(iter.Seq2[Keyword, int](twice))
+	c.Check(err, check.ErrorMatches, `edn: duplicate map key :a in .*`)
+
+	_, err = Marshal(func(int) {})
+	c.Check(err, check.FitsTypeOf, &UnsupportedTypeError{})
+	_, err = Marshal(func(yield func(chan int) bool) { yield(nil) })
+	c.Check(err, check.FitsTypeOf, &UnsupportedTypeError{})
+}
+
+func (*EncodeTests) TestSyncMap(c *check.C) {
+	var m, empty sync.Map
+	var nilMap *sync.Map
+	m.Store(K("a"), 1)
+	checkMarshal(
+		c,
+		pair{&empty, "{}"},
+		pair{nilMap, "nil"},
+		pair{&m, "{:a 1}"},
+		pair{struct{ Cache *sync.Map }{&m}, "{:cache {:a 1}}"},
+	)
+	m.Store("b", Vec{2})
+	b, err := MarshalCanonical(&m)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, `{"b" [2] :a 1}`)
+}
+
+type widget struct {
+	_    struct{} `edn:",tag=myapp/Widget"`
+	ID   int
+	Tags []Keyword `edn:",omitempty"`
+}
+
+type taggedRecord struct {
+	_    struct{} `edn:",key=string"`
+	_    struct{} `edn:",tag=#rec"`
+	Name string
+}
+
+type sample struct {
+	_     struct{} `edn:",vector"`
+	ID    int
+	Name  string `edn:",omitempty"`
+	OK    bool
+	Attrs map[string]int `edn:",emitnil"`
+}
+
+type taggedSample struct {
+	_ struct{} `edn:",vector,tag=m/sample"`
+	*Point
+	At float64
+}
+
+func (*EncodeTests) TestStructVectorOption(c *check.C) {
+	checkMarshal(
+		c,
+		pair{sample{ID: 1, Name: "x", OK: true}, `[1 "x" true nil]`},
+		pair{[]sample{{ID: 2, Attrs: map[string]int{}}}, `[[2 "" false {}]]`},
+		pair{taggedSample{Point: &Point{1, 2}, At: 0.5}, "#m/sample [1 2 0.5]"},
+		pair{taggedSample{At: 0.5}, "#m/sample [nil nil 0.5]"},
+	)
+}
+
+func (*EncodeTests) TestStructTagOption(c *check.C) {
+	checkMarshal(
+		c,
+		pair{widget{ID: 1}, "#myapp/Widget {:id 1}"},
+		pair{&widget{ID: 2, Tags: []Keyword{"a"}}, "#myapp/Widget {:id 2, :tags [:a]}"},
+		pair{[]widget{{ID: 3}}, "[#myapp/Widget {:id 3}]"},
+		pair{taggedRecord{Name: "r"}, `#rec {"name" "r"}`},
+		// The tag belongs to the struct type, not to structs embedding it.
+		pair{struct{ widget }{widget{ID: 4}}, "{:id 4}"},
+	)
+}
@@ -0,0 +1,454 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Equal reports whether a and b are equal as EDN values, following the
+// rules of Clojure's =, which reflect.DeepEqual does not:
+//
+//   - Integers of any Go type, including *big.Int, are equal if they
+//     have the same value, and so are floats of either size. Integers
+//     are never equal to floats, nor floats to *big.Float decimals.
+//   - Strings, keywords, symbols and characters are distinct from each
+//     other, so "a" is not equal to K("a"). A keyword's leading colon
+//     is optional, as in Marshal.
+//   - Slices, arrays, Vec and List are equal if their elements are
+//     equal in order.
+//   - Maps, including KMap, SMap, HashMap and OrderedMap, are equal if
+//     they have equal keys mapped to equal values, in any order. The
+//     keys of a KMap are keywords and those of an SMap are symbols.
+//   - Sets, Set and SetOf, are equal if they have equal elements.
+//   - time.Time and Instant values are equal if they denote the same
+//     instant, and UUIDs if they have the same text.
+//   - Metadata attached with WithMeta is ignored.
+//
+// Pointers are followed, and nil maps and slices are equal to empty
+// ones. Other values, such as structs, are compared with
+// reflect.DeepEqual.
+func Equal(a, b interface{}) bool {
+	return equalValues(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+// A valueClass groups the Go values that may be equal to each other.
+type valueClass int
+
+const (
+	classBool valueClass = iota
+	classInt
+	classFloat
+	classDecimal
+	classString
+	classKeyword
+	classSymbol
+	classChar
+	classBytes
+	classInst
+	classUUID
+	classSeq
+	classMap
+	classSet
+	classTagged
+	classOther
+)
+
+// ednValue follows pointers and interfaces in v, and unwraps metadata.
+// It returns an invalid Value for nil.
+func ednValue(v reflect.Value) reflect.Value {
+	for v.IsValid() {
+		switch {
+		case v.Kind() == reflect.Ptr && !isSpecialPtr(v.Type()), v.Kind() == reflect.Interface:
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		case v.Type() == metaType:
+			v = reflect.ValueOf(v.Interface().(Meta).Value)
+		default:
+			return v
+		}
+	}
+	return v
+}
+
+// isSpecialPtr reports whether the pointer type t is handled as a
+// whole rather than followed.
+func isSpecialPtr(t reflect.Type) bool {
+	return t.Elem() == bigIntType || t.Elem() == bigFloatType
+}
+
+func classOf(v reflect.Value) valueClass {
+	t := v.Type()
+	switch {
+	case t == keywordType:
+		return classKeyword
+	case t == symbolType:
+		return classSymbol
+	case t == charType:
+		return classChar
+	case t == timeType || t == instantType:
+		return classInst
+	case isUUIDType(t):
+		return classUUID
+	case t == taggedType:
+		return classTagged
+	case t == hashMapType || t == orderedMapType:
+		return classMap
+	case t == bigIntType, t.Kind() == reflect.Ptr && t.Elem() == bigIntType:
+		return classInt
+	case t == bigFloatType, t.Kind() == reflect.Ptr && t.Elem() == bigFloatType:
+		return classDecimal
+	case t.Implements(marshalerType), t.Implements(textMarshalerType):
+		return classOther
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return classBool
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if t == durationType {
+			return classOther
+		}
+		return classInt
+	case reflect.Float32, reflect.Float64:
+		return classFloat
+	case reflect.String:
+		return classString
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return classBytes
+		}
+		return classSeq
+	case reflect.Array:
+		return classSeq
+	case reflect.Map:
+		if isSetType(t) {
+			return classSet
+		}
+		return classMap
+	}
+	return classOther
+}
+
+func equalValues(a, b reflect.Value) bool {
+	a, b = ednValue(a), ednValue(b)
+	if !a.IsValid() || !b.IsValid() {
+		return !a.IsValid() && !b.IsValid()
+	}
+	ca := classOf(a)
+	if ca != classOf(b) {
+		return false
+	}
+	switch ca {
+	case classBool:
+		return a.Bool() == b.Bool()
+	case classInt:
+		return bigIntOf(a).Cmp(bigIntOf(b)) == 0
+	case classFloat:
+		return a.Float() == b.Float()
+	case classDecimal:
+		return bigFloatOf(a).Cmp(bigFloatOf(b)) == 0
+	case classString, classSymbol:
+		return a.String() == b.String()
+	case classKeyword:
+		return strings.TrimPrefix(a.String(), ":") == strings.TrimPrefix(b.String(), ":")
+	case classChar:
+		return a.Int() == b.Int()
+	case classBytes:
+		return bytes.Equal(a.Bytes(), b.Bytes())
+	case classInst:
+		return instTime(a).Equal(instTime(b))
+	case classUUID:
+		return a.Interface().(fmt.Stringer).String() == b.Interface().(fmt.Stringer).String()
+	case classTagged:
+		ta, tb := a.Interface().(Tagged), b.Interface().(Tagged)
+		return strings.TrimPrefix(ta.Tag, "#") == strings.TrimPrefix(tb.Tag, "#") && Equal(ta.Value, tb.Value)
+	case classSeq:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalValues(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case classSet:
+		return equalSets(a, b)
+	case classMap:
+		return equalMaps(a, b)
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+func bigIntOf(v reflect.Value) *big.Int {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return big.NewInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return new(big.Int).SetUint64(v.Uint())
+	case reflect.Ptr:
+		return v.Interface().(*big.Int)
+	}
+	return addrOf(v).Interface().(*big.Int)
+}
+
+func bigFloatOf(v reflect.Value) *big.Float {
+	if v.Kind() == reflect.Ptr {
+		return v.Interface().(*big.Float)
+	}
+	return addrOf(v).Interface().(*big.Float)
+}
+
+func instTime(v reflect.Value) time.Time {
+	if v.Type() == instantType {
+		return v.Interface().(Instant).Time
+	}
+	return v.Interface().(time.Time)
+}
+
+// equalSets compares two sets. Elements found by Go equality are
+// looked up directly; the rest are matched by searching.
+func equalSets(a, b reflect.Value) bool {
+	if a.Len() != b.Len() {
+		return false
+	}
+	bkeys := b.MapKeys()
+	for _, k := range a.MapKeys() {
+		if k.Type().AssignableTo(b.Type().Key()) && b.MapIndex(k).IsValid() {
+			continue
+		}
+		found := false
+		for _, bk := range bkeys {
+			if equalValues(k, bk) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+type mapEntry struct {
+	k, v reflect.Value
+}
+
+// mapEntries returns the entries of the map-like value v, with the
+// keys of KMaps and SMaps converted to keywords and symbols.
+func mapEntries(v reflect.Value) []mapEntry {
+	var ents []mapEntry
+	switch v.Type() {
+	case hashMapType:
+		m := v.Interface().(HashMap)
+		m.Range(func(k, x interface{}) bool {
+			ents = append(ents, mapEntry{reflect.ValueOf(k), reflect.ValueOf(x)})
+			return true
+		})
+	case orderedMapType:
+		m := v.Interface().(OrderedMap)
+		for _, k := range m.keys {
+			ents = append(ents, mapEntry{reflect.ValueOf(k), reflect.ValueOf(m.vals[k])})
+		}
+	default:
+		iter := v.MapRange()
+		for iter.Next() {
+			k := iter.Key()
+			switch v.Type() {
+			case keywordMapType:
+				k = reflect.ValueOf(Keyword(k.String()))
+			case symbolMapType:
+				k = reflect.ValueOf(Symbol(k.String()))
+			}
+			ents = append(ents, mapEntry{k, iter.Value()})
+		}
+	}
+	return ents
+}
+
+// equalMaps compares two map-like values. As in equalSets, keys found
+// by Go equality are looked up directly.
+func equalMaps(a, b reflect.Value) bool {
+	ents := mapEntries(a)
+	var bents []mapEntry // built on first need
+	direct := b.Kind() == reflect.Map && b.Type() != keywordMapType && b.Type() != symbolMapType
+	if direct && len(ents) != b.Len() {
+		return false
+	}
+	if !direct {
+		bents = mapEntries(b)
+		if len(ents) != len(bents) {
+			return false
+		}
+	}
+	for _, ea := range ents {
+		if direct && ea.k.Comparable() && ea.k.Type().AssignableTo(b.Type().Key()) {
+			if bv := b.MapIndex(ea.k); bv.IsValid() {
+				if !equalValues(ea.v, bv) {
+					return false
+				}
+				continue
+			}
+		}
+		if bents == nil {
+			bents = mapEntries(b)
+		}
+		found := false
+		for _, eb := range bents {
+			if equalValues(ea.k, eb.k) {
+				if !equalValues(ea.v, eb.v) {
+					return false
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Hash returns a hash of v consistent with Equal: values that are
+// Equal have the same hash. As with Equal, the order of map entries
+// and set elements does not matter. Hashes are the same in every run of
+// a program, but may change between versions of this package.
+func Hash(v interface{}) uint64 {
+	return hashValue(reflect.ValueOf(v))
+}
+
+const (
+	fnvOffset = 14695981039346656037
+	fnvPrime  = 1099511628211
+)
+
+// A hasher computes a 64-bit FNV-1a hash.
+type hasher uint64
+
+func (h *hasher) bytes(b []byte) {
+	for _, c := range b {
+		*h = (*h ^ hasher(c)) * fnvPrime
+	}
+}
+
+func (h *hasher) string(s string) {
+	for i := 0; i < len(s); i++ {
+		*h = (*h ^ hasher(s[i])) * fnvPrime
+	}
+	h.uint64(uint64(len(s)))
+}
+
+func (h *hasher) uint64(x uint64) {
+	for i := 0; i < 8; i++ {
+		*h = (*h ^ hasher(byte(x))) * fnvPrime
+		x >>= 8
+	}
+}
+
+func hashValue(v reflect.Value) uint64 {
+	h := hasher(fnvOffset)
+	v = ednValue(v)
+	if !v.IsValid() {
+		h.uint64(^uint64(0))
+		return uint64(h)
+	}
+	class := classOf(v)
+	h.uint64(uint64(class))
+	switch class {
+	case classBool:
+		if v.Bool() {
+			h.uint64(1)
+		}
+	case classInt:
+		switch x := bigIntOf(v); {
+		case x.IsInt64():
+			h.uint64(uint64(x.Int64()))
+		default:
+			h.uint64(uint64(x.Sign()))
+			h.bytes(x.Bytes())
+		}
+	case classFloat:
+		f := v.Float()
+		if f == 0 {
+			f = 0 // -0 is equal to 0
+		}
+		h.uint64(math.Float64bits(f))
+	case classDecimal:
+		// The 'p' format is exact and has no trailing zeros, so it
+		// spells equal values the same at any precision.
+		h.string(bigFloatOf(v).Text('p', 0))
+	case classString, classSymbol:
+		h.string(v.String())
+	case classKeyword:
+		h.string(strings.TrimPrefix(v.String(), ":"))
+	case classChar:
+		h.uint64(uint64(v.Int()))
+	case classBytes:
+		h.bytes(v.Bytes())
+	case classInst:
+		t := instTime(v)
+		h.uint64(uint64(t.Unix()))
+		h.uint64(uint64(t.Nanosecond()))
+	case classUUID:
+		h.string(v.Interface().(fmt.Stringer).String())
+	case classTagged:
+		t := v.Interface().(Tagged)
+		h.string(strings.TrimPrefix(t.Tag, "#"))
+		h.uint64(Hash(t.Value))
+	case classSeq:
+		h.uint64(uint64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			h.uint64(hashValue(v.Index(i)))
+		}
+	case classSet:
+		// Summing makes the hash independent of iteration order.
+		var sum uint64
+		for _, k := range v.MapKeys() {
+			sum += hashValue(k)
+		}
+		h.uint64(uint64(v.Len()))
+		h.uint64(sum)
+	case classMap:
+		ents := mapEntries(v)
+		var sum uint64
+		for _, ent := range ents {
+			sum += hashValue(ent.k)*fnvPrime + hashValue(ent.v)
+		}
+		h.uint64(uint64(len(ents)))
+		h.uint64(sum)
+	default:
+		// Other values are equal only if deeply equal, so have the
+		// same type and equal fields. Only exported scalar fields are
+		// hashed, which keeps clear of pointer cycles.
+		h.string(v.Type().String())
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			h.uint64(uint64(v.Int()))
+		case reflect.Struct:
+			for i := 0; i < v.NumField(); i++ {
+				if !v.Type().Field(i).IsExported() {
+					continue
+				}
+				switch f := v.Field(i); f.Kind() {
+				case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+					reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+					reflect.Float32, reflect.Float64, reflect.String:
+					h.uint64(hashValue(f))
+				}
+			}
+		}
+	}
+	return uint64(h)
+}
@@ -0,0 +1,105 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+	"math"
+	"math/big"
+	"time"
+)
+
+type EqualTests struct{}
+
+func init() { check.Suite(&EqualTests{}) }
+
+func (*EqualTests) TestEqual(c *check.C) {
+	one := 1
+	var nilPtr *int
+	t := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var om OrderedMap
+	om.Set(K("b"), []int{2})
+	om.Set(K("a"), 1)
+	var hm HashMap
+	hm.Set([]int{1}, "x")
+	var hm2 HashMap
+	hm2.Set(Vec{int64(1)}, "x")
+	for _, p := range [][2]interface{}{
+		{nil, nil},
+		{nil, nilPtr},
+		{1, int64(1)},
+		{uint8(200), int16(200)},
+		{&one, 1},
+		{big.NewInt(7), uint(7)},
+		{uint64(math.MaxUint64), new(big.Int).SetUint64(math.MaxUint64)},
+		{float32(0.5), 0.5},
+		{big.NewFloat(1.5), *big.NewFloat(1.5)},
+		{"a", "a"},
+		{K("a"), K(":a")},
+		{S("x/y"), S("x/y")},
+		{Char('c'), Char('c')},
+		{[]byte("hi"), []byte("hi")},
+		{[]int{1, 2}, Vec{int8(1), uint(2)}},
+		{[2]string{"a", "b"}, List{"a", "b"}},
+		{[]int(nil), Vec{}},
+		{map[string]int(nil), map[Keyword]int{}},
+		{Set{}.Add(1, K("a")), Set{}.Add(K(":a"), int64(1))},
+		{NewSetOf("x", "y"), Set{}.Add("y", "x")},
+		{KMap{"a": 1, "b": Vec{2}}, map[interface{}]interface{}{K("a"): 1, K("b"): []int{2}}},
+		{SMap{"?e": 1}, map[Symbol]int{"?e": 1}},
+		{map[interface{}]int{int8(1): 2}, map[interface{}]int{1: 2}},
+		{&om, KMap{"a": 1, "b": []int{2}}},
+		{hm, &hm2},
+		{HashMap{}, map[interface{}]string{}},
+		{t, Instant{Time: t.In(time.FixedZone("", 3600)), Digits: 3}},
+		{Tagged{"#x", 1}, Tagged{"x", int64(1)}},
+		{WithMeta(Vec{1}, map[interface{}]interface{}{K("m"): 1}), []int{1}},
+		{Point{1, 2}, Point{1, 2}},
+	} {
+		c.Check(Equal(p[0], p[1]), check.Equals, true, check.Commentf("%#v == %#v", p[0], p[1]))
+		c.Check(Hash(p[0]), check.Equals, Hash(p[1]), check.Commentf("Hash(%#v) == Hash(%#v)", p[0], p[1]))
+		c.Check(Equal(p[1], p[0]), check.Equals, true, check.Commentf("%#v == %#v", p[1], p[0]))
+	}
+	for _, p := range [][2]interface{}{
+		{nil, false},
+		{1, 1.0},
+		{1.5, big.NewFloat(1.5)},
+		{-1, uint64(math.MaxUint64)},
+		{math.NaN(), math.NaN()},
+		{"a", K("a")},
+		{K("a"), S("a")},
+		{"c", Char('c')},
+		{Char('c'), 'c'},
+		{[]int{1, 2}, []int{2, 1}},
+		{[]int{1}, Set{}.Add(1)},
+		{Set{}.Add(1, 2), Set{}.Add(1, 3)},
+		{KMap{"a": 1}, map[string]int{"a": 1}},
+		{map[int]int{1: 2}, map[int]int{1: 3}},
+		{map[interface{}]int{int8(1): 2}, map[interface{}]int{1: 2, 2: 2}},
+		{Tagged{"x", 1}, Tagged{"y", 1}},
+		{time.Second, 1000000000},
+		{Point{1, 2}, KMap{"x": 1, "y": 2}},
+	} {
+		c.Check(Equal(p[0], p[1]), check.Equals, false, check.Commentf("%#v != %#v", p[0], p[1]))
+		c.Check(Equal(p[1], p[0]), check.Equals, false, check.Commentf("%#v != %#v", p[1], p[0]))
+	}
+}
+
+func (*EqualTests) TestHash(c *check.C) {
+	// Unequal values should rarely collide.
+	seen := map[uint64]interface{}{}
+	for _, v := range []interface{}{
+		nil, false, true, 0, 1, -1, 1.0, 0.5, "", "a", K("a"), S("a"), Char('a'),
+		[]int{}, []int{1}, []int{1, 2}, []int{2, 1}, Set{}, Set{}.Add(1), KMap{}, KMap{"a": 1},
+		map[int]int{1: 2}, map[int]int{2: 1}, Tagged{"x", 1}, Point{1, 2}, Point{2, 1},
+		new(big.Int).Lsh(big.NewInt(1), 100), big.NewFloat(0.5),
+	} {
+		h := Hash(v)
+		prev, dup := seen[h]
+		c.Check(dup, check.Equals, false, check.Commentf("%#v and %#v", v, prev))
+		seen[h] = v
+	}
+	c.Check(Hash(math.Copysign(0, -1)), check.Equals, Hash(0.0))
+	c.Check(Hash(new(big.Float).SetPrec(200).SetFloat64(0.1)), check.Equals, Hash(big.NewFloat(0.1)))
+}
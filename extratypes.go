@@ -4,16 +4,52 @@
 package edn
 
 import (
+	"encoding/hex"
+	"fmt"
 	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
 )
 
-type Set map[interface{}]bool
+// Set is an EDN set of arbitrary elements, written as #{...}. Its
+// elements are its keys; the empty struct values take no memory. Use
+// the methods, or SetOf for elements of a single type.
+type Set map[interface{}]struct{}
 
 var setType = reflect.TypeOf(Set(nil))
 
+// NewSet returns a set holding items.
+func NewSet(items ...interface{}) Set {
+	return make(Set, len(items)).Add(items...)
+}
+
+// SetFromSlice returns a set holding the elements of s, which must be
+// of a type usable as a map key.
+func SetFromSlice[T any](s []T) Set {
+	set := make(Set, len(s))
+	for _, x := range s {
+		set[x] = struct{}{}
+	}
+	return set
+}
+
+// SetFromBools converts a set held the way Set used to be, as a map
+// from elements to true, into a Set. Keys mapped to false are left out.
+func SetFromBools(m map[interface{}]bool) Set {
+	set := make(Set, len(m))
+	for k, in := range m {
+		if in {
+			set[k] = struct{}{}
+		}
+	}
+	return set
+}
+
 func (set Set) Add(keys ...interface{}) Set {
 	for _, k := range keys {
-		set[k] = true
+		set[k] = struct{}{}
 	}
 	return set
 }
@@ -27,14 +63,162 @@ func (set Set) Has(k interface{}) (ret bool) {
 	return
 }
 
+// Len returns the number of elements of set.
+func (set Set) Len() int {
+	return len(set)
+}
+
+// Slice returns the elements of set. If sorted is true, they are in the
+// order MarshalCanonical writes them, and otherwise in no fixed order.
+func (set Set) Slice(sorted bool) []interface{} {
+	xs := make([]interface{}, 0, len(set))
+	for x := range set {
+		xs = append(xs, x)
+	}
+	if sorted {
+		texts := make(map[interface{}]string, len(xs))
+		for _, x := range xs {
+			b, _ := MarshalCanonical(x)
+			texts[x] = string(b)
+		}
+		sort.Slice(xs, func(i, j int) bool { return texts[xs[i]] < texts[xs[j]] })
+	}
+	return xs
+}
+
+// Each calls f for each element of set, in no fixed order.
+func (set Set) Each(f func(x interface{})) {
+	for x := range set {
+		f(x)
+	}
+}
+
+// Clone returns a copy of set.
+func (set Set) Clone() Set {
+	c := make(Set, len(set))
+	for x := range set {
+		c[x] = struct{}{}
+	}
+	return c
+}
+
+// SetOf is an EDN set of elements of type T. Like Set, it is written as
+// #{...}, but its element type is checked at compile time. The name Set
+// is taken by the untyped set, which predates generics.
+type SetOf[T comparable] map[T]struct{}
+
+// typedSet is implemented by every SetOf type.
+type typedSet interface {
+	isSetOf()
+}
+
+var typedSetType = reflect.TypeOf((*typedSet)(nil)).Elem()
+
+func (SetOf[T]) isSetOf() {}
+
+// NewSetOf returns a set holding elems.
+func NewSetOf[T comparable](elems ...T) SetOf[T] {
+	s := make(SetOf[T], len(elems))
+	for _, x := range elems {
+		s[x] = struct{}{}
+	}
+	return s
+}
+
+// Add adds x to s.
+func (s SetOf[T]) Add(x T) {
+	s[x] = struct{}{}
+}
+
+// Has reports whether x is in s.
+func (s SetOf[T]) Has(x T) bool {
+	_, ok := s[x]
+	return ok
+}
+
+// Delete removes x from s.
+func (s SetOf[T]) Delete(x T) {
+	delete(s, x)
+}
+
+// Len returns the number of elements of s.
+func (s SetOf[T]) Len() int {
+	return len(s)
+}
+
+// isSetType reports whether t is written as an EDN set.
+func isSetType(t reflect.Type) bool {
+	return t == setType || t.Kind() == reflect.Map && t.Implements(typedSetType)
+}
+
 type Keyword string
 
 var keywordType = reflect.TypeOf(Keyword(""))
 
+// K converts k to a Keyword without checking it. NewKeyword assembles
+// and checks namespaced keywords.
 func K(k string) Keyword {
 	return Keyword(k)
 }
 
+// NewKeyword returns the keyword :ns/name, or :name if ns is empty. A
+// leading colon on ns, or on name when ns is empty, is dropped, so
+// NewKeyword(":db", "id") is :db/id. It fails if the result is not a
+// keyword by the EDN grammar.
+func NewKeyword(ns, name string) (Keyword, error) {
+	ns = strings.TrimPrefix(ns, ":")
+	s := ns + "/" + name
+	if ns == "" {
+		s = strings.TrimPrefix(name, ":")
+	}
+	if name != "/" && strings.Contains(name, "/") || !validKeyword(s) {
+		return "", fmt.Errorf("edn: invalid keyword %q", ":"+s)
+	}
+	return Keyword(s), nil
+}
+
+// ParseKeyword returns the keyword written as s, with or without its
+// leading colon, as in ":myapp/widget" or "widget". It fails if s is
+// not a keyword by the EDN grammar.
+func ParseKeyword(s string) (Keyword, error) {
+	k := Keyword(strings.TrimPrefix(s, ":"))
+	if !k.IsValid() {
+		return "", fmt.Errorf("edn: invalid keyword %q", s)
+	}
+	return k, nil
+}
+
+// Namespace returns the namespace of k, or "" if it has none. For
+// :myapp/widget, it is "myapp".
+func (k Keyword) Namespace() string {
+	ns, _ := splitKeyword(strings.TrimPrefix(string(k), ":"))
+	return ns
+}
+
+// Name returns the name of k without its namespace. For :myapp/widget,
+// it is "widget".
+func (k Keyword) Name() string {
+	_, name := splitKeyword(strings.TrimPrefix(string(k), ":"))
+	return name
+}
+
+// IsValid reports whether k, with or without its leading colon, is a
+// keyword by the EDN grammar, and so can be read back as written.
+func (k Keyword) IsValid() bool {
+	return validKeyword(strings.TrimPrefix(string(k), ":"))
+}
+
+// splitKeyword splits a keyword or symbol, without any leading colon,
+// into its namespace and name. The namespace is empty if there is none.
+// A lone / and names ending in // such as clojure.core// are handled as
+// the division symbol.
+func splitKeyword(s string) (ns, name string) {
+	if i := strings.IndexByte(s, '/'); i > 0 && i < len(s)-1 {
+		return s[:i], s[i+1:]
+	}
+	return "", s
+}
+
 type Symbol string
 
 var symbolType = reflect.TypeOf(Symbol(""))
@@ -43,8 +227,341 @@ func S(s string) Symbol {
 	return Symbol(s)
 }
 
+// NewSymbol returns the symbol with namespace ns, which may be empty,
+// and the given name. It fails if the result is not a symbol by the EDN
+// grammar. The name may be the division symbol /, so that
+// NewSymbol("clojure.core", "/") is clojure.core//.
+func NewSymbol(ns, name string) (Symbol, error) {
+	s := name
+	if ns != "" {
+		s = ns + "/" + name
+	}
+	if name != "/" && strings.Contains(name, "/") || !validSymbol(s) {
+		return "", fmt.Errorf("edn: invalid symbol %q", s)
+	}
+	return Symbol(s), nil
+}
+
+// Namespace returns the namespace of s, or "" if it has none. For
+// clojure.core/map, it is "clojure.core".
+func (s Symbol) Namespace() string {
+	ns, _ := splitKeyword(string(s))
+	return ns
+}
+
+// Name returns the name of s without its namespace. For
+// clojure.core/map, it is "map", and for clojure.core//, it is "/".
+func (s Symbol) Name() string {
+	_, name := splitKeyword(string(s))
+	return name
+}
+
+// IsValid reports whether s is a symbol by the EDN grammar, and so can
+// be read back as written.
+func (s Symbol) IsValid() bool {
+	return validSymbol(string(s))
+}
+
+// symbolReadsAsNumber reports whether s, written out as a symbol, would
+// be read back as a number. EDN forbids a symbol's prefix or name from
+// starting with a digit, or with -, + or . followed by a digit. The
+// division symbol / and names like foo// are legal and not affected.
+func symbolReadsAsNumber(s string) bool {
+	if i := strings.IndexByte(s, '/'); i > 0 && i < len(s)-1 {
+		return numberLike(s[:i]) || numberLike(s[i+1:])
+	}
+	return numberLike(s)
+}
+
+func numberLike(s string) bool {
+	if s == "" {
+		return false
+	}
+	if s[0] == '-' || s[0] == '+' || s[0] == '.' {
+		s = s[1:]
+	}
+	return s != "" && '0' <= s[0] && s[0] <= '9'
+}
+
+// validSymbol reports whether s is a symbol by the EDN grammar: an
+// optional prefix and a name, separated by /, each made of letters,
+// digits and the characters .*+!-_?$%&=<>:#, and not read as a number.
+// The names nil, true and false are reserved, and / may only be used
+// alone or as the name of a prefixed symbol, as in clojure.core//.
+func validSymbol(s string) bool {
+	switch s {
+	case "/":
+		return true
+	case "nil", "true", "false":
+		return false
+	}
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		return validSymbolPart(s[:i]) && (s[i+1:] == "/" || validSymbolPart(s[i+1:]))
+	}
+	return validSymbolPart(s)
+}
+
+func validSymbolPart(s string) bool {
+	if s == "" || numberLike(s) {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case unicode.IsLetter(r), strings.ContainsRune(".*+!-_?$%&=<>", r):
+		case unicode.IsDigit(r), r == ':', r == '#':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validKeyword reports whether :name is a keyword by the EDN grammar.
+func validKeyword(name string) bool {
+	return name != "/" && validSymbol(name) || name == "nil" || name == "true" || name == "false"
+}
+
+// Vec is an EDN vector. Any Go slice or array is written as a vector,
+// but Vec names the intent in heterogeneous trees, where a plain
+// []interface{} says nothing about which EDN collection was meant.
+// For example: Marshal(Vec{1, K("a"), "b"}) => [1 :a "b"]
+type Vec []interface{}
+
+// List is an EDN list. Marshal writes it in parentheses, (1 2 3),
+// where other slices are written as vectors. It is a lighter-weight
+// alternative to container/list, which is also written as a list.
+type List []interface{}
+
+var listSliceType = reflect.TypeOf(List(nil))
+
+// Char is an EDN character. Marshal writes it as a character literal:
+// \a, \newline, \space, or \u00df for runes outside printable ASCII.
+type Char rune
+
+var charType = reflect.TypeOf(Char(0))
+
+// Tagged is an EDN tagged element with an arbitrary tag. Marshal writes
+// it as the tag followed by the value, so
+// Tagged{"myapp/Point", []int{1, 2}} becomes #myapp/Point [1 2].
+type Tagged struct {
+	Tag   string
+	Value interface{}
+}
+
+var taggedType = reflect.TypeOf(Tagged{})
+
+// Meta is a value with Clojure metadata attached. Marshal writes it as
+// the metadata map prefixed with ^, followed by the value, so
+// WithMeta([]int{1}, map[interface{}]interface{}{K("source"): "db"})
+// becomes ^{:source "db"} [1]. Metadata is not part of EDN proper, but
+// the Clojure reader attaches it to the value that follows, which must
+// then be a collection or a symbol. An empty Meta map is not written.
+type Meta struct {
+	Value interface{}
+	Meta  map[interface{}]interface{}
+}
+
+var metaType = reflect.TypeOf(Meta{})
+
+// WithMeta returns v with the metadata meta attached.
+func WithMeta(v interface{}, meta map[interface{}]interface{}) Meta {
+	return Meta{v, meta}
+}
+
+// Instant is an EDN #inst that keeps the form of its timestamp. Where
+// Marshal writes a time.Time in UTC with as many fractional digits as
+// it needs, an Instant is written in its own UTC offset with exactly
+// Digits fractional digits, so that a timestamp read with ParseInstant
+// is written back byte for byte:
+//
+//	i, _ := edn.ParseInstant("1985-04-12T23:20:50.520-07:00")
+//	Marshal(i) => #inst "1985-04-12T23:20:50.520-07:00"
+type Instant struct {
+	Time time.Time
+
+	// Digits is the number of fractional-second digits written, from
+	// 0 to 9.
+	Digits int
+
+	// NumericUTC writes a zero UTC offset as +00:00 rather than Z.
+	NumericUTC bool
+
+	// UnknownOffset writes a zero UTC offset as -00:00, which RFC 3339
+	// uses for a time in UTC whose local offset is unknown.
+	UnknownOffset bool
+}
+
+var instantType = reflect.TypeOf(Instant{})
+
+// ParseInstant parses an RFC 3339 timestamp, as found in an EDN #inst,
+// and records its precision and UTC offset.
+func ParseInstant(s string) (Instant, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return Instant{}, err
+	}
+	i := Instant{Time: t}
+	if len(s) > 19 && s[19] == '.' {
+		for _, c := range s[20:] {
+			if c < '0' || c > '9' {
+				break
+			}
+			i.Digits++
+		}
+		i.Digits = min(i.Digits, 9)
+	}
+	i.UnknownOffset = strings.HasSuffix(s, "-00:00")
+	i.NumericUTC = i.UnknownOffset || strings.HasSuffix(s, "+00:00")
+	return i, nil
+}
+
+// String returns the timestamp of i in RFC 3339 format.
+func (i Instant) String() string {
+	layout := "2006-01-02T15:04:05"
+	if d := min(max(i.Digits, 0), 9); d > 0 {
+		layout += "." + strings.Repeat("0", d)
+	}
+	switch _, off := i.Time.Zone(); {
+	case i.UnknownOffset && off == 0:
+		return i.Time.Format(layout) + "-00:00"
+	case i.NumericUTC:
+		layout += "-07:00"
+	default:
+		layout += "Z07:00"
+	}
+	return i.Time.Format(layout)
+}
+
+// UUID is a universally unique identifier, written by Marshal as
+// #uuid "f81d4fae-7dec-11d0-a765-00a0c91e6bf6". UUID types of other
+// packages with the same shape are written the same way; see Marshal.
+type UUID [16]byte
+
+// ParseUUID parses a UUID in the canonical form
+// xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx, in either letter case.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("edn: invalid UUID %q", s)
+	}
+	src := []byte(s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:])
+	if _, err := hex.Decode(u[:], src); err != nil {
+		return UUID{}, fmt.Errorf("edn: invalid UUID %q", s)
+	}
+	return u, nil
+}
+
+// String returns u in the canonical lowercase form.
+func (u UUID) String() string {
+	b, _ := u.MarshalText()
+	return string(b)
+}
+
+// MarshalText implements encoding.TextMarshaler using the canonical
+// form.
+func (u UUID) MarshalText() ([]byte, error) {
+	b := make([]byte, 36)
+	hex.Encode(b[0:8], u[0:4])
+	b[8] = '-'
+	hex.Encode(b[9:13], u[4:6])
+	b[13] = '-'
+	hex.Encode(b[14:18], u[6:8])
+	b[18] = '-'
+	hex.Encode(b[19:23], u[8:10])
+	b[23] = '-'
+	hex.Encode(b[24:], u[10:])
+	return b, nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler using ParseUUID.
+func (u *UUID) UnmarshalText(b []byte) error {
+	v, err := ParseUUID(string(b))
+	if err != nil {
+		return err
+	}
+	*u = v
+	return nil
+}
+
+// OrderedMap is an EDN map that remembers the order its keys were first
+// set in, and is written in that order, so that config files and API
+// payloads keep their layout. When map keys are sorted, as by
+// MarshalCanonical, its entries are sorted like those of any other map,
+// so that equal OrderedMaps are written alike. The zero OrderedMap is
+// empty and ready to use.
+type OrderedMap struct {
+	keys []interface{}
+	vals map[interface{}]interface{}
+}
+
+var orderedMapType = reflect.TypeOf(OrderedMap{})
+
+// Set sets the value of key k to v. A new key is placed last; an
+// existing key keeps its place.
+func (m *OrderedMap) Set(k, v interface{}) {
+	if m.vals == nil {
+		m.vals = make(map[interface{}]interface{})
+	}
+	if _, ok := m.vals[k]; !ok {
+		m.keys = append(m.keys, k)
+	}
+	m.vals[k] = v
+}
+
+// Get returns the value of key k, and whether there is one.
+func (m *OrderedMap) Get(k interface{}) (interface{}, bool) {
+	v, ok := m.vals[k]
+	return v, ok
+}
+
+// Delete removes key k, if present.
+func (m *OrderedMap) Delete(k interface{}) {
+	if _, ok := m.vals[k]; !ok {
+		return
+	}
+	delete(m.vals, k)
+	for i, x := range m.keys {
+		if x == k {
+			m.keys = append(m.keys[:i], m.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Len returns the number of entries in m.
+func (m *OrderedMap) Len() int {
+	return len(m.keys)
+}
+
+// Keys returns the keys of m in order.
+func (m *OrderedMap) Keys() []interface{} {
+	return append([]interface{}(nil), m.keys...)
+}
+
+// clone returns a copy of m.
+func (m *OrderedMap) clone() OrderedMap {
+	c := OrderedMap{keys: append([]interface{}(nil), m.keys...)}
+	if m.vals != nil {
+		c.vals = make(map[interface{}]interface{}, len(m.vals))
+		for k, v := range m.vals {
+			c.vals[k] = v
+		}
+	}
+	return c
+}
+
 // KMap is useful for generating EDN maps with Keywords as keys.
 // For example: Marshal(KMap{"foo": 45, "bar": 3.14}) => {:foo 45, :bar 3.14}
 type KMap map[string]interface{}
 
 var keywordMapType = reflect.TypeOf(KMap{})
+
+// SMap is useful for generating EDN maps with Symbols as keys, as
+// needed in Datomic queries and transactions.
+// For example: Marshal(SMap{"?e": K("db/id")}) => {?e :db/id}
+type SMap map[string]interface{}
+
+var symbolMapType = reflect.TypeOf(SMap{})
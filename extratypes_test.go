@@ -4,32 +4,288 @@
 package edn
 
 import (
-	. "gopkg.in/check.v1"
+	"gopkg.in/check.v1"
+	"time"
 )
 
 type ExtraTypesTests struct{}
 
-func init() { Suite(&ExtraTypesTests{}) }
+func init() { check.Suite(&ExtraTypesTests{}) }
 
-func (*ExtraTypesTests) TestSet(c *C) {
+func (*ExtraTypesTests) TestSet(c *check.C) {
 	set := Set{}
-	c.Check(set.Has(nil), Equals, false)
-	c.Check(set.Has(42), Equals, false)
-	c.Check(set.Add("hello", 3.14, true, false, nil), DeepEquals, set)
+	c.Check(set.Has(nil), check.Equals, false)
+	c.Check(set.Has(42), check.Equals, false)
+	c.Check(set.Add("hello", 3.14, true, false, nil), check.DeepEquals, set)
 	for _, k := range []interface{}{"hello", 3.14, true, false, nil} {
-		c.Check(set.Has(k), Equals, true)
+		c.Check(set.Has(k), check.Equals, true)
 	}
 }
 
-func (*ExtraTypesTests) TestK(c *C) {
-	c.Check(string(K("abc")), Equals, "abc")
-	c.Check(string(K(":foo/abc")), Equals, ":foo/abc")
+func (*ExtraTypesTests) TestSetHelpers(c *check.C) {
+	set := NewSet(K("b"), 2, "a", K("b"))
+	c.Check(set.Len(), check.Equals, 3)
+	c.Check(set.Slice(true), check.DeepEquals, []interface{}{"a", 2, K("b")})
+	c.Check(len(set.Slice(false)), check.Equals, 3)
+	c.Check(SetFromSlice([]string{"x", "y", "x"}), check.DeepEquals, NewSet("x", "y"))
+	c.Check(NewSet().Len(), check.Equals, 0)
+
+	clone := set.Clone()
+	clone.Discard(2)
+	c.Check(set.Has(2), check.Equals, true)
+	c.Check(clone.Len(), check.Equals, 2)
+
+	n := 0
+	set.Each(func(x interface{}) {
+		c.Check(set.Has(x), check.Equals, true)
+		n++
+	})
+	c.Check(n, check.Equals, 3)
+}
+
+func (*ExtraTypesTests) TestSetFromBools(c *check.C) {
+	set := SetFromBools(map[interface{}]bool{"a": true, "b": false, K("c"): true})
+	c.Check(set, check.DeepEquals, Set{}.Add("a", K("c")))
+	checkMarshal(c, pair{SetFromBools(map[interface{}]bool{1: true, 2: false}), "#{1}"})
+}
+
+func (*ExtraTypesTests) TestK(c *check.C) {
+	c.Check(string(K("abc")), check.Equals, "abc")
+	c.Check(string(K(":foo/abc")), check.Equals, ":foo/abc")
+}
+
+func (*ExtraTypesTests) TestValidSymbol(c *check.C) {
+	for _, s := range []string{"a", "foo/bar", "/", "clojure.core//", "-", "+a", ".x", "a1", "a:b#", "ñ", "<=>", "?x!"} {
+		c.Check(validSymbol(s), check.Equals, true, check.Commentf("%q", s))
+	}
+	for _, s := range []string{"", "1a", "-1", ".5", "a b", "a\"b", "a/", "/a", "a/b/c", ":a", "#a", "nil", "true", "a(b", "a,b"} {
+		c.Check(validSymbol(s), check.Equals, false, check.Commentf("%q", s))
+	}
+	for _, s := range []string{"a", "ns/a", "nil", "true"} {
+		c.Check(validKeyword(s), check.Equals, true, check.Commentf("%q", s))
+	}
+	for _, s := range []string{"/", ":a", "a b", "", "1"} {
+		c.Check(validKeyword(s), check.Equals, false, check.Commentf("%q", s))
+	}
+}
+
+func (*ExtraTypesTests) TestSetOf(c *check.C) {
+	s := NewSetOf(K("a"))
+	s.Add(K("b"))
+	s.Add(K("a"))
+	c.Check(s.Len(), check.Equals, 2)
+	c.Check(s.Has(K("b")), check.Equals, true)
+	s.Delete(K("b"))
+	c.Check(s.Has(K("b")), check.Equals, false)
+
+	b, err := MarshalCanonical(Vec{NewSetOf(3, 1, 2), s, SetOf[string](nil)})
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, "[#{1 2 3} #{:a} #{}]")
+	checkMarshal(c, pair{&s, "#{:a}"})
+	_, err = MarshalCanonical(NewSetOf[interface{}](1, uint8(1)))
+	c.Check(err, check.ErrorMatches, "edn: duplicate set key 1 in .*")
+}
+
+func (*ExtraTypesTests) TestKeywordParts(c *check.C) {
+	for _, t := range []struct {
+		k        Keyword
+		ns, name string
+		valid    bool
+	}{
+		{":myapp/widget", "myapp", "widget", true},
+		{"widget", "", "widget", true},
+		{":a.b.c/d-e?", "a.b.c", "d-e?", true},
+		{":clojure.core//", "clojure.core", "/", true},
+		{"/", "", "/", false},
+		{":a b", "", "a b", false},
+		{"", "", "", false},
+	} {
+		c.Check(t.k.Namespace(), check.Equals, t.ns, check.Commentf("%q", t.k))
+		c.Check(t.k.Name(), check.Equals, t.name, check.Commentf("%q", t.k))
+		c.Check(t.k.IsValid(), check.Equals, t.valid, check.Commentf("%q", t.k))
+	}
+
+	k, err := ParseKeyword(":db/id")
+	c.Check(err, check.IsNil)
+	c.Check(k, check.Equals, Keyword("db/id"))
+	_, err = ParseKeyword("1st")
+	c.Check(err, check.ErrorMatches, `edn: invalid keyword "1st"`)
+}
+
+func (*ExtraTypesTests) TestNewKeyword(c *check.C) {
+	for _, t := range []struct {
+		ns, name string
+		want     Keyword
+	}{
+		{"db", "id", "db/id"},
+		{":db", "id", "db/id"},
+		{"", "id", "id"},
+		{"", ":id", "id"},
+		{"my.app", "valid?", "my.app/valid?"},
+		{"clojure.core", "/", "clojure.core//"},
+	} {
+		k, err := NewKeyword(t.ns, t.name)
+		c.Check(err, check.IsNil)
+		c.Check(k, check.Equals, t.want)
+	}
+	for _, t := range [][2]string{{"", ""}, {"db", ""}, {"", "/"}, {"db", "a/b"}, {"a/b", "c"}, {"db", ":id"}, {"1db", "id"}} {
+		_, err := NewKeyword(t[0], t[1])
+		c.Check(err, check.NotNil, check.Commentf("%q", t))
+	}
+	_, err := NewKeyword("db", "first name")
+	c.Check(err, check.ErrorMatches, `edn: invalid keyword ":db/first name"`)
+}
+
+func (*ExtraTypesTests) TestSymbolParts(c *check.C) {
+	for _, t := range []struct {
+		s        Symbol
+		ns, name string
+		valid    bool
+	}{
+		{"clojure.core/map", "clojure.core", "map", true},
+		{"?e", "", "?e", true},
+		{"clojure.core//", "clojure.core", "/", true},
+		{"/", "", "/", true},
+		{"nil", "", "nil", false},
+		{"a/b/c", "a", "b/c", false},
+	} {
+		c.Check(t.s.Namespace(), check.Equals, t.ns, check.Commentf("%q", t.s))
+		c.Check(t.s.Name(), check.Equals, t.name, check.Commentf("%q", t.s))
+		c.Check(t.s.IsValid(), check.Equals, t.valid, check.Commentf("%q", t.s))
+	}
+
+	for _, t := range []struct {
+		ns, name string
+		want     Symbol
+	}{
+		{"", "?e", "?e"},
+		{"db", "add", "db/add"},
+		{"", "/", "/"},
+		{"clojure.core", "/", "clojure.core//"},
+	} {
+		s, err := NewSymbol(t.ns, t.name)
+		c.Check(err, check.IsNil)
+		c.Check(s, check.Equals, t.want)
+	}
+	for _, t := range [][2]string{{"", "a/b"}, {"a/b", "c"}, {"db", ""}, {"", "1x"}, {"my ns", "x"}} {
+		_, err := NewSymbol(t[0], t[1])
+		c.Check(err, check.NotNil, check.Commentf("%q", t))
+	}
+	_, err := NewSymbol("db", "a b")
+	c.Check(err, check.ErrorMatches, `edn: invalid symbol "db/a b"`)
+}
+
+func (*ExtraTypesTests) TestInstant(c *check.C) {
+	for _, s := range []string{
+		"1985-04-12T23:20:50.520-07:00",
+		"1985-04-12T23:20:50Z",
+		"2024-02-29T00:00:00.000000000+05:30",
+		"2024-02-29T00:00:00.1+00:00",
+		"2024-02-29T00:00:00-00:00",
+	} {
+		i, err := ParseInstant(s)
+		c.Assert(err, check.IsNil)
+		checkMarshal(c, pair{i, `#inst "` + s + `"`}, pair{&i, `#inst "` + s + `"`})
+	}
+	i, err := ParseInstant("1985-04-12T23:20:50.52-07:00")
+	c.Assert(err, check.IsNil)
+	c.Check(i.Digits, check.Equals, 2)
+	c.Check(i.Time.Equal(time.Date(1985, 4, 13, 6, 20, 50, 520e6, time.UTC)), check.Equals, true)
+	c.Check(i.UnknownOffset, check.Equals, false)
+	i, err = ParseInstant("1985-04-12T23:20:50-00:00")
+	c.Assert(err, check.IsNil)
+	c.Check(i.UnknownOffset, check.Equals, true)
+	i.Time = i.Time.In(time.FixedZone("", 3600))
+	c.Check(i.String(), check.Equals, "1985-04-13T00:20:50+01:00")
+
+	t := time.Date(2001, 2, 3, 4, 5, 6, 7, time.UTC)
+	checkMarshal(c, pair{Instant{Time: t, Digits: 3}, `#inst "2001-02-03T04:05:06.000Z"`})
+
+	_, err = ParseInstant("1985-04-12")
+	c.Check(err, check.NotNil)
+}
+
+func (*ExtraTypesTests) TestUUID(c *check.C) {
+	u, err := ParseUUID("F81D4FAE-7DEC-11d0-a765-00a0c91e6bf6")
+	c.Assert(err, check.IsNil)
+	c.Check(u, check.Equals, UUID{0xf8, 0x1d, 0x4f, 0xae, 0x7d, 0xec, 0x11, 0xd0, 0xa7, 0x65, 0x00, 0xa0, 0xc9, 0x1e, 0x6b, 0xf6})
+	c.Check(u.String(), check.Equals, "f81d4fae-7dec-11d0-a765-00a0c91e6bf6")
+	checkMarshal(c, pair{u, `#uuid "f81d4fae-7dec-11d0-a765-00a0c91e6bf6"`}, pair{UUID{}, `#uuid "00000000-0000-0000-0000-000000000000"`})
+
+	var v UUID
+	c.Assert(v.UnmarshalText([]byte(u.String())), check.IsNil)
+	c.Check(v, check.Equals, u)
+	for _, s := range []string{"", "f81d4fae7dec11d0a76500a0c91e6bf6", "f81d4fae-7dec-11d0-a765-00a0c91e6bfg", "f81d4fae-7dec-11d0-a765_00a0c91e6bf6"} {
+		_, err := ParseUUID(s)
+		c.Check(err, check.ErrorMatches, "edn: invalid UUID .*", check.Commentf("%q", s))
+	}
+}
+
+func (*ExtraTypesTests) TestOrderedMap(c *check.C) {
+	var m OrderedMap
+	m.Set(K("name"), "app")
+	m.Set(K("port"), 8080)
+	m.Set(K("debug"), false)
+	m.Set(K("port"), 8081)
+	c.Check(m.Len(), check.Equals, 3)
+	c.Check(m.Keys(), check.DeepEquals, []interface{}{K("name"), K("port"), K("debug")})
+	v, ok := m.Get(K("port"))
+	c.Check(ok, check.Equals, true)
+	c.Check(v, check.Equals, 8081)
+
+	want := `{:name "app", :port 8081, :debug false}`
+	checkMarshal(c, pair{&m, want}, pair{Vec{m}, "[" + want + "]"})
+	b, err := MarshalCanonical(m)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, `{:debug false :name "app" :port 8081}`)
+
+	var other OrderedMap
+	other.Set(K("debug"), false)
+	other.Set(K("port"), 8081)
+	other.Set(K("name"), "app")
+	c.Check(Equal(m, other), check.Equals, true)
+	b2, err := MarshalCanonical(other)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b2), check.Equals, string(b))
+	b, err = MarshalCanonical(OrderedMap{})
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, "{}")
+
+	m.Delete(K("port"))
+	m.Delete(K("missing"))
+	m.Set(K("port"), 1)
+	checkMarshal(c, pair{m, `{:name "app", :debug false, :port 1}`}, pair{OrderedMap{}, "{}"})
 }
 
-func (*ExtraTypesTests) TestKMap(c *C) {
+func (*ExtraTypesTests) TestMeta(c *check.C) {
+	checkMarshal(
+		c,
+		pair{WithMeta([]int{1}, map[interface{}]interface{}{K("source"): "db"}), `^{:source "db"} [1]`},
+		pair{WithMeta(S("x"), nil), "x"},
+		pair{&Meta{Vec{WithMeta(List{}, map[interface{}]interface{}{K("a"): true})}, map[interface{}]interface{}{K("b"): 1}}, "^{:b 1} [^{:a true} ()]"},
+	)
+}
+
+func (*ExtraTypesTests) TestKMap(c *check.C) {
 	if b, err := Marshal(KMap{"foo": 123, "bar": true}); err == nil {
-		c.Assert(string(b), Equals, "{:foo 123, :bar true}")
+		c.Assert(string(b), check.Equals, "{:foo 123, :bar true}")
 	} else {
 		c.Fatal(err)
 	}
 }
+
+func (*ExtraTypesTests) TestSMap(c *check.C) {
+	if b, err := Marshal(SMap{"?e": K("db/id")}); err == nil {
+		c.Assert(string(b), check.Equals, "{?e :db/id}")
+	} else {
+		c.Fatal(err)
+	}
+	_, err := Marshal(SMap{"-1": true})
+	c.Check(err, check.FitsTypeOf, &UnsupportedValueError{})
+}
+
+func (*ExtraTypesTests) TestVec(c *check.C) {
+	b, err := Marshal(Vec{1, K("a"), Vec{}, Vec(nil), List{Vec{"b"}}})
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, `[1 :a [] [] (["b"])]`)
+}
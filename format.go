@@ -0,0 +1,315 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Formatter reformats EDN text by the rules of cljfmt: the line
+// breaks of the text are kept, but the whitespace around them is
+// normalized. Elements on the same line are separated by one space,
+// whitespace just inside delimiters and at the ends of lines is
+// removed, and each line is indented to suit the collection it is in.
+// Comments, discarded forms, commas and the spelling of atoms are
+// kept.
+type Formatter struct {
+	// MaxBlankLines is the number of consecutive blank lines kept;
+	// longer runs are shortened to it.
+	MaxBlankLines int
+
+	// AlignMapValues lines up the values of the map entries that
+	// begin lines, when their keys are atoms and their values start
+	// on the same line as the keys.
+	AlignMapValues bool
+
+	// ListIndent, if positive, indents the elements of lists that
+	// begin lines by that many columns from the opening parenthesis.
+	// If zero, as in cljfmt's default style, they are aligned with
+	// the second element of the list when it is on the first line,
+	// and indented by one column otherwise.
+	ListIndent int
+}
+
+// DefaultFormatter is the Formatter used by Format. It keeps single
+// blank lines, and neither aligns map values nor uses a fixed list
+// indentation.
+var DefaultFormatter = &Formatter{MaxBlankLines: 1}
+
+// Format calls DefaultFormatter.Format.
+func Format(src []byte) ([]byte, error) {
+	return DefaultFormatter.Format(src)
+}
+
+// Format returns src reformatted. It fails with a *SyntaxError if src
+// is not well-formed EDN.
+func (f *Formatter) Format(src []byte) ([]byte, error) {
+	if err := Compact(new(bytes.Buffer), src); err != nil {
+		return nil, err
+	}
+	p := &fmtParser{src: src}
+	var forms []*fmtNode
+	for {
+		g, tok, ok := p.next()
+		if !ok {
+			w := &fmtWriter{f: f}
+			w.forms(forms, g)
+			if w.buf.Len() > 0 && bytes.HasSuffix(bytes.TrimRight(src, " \t\r,"), []byte("\n")) {
+				w.buf.WriteByte('\n')
+			}
+			return w.buf.Bytes(), nil
+		}
+		forms = append(forms, p.node(g, tok))
+	}
+}
+
+// A fmtGap is the text between two tokens: whitespace, commas and
+// comments.
+type fmtGap struct {
+	items [][]byte // comments, with nil for each line break
+	comma bool
+}
+
+func (g fmtGap) hasBreak() bool {
+	for _, it := range g.items {
+		if it == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (g fmtGap) hasComment() bool {
+	for _, it := range g.items {
+		if it != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// A fmtNode is a form of the text being formatted, with the gap
+// before it.
+type fmtNode struct {
+	gap  fmtGap
+	tok  token
+	kids []*fmtNode // elements of a collection, or forms after a tag or ^
+	tail fmtGap     // gap before the closing delimiter of a collection
+}
+
+type fmtParser struct {
+	src []byte
+	off int // end of the last token read
+}
+
+// next returns the next token and the gap before it, or the gap to
+// the end of the text and false if there are no more tokens.
+func (p *fmtParser) next() (fmtGap, token, bool) {
+	tok, next, err := nextToken(p.src, p.off)
+	end := tok.off
+	if err != nil {
+		end = len(p.src)
+	}
+	var g fmtGap
+	for i := p.off; i < end; i++ {
+		switch p.src[i] {
+		case '\n':
+			g.items = append(g.items, nil)
+		case ',':
+			g.comma = true
+		case ';':
+			j := i
+			for j < end && p.src[j] != '\n' {
+				j++
+			}
+			g.items = append(g.items, bytes.TrimRight(p.src[i:j], " \t\r\f"))
+			i = j - 1
+		}
+	}
+	if err != nil {
+		p.off = len(p.src)
+		return g, token{}, false
+	}
+	p.off = next
+	return g, tok, true
+}
+
+// node parses the rest of the form that begins with tok.
+func (p *fmtParser) node(g fmtGap, tok token) *fmtNode {
+	n := &fmtNode{gap: g, tok: tok}
+	switch tok.kind {
+	case tokTag:
+		kg, kt, _ := p.next()
+		n.kids = []*fmtNode{p.node(kg, kt)}
+	case tokMeta:
+		for i := 0; i < 2; i++ {
+			kg, kt, _ := p.next()
+			n.kids = append(n.kids, p.node(kg, kt))
+		}
+	case tokOpen:
+		for {
+			kg, kt, _ := p.next()
+			if kt.kind == tokClose {
+				n.tail = kg
+				return n
+			}
+			n.kids = append(n.kids, p.node(kg, kt))
+		}
+	}
+	return n
+}
+
+type fmtWriter struct {
+	f    *Formatter
+	buf  bytes.Buffer
+	col  int // current column
+	line int // current line
+}
+
+func (w *fmtWriter) write(b []byte) {
+	w.buf.Write(b)
+	w.col += utf8.RuneCount(b)
+}
+
+func (w *fmtWriter) newline(blank, indent int) {
+	blank = min(blank, w.f.MaxBlankLines)
+	for i := 0; i <= blank; i++ {
+		w.buf.WriteByte('\n')
+	}
+	w.buf.WriteString(strings.Repeat(" ", indent))
+	w.col = indent
+	w.line++
+}
+
+// gap writes the separator that g stands for before an element at the
+// given indentation. At the start of a collection or the text, leading
+// line breaks are dropped, and at its end, trailing ones are; pad is
+// the number of spaces separating an element from one on the same
+// line.
+func (w *fmtWriter) gap(g fmtGap, indent int, atStart, atEnd bool, pad int) {
+	if g.comma && !atStart {
+		w.buf.WriteByte(',')
+		w.col++
+	}
+	breaks, afterComment := 0, false
+	for _, it := range g.items {
+		if it == nil {
+			breaks++
+			continue
+		}
+		switch {
+		case breaks == 0 && !afterComment && w.buf.Len() > 0 && !atStart:
+			w.write([]byte(" "))
+		case breaks > 0 || afterComment:
+			if w.buf.Len() > 0 {
+				w.newline(max(breaks-1, 0), indent)
+			}
+		}
+		w.write(it)
+		breaks, afterComment = 0, true
+	}
+	switch {
+	case afterComment && atEnd:
+		w.newline(0, indent)
+	case afterComment:
+		w.newline(max(breaks-1, 0), indent)
+	case atStart || atEnd:
+	case breaks > 0:
+		w.newline(breaks-1, indent)
+	case pad > 0:
+		w.write([]byte(strings.Repeat(" ", pad)))
+	}
+}
+
+// forms writes the top-level forms, and the gap after them.
+func (w *fmtWriter) forms(forms []*fmtNode, tail fmtGap) {
+	for i, n := range forms {
+		w.gap(n.gap, 0, i == 0, false, 1)
+		w.node(n)
+	}
+	tail.comma = false
+	if tail.hasComment() {
+		w.gap(tail, 0, len(forms) == 0, false, 0)
+		w.buf.Truncate(len(bytes.TrimRight(w.buf.Bytes(), "\n")))
+	}
+}
+
+func (w *fmtWriter) node(n *fmtNode) {
+	switch n.tok.kind {
+	case tokAtom:
+		w.write(n.tok.text)
+	case tokTag:
+		w.write(n.tok.text)
+		kid := n.kids[0]
+		w.gap(kid.gap, w.col+1, false, false, 1)
+		w.node(kid)
+	case tokMeta:
+		indent := w.col
+		w.write(n.tok.text)
+		w.gap(n.kids[0].gap, indent+1, true, false, 0)
+		w.node(n.kids[0])
+		w.gap(n.kids[1].gap, indent, false, false, 1)
+		w.node(n.kids[1])
+	case tokOpen:
+		w.coll(n)
+	}
+}
+
+func (w *fmtWriter) coll(n *fmtNode) {
+	openCol, openLine := w.col, w.line
+	w.write(n.tok.text)
+	open := string(n.tok.text)
+	indent := w.col
+	isList := open == "("
+	if isList {
+		indent = openCol + 1
+		if w.f.ListIndent > 0 {
+			indent = openCol + w.f.ListIndent
+		}
+	}
+	alignCol := -1
+	if w.f.AlignMapValues && isMapOpen(n.tok.text) {
+		alignCol = mapAlignColumn(n, w.col)
+	}
+	var aligned bool // whether the key just written began a line
+	for i, kid := range n.kids {
+		pad := 1
+		if alignCol >= 0 && i%2 == 1 && aligned {
+			pad = max(alignCol-w.col, 1)
+		}
+		w.gap(kid.gap, indent, i == 0, false, pad)
+		aligned = i%2 == 0 && (i == 0 || kid.gap.hasBreak())
+		if isList && i == 1 && w.line == openLine && w.f.ListIndent == 0 {
+			indent = w.col
+		}
+		w.node(kid)
+	}
+	w.gap(n.tail, indent, len(n.kids) == 0, true, 0)
+	w.buf.WriteByte(closerFor(n.tok.text))
+	w.col++
+}
+
+// mapAlignColumn returns the column at which the values of the map n,
+// whose keys begin lines at column col, are aligned, or -1 if they are
+// not.
+func mapAlignColumn(n *fmtNode, col int) int {
+	width, lines := 0, 0
+	for i := 0; i+1 < len(n.kids); i += 2 {
+		k, v := n.kids[i], n.kids[i+1]
+		if i > 0 && !k.gap.hasBreak() {
+			continue
+		}
+		if k.tok.kind != tokAtom || v.gap.hasBreak() || v.gap.hasComment() {
+			return -1
+		}
+		width = max(width, utf8.RuneCount(k.tok.text))
+		lines++
+	}
+	if lines < 2 {
+		return -1
+	}
+	return col + width + 1
+}
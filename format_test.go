@@ -0,0 +1,63 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type FormatTests struct{}
+
+func init() { check.Suite(&FormatTests{}) }
+
+func (*FormatTests) TestFormat(c *check.C) {
+	for _, t := range []struct {
+		src, want string
+	}{
+		{``, ``},
+		{"  [ 1   2 ,3 ]  \n", "[1 2, 3]\n"},
+		{"[1][2]", "[1] [2]"},
+		{"{:a 1,\n     :b 2}", "{:a 1,\n :b 2}"},
+		{"[\n  1\n2\n   ]", "[1\n 2]"},
+		{"{:db {:host \"h\"   ; primary   \n:port 1}}", "{:db {:host \"h\" ; primary\n      :port 1}}"},
+		{"(foo bar\nbaz)", "(foo bar\n     baz)"},
+		{"(foo\nbar\n  baz)", "(foo\n bar\n baz)"},
+		{"#{:a\n:b}", "#{:a\n  :b}"},
+		{"#:p{:x 1\n:y 2}", "#:p{:x 1\n    :y 2}"},
+		{"[1\n\n\n\n2]", "[1\n\n 2]"},
+		{";; header\n\n\n{:a 1}  ; trailing\n", ";; header\n\n{:a 1} ; trailing\n"},
+		{"[1 ; one\n]", "[1 ; one\n ]"},
+		{"[1\n #_ 2\n 3]", "[1\n #_ 2\n 3]"},
+		{"#inst   \"2014-03-14T15:59:59Z\"", "#inst \"2014-03-14T15:59:59Z\""},
+		{"^:private\n   [x]", "^:private\n[x]"},
+		{"[{:a [1\n2]}\n{:b 3}]", "[{:a [1\n      2]}\n {:b 3}]"},
+		{"{:name \"é\" :x [1\n2]}", "{:name \"é\" :x [1\n               2]}"},
+	} {
+		got, err := Format([]byte(t.src))
+		c.Check(err, check.IsNil, check.Commentf("%q", t.src))
+		c.Check(string(got), check.Equals, t.want, check.Commentf("%q", t.src))
+	}
+}
+
+func (*FormatTests) TestFormatterOptions(c *check.C) {
+	f := &Formatter{AlignMapValues: true, ListIndent: 2}
+	got, err := f.Format([]byte("{:a 1\n:bbb [2]\n  :cc {:d 4\n:eeee 5}}\n\n\n(defn f [x]\n(inc x))\n"))
+	c.Assert(err, check.IsNil)
+	c.Check(string(got), check.Equals, `{:a   1
+ :bbb [2]
+ :cc  {:d    4
+       :eeee 5}}
+(defn f [x]
+  (inc x))
+`)
+
+	got, err = f.Format([]byte("{:a 1 :bb 2}\n{:a 1\n:bb\n2}"))
+	c.Assert(err, check.IsNil)
+	c.Check(string(got), check.Equals, "{:a 1 :bb 2}\n{:a 1\n :bb\n 2}")
+}
+
+func (*FormatTests) TestFormatError(c *check.C) {
+	_, err := Format([]byte(`{:a [1}`))
+	c.Check(err, check.ErrorMatches, `edn: \[ closed by }`)
+}
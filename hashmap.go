@@ -0,0 +1,146 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"reflect"
+	"sort"
+)
+
+// HashMap is an EDN map whose keys may be any values, including the
+// vectors, maps and sets EDN allows as keys but Go maps cannot hold.
+// Keys are compared with Equal and located with Hash, so []int{1, 2}
+// and Vec{1, 2} name one entry. Marshal writes the entries ordered by
+// the canonical encoding of their keys, as MarshalCanonical produces.
+//
+// The zero HashMap is empty and ready to use.
+type HashMap struct {
+	buckets map[uint64][]*hashEntry // by Hash of the key
+	n       int
+}
+
+type hashEntry struct {
+	key, value interface{}
+	text       string // canonical encoding of key
+}
+
+var hashMapType = reflect.TypeOf(HashMap{})
+
+// find returns the entry for key k, if any, and its hash.
+func (m *HashMap) find(k interface{}) (*hashEntry, uint64) {
+	h := Hash(k)
+	for _, ent := range m.buckets[h] {
+		if Equal(ent.key, k) {
+			return ent, h
+		}
+	}
+	return nil, h
+}
+
+// Set sets the value of key k to v. It fails if k cannot be encoded.
+func (m *HashMap) Set(k, v interface{}) error {
+	if ent, _ := m.find(k); ent != nil {
+		ent.value = v
+		return nil
+	}
+	b, err := MarshalCanonical(k)
+	if err != nil {
+		return err
+	}
+	if m.buckets == nil {
+		m.buckets = make(map[uint64][]*hashEntry)
+	}
+	h := Hash(k)
+	m.buckets[h] = append(m.buckets[h], &hashEntry{k, v, string(b)})
+	m.n++
+	return nil
+}
+
+// Get returns the value of key k, and whether there is one.
+func (m *HashMap) Get(k interface{}) (interface{}, bool) {
+	if ent, _ := m.find(k); ent != nil {
+		return ent.value, true
+	}
+	return nil, false
+}
+
+// Delete removes key k, if present.
+func (m *HashMap) Delete(k interface{}) {
+	ent, h := m.find(k)
+	if ent == nil {
+		return
+	}
+	b := m.buckets[h]
+	for i := range b {
+		if b[i] == ent {
+			b = append(b[:i], b[i+1:]...)
+			break
+		}
+	}
+	if len(b) == 0 {
+		delete(m.buckets, h)
+	} else {
+		m.buckets[h] = b
+	}
+	m.n--
+}
+
+// Len returns the number of entries in m.
+func (m *HashMap) Len() int {
+	return m.n
+}
+
+// Range calls f for each entry of m, in the order Marshal writes them,
+// until f returns false.
+func (m *HashMap) Range(f func(k, v interface{}) bool) {
+	for _, ent := range m.sorted() {
+		if !f(ent.key, ent.value) {
+			return
+		}
+	}
+}
+
+func (m *HashMap) sorted() []*hashEntry {
+	ents := make([]*hashEntry, 0, m.n)
+	for _, b := range m.buckets {
+		ents = append(ents, b...)
+	}
+	sort.Slice(ents, func(i, j int) bool { return ents[i].text < ents[j].text })
+	return ents
+}
+
+// clone returns a copy of m.
+func (m *HashMap) clone() HashMap {
+	var c HashMap
+	if m.buckets != nil {
+		c.buckets = make(map[uint64][]*hashEntry, len(m.buckets))
+		for h, b := range m.buckets {
+			nb := make([]*hashEntry, len(b))
+			for i, ent := range b {
+				e := *ent
+				nb[i] = &e
+			}
+			c.buckets[h] = nb
+		}
+	}
+	c.n = m.n
+	return c
+}
+
+func hashMapEncoder(e *encodeState, v reflect.Value) {
+	m := v.Interface().(HashMap)
+	e.enter()
+	e.WriteByte('{')
+	for i, ent := range m.sorted() {
+		if i > 0 {
+			e.WriteString(e.entrySep())
+			e.flush()
+		}
+		e.value(ent.key)
+		e.WriteByte(' ')
+		e.value(ent.value)
+	}
+	e.WriteByte('}')
+	e.leave()
+}
@@ -0,0 +1,50 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type HashMapTests struct{}
+
+func init() { check.Suite(&HashMapTests{}) }
+
+func (*HashMapTests) TestHashMap(c *check.C) {
+	var m HashMap
+	c.Assert(m.Set([]int{1, 2}, "pair"), check.IsNil)
+	c.Assert(m.Set(KMap{"a": 1}, K("map")), check.IsNil)
+	c.Assert(m.Set(Set{}.Add(K("x")), nil), check.IsNil)
+	c.Assert(m.Set(Vec{1, 2}, "vec"), check.IsNil)
+	c.Check(m.Len(), check.Equals, 3)
+
+	v, ok := m.Get([2]int{1, 2})
+	c.Check(ok, check.Equals, true)
+	c.Check(v, check.Equals, "vec")
+	_, ok = m.Get(map[string]interface{}{":a": 1})
+	c.Check(ok, check.Equals, false)
+	v, ok = m.Get(KMap{"a": 1})
+	c.Check(ok, check.Equals, true)
+	c.Check(v, check.Equals, K("map"))
+
+	checkMarshal(
+		c,
+		pair{&m, `{#{:x} nil, [1 2] "vec", {:a 1} :map}`},
+		pair{Vec{m}, `[{#{:x} nil, [1 2] "vec", {:a 1} :map}]`},
+	)
+
+	var keys []interface{}
+	m.Range(func(k, v interface{}) bool {
+		keys = append(keys, k)
+		return len(keys) < 2
+	})
+	// Setting an equal key keeps the original key.
+	c.Check(keys, check.DeepEquals, []interface{}{Set{}.Add(K("x")), []int{1, 2}})
+
+	m.Delete([]interface{}{1, 2})
+	m.Delete(make(chan int))
+	c.Check(m.Len(), check.Equals, 2)
+	c.Check(m.Set(make(chan int), 1), check.FitsTypeOf, &UnsupportedTypeError{})
+	checkMarshal(c, pair{HashMap{}, "{}"})
+}
@@ -0,0 +1,187 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MIMEType is the media type of EDN.
+const MIMEType = "application/edn"
+
+const jsonMIMEType = "application/json"
+
+// WriteResponse writes v as the body of an HTTP response with the given
+// status. The body is EDN, as Marshal writes it, unless w was passed to
+// a handler by Negotiate for a request preferring JSON, in which case v
+// is converted as ToJSON converts it. Nothing is written if v cannot be
+// encoded, so that the caller can still reply with an error.
+func WriteResponse(w http.ResponseWriter, status int, v interface{}) error {
+	b, err := Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctype := MIMEType
+	if nw, ok := w.(*negotiatedWriter); ok && nw.json {
+		var buf bytes.Buffer
+		if err := ToJSON(&buf, b, nw.opts...); err != nil {
+			return err
+		}
+		b, ctype = buf.Bytes(), jsonMIMEType
+	} else {
+		b = append(b, '\n')
+	}
+	w.Header().Set("Content-Type", ctype)
+	w.WriteHeader(status)
+	_, err = w.Write(b)
+	return err
+}
+
+// Negotiate returns a handler that calls h with a ResponseWriter on
+// which WriteResponse writes EDN or JSON, whichever the Accept header
+// of the request prefers. EDN is chosen when both are equally
+// acceptable or there is no Accept header, and requests accepting
+// neither are answered 406 Not Acceptable. opts configure the
+// conversion to JSON.
+func Negotiate(h http.Handler, opts ...JSONOption) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		ctype := negotiate(r.Header.Values("Accept"))
+		if ctype == "" {
+			http.Error(w, "edn: response can only be "+MIMEType+" or "+jsonMIMEType, http.StatusNotAcceptable)
+			return
+		}
+		h.ServeHTTP(&negotiatedWriter{w, ctype == jsonMIMEType, opts}, r)
+	})
+}
+
+// A negotiatedWriter is a ResponseWriter that Negotiate has chosen the
+// body format of.
+type negotiatedWriter struct {
+	http.ResponseWriter
+	json bool
+	opts []JSONOption
+}
+
+// Unwrap returns the ResponseWriter w wraps, for http.ResponseController.
+func (w *negotiatedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// negotiate returns the one of MIMEType and the JSON media type that
+// the Accept header values prefer, or "" if they accept neither.
+func negotiate(accept []string) string {
+	var ranges []mediaRange
+	for _, a := range accept {
+		for _, part := range strings.Split(a, ",") {
+			if r, ok := parseMediaRange(part); ok {
+				ranges = append(ranges, r)
+			}
+		}
+	}
+	if len(ranges) == 0 {
+		return MIMEType
+	}
+	qEDN, qJSON := acceptQ(ranges, MIMEType), acceptQ(ranges, jsonMIMEType)
+	switch {
+	case qEDN > 0 && qEDN >= qJSON:
+		return MIMEType
+	case qJSON > 0:
+		return jsonMIMEType
+	}
+	return ""
+}
+
+type mediaRange struct {
+	typ string
+	q   float64
+}
+
+func parseMediaRange(s string) (mediaRange, bool) {
+	typ, params, err := mime.ParseMediaType(s)
+	if err != nil {
+		return mediaRange{}, false
+	}
+	r := mediaRange{typ, 1}
+	if q, ok := params["q"]; ok {
+		if r.q, err = strconv.ParseFloat(q, 64); err != nil {
+			return mediaRange{}, false
+		}
+	}
+	return r, true
+}
+
+// acceptQ returns the quality the ranges give the media type typ: that
+// of the most specific range matching it, or 0 if none does.
+func acceptQ(ranges []mediaRange, typ string) float64 {
+	q, best := 0.0, -1
+	major, _, _ := strings.Cut(typ, "/")
+	for _, r := range ranges {
+		spec := -1
+		switch r.typ {
+		case typ:
+			spec = 2
+		case major + "/*":
+			spec = 1
+		case "*/*":
+			spec = 0
+		}
+		if spec > best {
+			q, best = r.q, spec
+		}
+	}
+	return q
+}
+
+// NewRequest returns an HTTP request asking for an EDN response, as
+// http.NewRequestWithContext does. If body is not nil, it is encoded
+// with Marshal as the EDN body of the request.
+func NewRequest(ctx context.Context, method, url string, body interface{}) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", MIMEType)
+	if body != nil {
+		req.Header.Set("Content-Type", MIMEType)
+	}
+	return req, nil
+}
+
+// A Transport is an http.RoundTripper that asks for EDN responses to
+// the requests it sends that do not say what they accept, so that an
+// http.Client using it talks to EDN services without setting headers
+// on each request.
+type Transport struct {
+	// Base sends the requests. If nil, http.DefaultTransport is used.
+	Base http.RoundTripper
+}
+
+// RoundTrip sends req with Base, adding an Accept header for EDN if it
+// has none.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept", MIMEType)
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
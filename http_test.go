@@ -0,0 +1,113 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"context"
+	"gopkg.in/check.v1"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+)
+
+type HTTPTests struct{}
+
+func init() { check.Suite(&HTTPTests{}) }
+
+func (*HTTPTests) TestWriteResponse(c *check.C) {
+	rec := httptest.NewRecorder()
+	c.Assert(WriteResponse(rec, http.StatusCreated, KMap{"id": 7}), check.IsNil)
+	c.Check(rec.Code, check.Equals, http.StatusCreated)
+	c.Check(rec.Header().Get("Content-Type"), check.Equals, "application/edn")
+	c.Check(rec.Body.String(), check.Equals, "{:id 7}\n")
+
+	rec = httptest.NewRecorder()
+	c.Check(WriteResponse(rec, http.StatusOK, math.Inf(1)), check.NotNil)
+	c.Check(rec.Body.Len(), check.Equals, 0)
+	c.Check(rec.Header().Get("Content-Type"), check.Equals, "")
+}
+
+func (*HTTPTests) TestNegotiate(c *check.C) {
+	h := Negotiate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteResponse(w, http.StatusOK, KMap{"tags": NewSet(K("a"))})
+	}), JSONKeywordColons(true))
+	for _, t := range []struct {
+		accept []string
+		code   int
+		ctype  string
+		body   string
+	}{
+		{nil, 200, "application/edn", "{:tags #{:a}}\n"},
+		{[]string{"application/json"}, 200, "application/json", `{":tags":[":a"]}` + "\n"},
+		{[]string{"application/json, application/edn"}, 200, "application/edn", "{:tags #{:a}}\n"},
+		{[]string{"application/edn;q=0.5", "application/json;q=0.9"}, 200, "application/json", `{":tags":[":a"]}` + "\n"},
+		{[]string{"application/json, */*;q=0.1"}, 200, "application/json", `{":tags":[":a"]}` + "\n"},
+		{[]string{"application/*"}, 200, "application/edn", "{:tags #{:a}}\n"},
+		{[]string{"text/html, application/edn;q=0"}, 406, "text/plain; charset=utf-8", "edn: response can only be application/edn or application/json\n"},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		for _, a := range t.accept {
+			req.Header.Add("Accept", a)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		c.Check(rec.Code, check.Equals, t.code, check.Commentf("%q", t.accept))
+		c.Check(rec.Header().Get("Content-Type"), check.Equals, t.ctype, check.Commentf("%q", t.accept))
+		c.Check(rec.Header().Get("Vary"), check.Equals, "Accept")
+		c.Check(rec.Body.String(), check.Equals, t.body, check.Commentf("%q", t.accept))
+	}
+}
+
+func (*HTTPTests) TestNegotiateUnwrap(c *check.C) {
+	h := Negotiate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(http.NewResponseController(w).Flush(), check.IsNil)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+}
+
+func (*HTTPTests) TestNewRequest(c *check.C) {
+	req, err := NewRequest(context.Background(), "POST", "http://example.com/orders", KMap{"sku": "a1"})
+	c.Assert(err, check.IsNil)
+	c.Check(req.Header.Get("Accept"), check.Equals, "application/edn")
+	c.Check(req.Header.Get("Content-Type"), check.Equals, "application/edn")
+	c.Check(req.ContentLength, check.Equals, int64(len(`{:sku "a1"}`)))
+	b, err := io.ReadAll(req.Body)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, `{:sku "a1"}`)
+	c.Check(req.GetBody, check.NotNil)
+
+	req, err = NewRequest(context.Background(), "GET", "http://example.com/orders", nil)
+	c.Assert(err, check.IsNil)
+	c.Check(req.Body, check.IsNil)
+	c.Check(req.Header.Get("Accept"), check.Equals, "application/edn")
+	c.Check(req.Header.Get("Content-Type"), check.Equals, "")
+
+	_, err = NewRequest(context.Background(), "POST", "http://example.com", math.NaN())
+	c.Check(err, check.NotNil)
+}
+
+type recordingTransport struct{ req *http.Request }
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.req = req
+	return &http.Response{StatusCode: 200, Body: http.NoBody, Request: req}, nil
+}
+
+func (*HTTPTests) TestTransport(c *check.C) {
+	base := &recordingTransport{}
+	client := &http.Client{Transport: &Transport{Base: base}}
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RequestURI = ""
+	_, err := client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Check(base.req.Header.Get("Accept"), check.Equals, "application/edn")
+	c.Check(req.Header.Get("Accept"), check.Equals, "")
+
+	req.Header.Set("Accept", "text/plain")
+	_, err = client.Do(req)
+	c.Assert(err, check.IsNil)
+	c.Check(base.req.Header.Get("Accept"), check.Equals, "text/plain")
+}
@@ -0,0 +1,233 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Compact appends to dst the EDN-encoded src with comments, discarded
+// forms (#_ and the form after it) and insignificant whitespace and
+// commas removed. A space is kept only where two elements would
+// otherwise run together, and successive top-level forms are written
+// one per line. Atoms are copied as they are spelled in src.
+//
+// If src is not well-formed, Compact returns a *SyntaxError and leaves
+// dst unchanged.
+func Compact(dst *bytes.Buffer, src []byte) error {
+	origLen := dst.Len()
+	c := &compactor{dst: dst, src: src}
+	for off := 0; ; {
+		next, err := c.form(off)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			dst.Truncate(origLen)
+			return err
+		}
+		off = next
+		c.line = true
+	}
+}
+
+type compactor struct {
+	dst  *bytes.Buffer
+	src  []byte
+	prev token // last token written
+	line bool  // whether the next token written starts a top-level form
+	skip int   // depth of discarded forms being read
+}
+
+// form copies the form at or after src[off], skipping discarded forms
+// before it, and returns the offset just past it. It returns io.EOF if
+// there are no more forms.
+func (c *compactor) form(off int) (int, error) {
+	tok, off, err := c.token(off)
+	if err != nil {
+		return off, err
+	}
+	switch tok.kind {
+	case tokClose:
+		return off, &SyntaxError{fmt.Sprintf("unexpected %s", tok.text), int64(tok.off)}
+	case tokTag, tokMeta:
+		c.emit(tok)
+		nkids := 1
+		if tok.kind == tokMeta {
+			nkids = 2
+		}
+		for i := 0; i < nkids; i++ {
+			if off, err = c.form(off); err == io.EOF {
+				return off, &SyntaxError{fmt.Sprintf("%s not followed by a value", tok.text), int64(off)}
+			} else if err != nil {
+				return off, err
+			}
+		}
+	case tokOpen:
+		c.emit(tok)
+		closer := closerFor(tok.text)
+		for {
+			t, next, err := c.token(off)
+			if err == io.EOF {
+				return next, &SyntaxError{fmt.Sprintf("unclosed %s", tok.text), int64(next)}
+			}
+			if err != nil {
+				return next, err
+			}
+			if t.kind == tokClose {
+				if t.text[0] != closer {
+					return next, &SyntaxError{fmt.Sprintf("%s closed by %s", tok.text, t.text), int64(t.off)}
+				}
+				c.emit(t)
+				return next, nil
+			}
+			if off, err = c.form(off); err != nil {
+				return off, err
+			}
+		}
+	default:
+		c.emit(tok)
+	}
+	return off, nil
+}
+
+// token returns the next token at or after src[off] that is not part
+// of a discarded form.
+func (c *compactor) token(off int) (token, int, error) {
+	for {
+		tok, next, err := nextToken(c.src, off)
+		if err != nil || tok.kind != tokTag || string(tok.text) != "#_" {
+			return tok, next, err
+		}
+		c.skip++
+		next, err = c.form(next)
+		c.skip--
+		if err == io.EOF {
+			return tok, next, &SyntaxError{"#_ not followed by a value", int64(next)}
+		}
+		if err != nil {
+			return tok, next, err
+		}
+		off = next
+	}
+}
+
+// emit writes tok, unless it is being discarded, preceded by whatever
+// separator it needs.
+func (c *compactor) emit(tok token) {
+	if c.skip > 0 {
+		return
+	}
+	switch {
+	case c.prev.text == nil:
+	case c.line:
+		c.dst.WriteByte('\n')
+	case needsSpace(c.prev, tok):
+		c.dst.WriteByte(' ')
+	}
+	c.line = false
+	c.dst.Write(tok.text)
+	c.prev = tok
+}
+
+// needsSpace reports whether tok, written right after prev, would run
+// into it and be read as part of it.
+func needsSpace(prev, tok token) bool {
+	switch {
+	case prev.kind == tokOpen, prev.kind == tokClose, prev.kind == tokMeta, tok.kind == tokClose:
+		return false
+	case prev.kind == tokAtom && prev.text[0] == '"':
+		return false
+	}
+	switch tok.text[0] {
+	case '(', '[', '{', '"':
+		return false
+	}
+	return true
+}
+
+// Indent appends to dst an indented form of the EDN-encoded src, as
+// json.Indent does for JSON. Each element of a non-empty collection
+// begins on a new line, starting with prefix followed by one copy of
+// indent per level of nesting; the key and value of a map entry share
+// a line, and entries are separated by commas. The first line is not
+// prefixed, so that the result can be embedded in other formatted EDN.
+//
+// Atoms and tags are copied as they are spelled in src, while
+// comments and discarded forms are dropped, as by Compact. Successive
+// top-level forms are written one per line. If src is not well-formed,
+// Indent returns a *SyntaxError and leaves dst unchanged.
+func Indent(dst *bytes.Buffer, src []byte, prefix, indent string) error {
+	var compact bytes.Buffer
+	if err := Compact(&compact, src); err != nil {
+		return err
+	}
+	b := compact.Bytes()
+	in := &indenter{dst: dst, prefix: prefix, indent: indent}
+	for off, first := 0, true; ; first = false {
+		n, next, err := parseForm(b, off)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err // not reached: Compact checked src
+		}
+		if !first {
+			in.newline(0)
+		}
+		in.node(n, 0)
+		off = next
+	}
+}
+
+type indenter struct {
+	dst            *bytes.Buffer
+	prefix, indent string
+}
+
+func (in *indenter) newline(depth int) {
+	in.dst.WriteByte('\n')
+	in.dst.WriteString(in.prefix)
+	for i := 0; i < depth; i++ {
+		in.dst.WriteString(in.indent)
+	}
+}
+
+// node writes n, whose first line is at the given depth of nesting.
+func (in *indenter) node(n *ppNode, depth int) {
+	switch n.kind {
+	case ppAtom:
+		in.dst.Write(n.text)
+	case ppTagged:
+		in.dst.Write(n.text)
+		in.dst.WriteByte(' ')
+		in.node(n.kids[0], depth)
+	case ppMeta:
+		in.dst.WriteByte('^')
+		in.node(n.kids[0], depth)
+		in.dst.WriteByte(' ')
+		in.node(n.kids[1], depth)
+	case ppColl:
+		in.dst.Write(n.text)
+		isMap := n.isMap()
+		for i, kid := range n.kids {
+			switch {
+			case isMap && i%2 == 1:
+				in.dst.WriteByte(' ')
+			case isMap && i > 0:
+				in.dst.WriteByte(',')
+				fallthrough
+			default:
+				in.newline(depth + 1)
+			}
+			in.node(kid, depth+1)
+		}
+		if len(n.kids) > 0 {
+			in.newline(depth)
+		}
+		in.dst.WriteByte(closerFor(n.text))
+	}
+}
@@ -0,0 +1,76 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"gopkg.in/check.v1"
+)
+
+type IndentTests struct{}
+
+func init() { check.Suite(&IndentTests{}) }
+
+func (*IndentTests) TestCompact(c *check.C) {
+	for _, t := range []struct {
+		src, want string
+	}{
+		{``, ``},
+		{` [1, 2 ,3 ] `, `[1 2 3]`},
+		{"{:a 1, ; one\n :b [\"x\" \"y\"]}", `{:a 1 :b["x""y"]}`},
+		{`[1 #_ 2 3 #_ [4 5]]`, `[1 3]`},
+		{`[#_ #_ 1 2 3]`, `[3]`},
+		{`(a #_ b)`, `(a)`},
+		{`#inst "2014-03-14T15:59:59Z" #my/tag [1 2]`, "#inst\"2014-03-14T15:59:59Z\"\n#my/tag[1 2]"},
+		{`^{:a 1} x ^:b [y]`, "^{:a 1}x\n^:b[y]"},
+		{`[a #{1} #:p{:x 1} \( (b) \a "s"]`, `[a #{1}#:p{:x 1}\((b)\a"s"]`},
+		{`1.50M 0x1F 1e3 ##Inf`, "1.50M\n0x1F\n1e3\n##Inf"},
+		{"{:a 1}\n\n{:b 2} #_ {:c 3}\n", "{:a 1}\n{:b 2}"},
+	} {
+		var buf bytes.Buffer
+		err := Compact(&buf, []byte(t.src))
+		c.Check(err, check.IsNil, check.Commentf("%s", t.src))
+		c.Check(buf.String(), check.Equals, t.want, check.Commentf("%s", t.src))
+	}
+}
+
+func (*IndentTests) TestCompactErrors(c *check.C) {
+	for _, t := range []struct {
+		src, err string
+	}{
+		{`[1 2`, `edn: unclosed \[`},
+		{`[1 2)`, `edn: \[ closed by \)`},
+		{`)`, `edn: unexpected \)`},
+		{`[#_]`, `edn: unexpected \]`},
+		{`1 #_`, `edn: #_ not followed by a value`},
+		{`#tag`, `edn: #tag not followed by a value`},
+	} {
+		buf := bytes.NewBufferString("keep")
+		err := Compact(buf, []byte(t.src))
+		c.Check(err, check.ErrorMatches, t.err, check.Commentf("%s", t.src))
+		c.Check(buf.String(), check.Equals, "keep")
+	}
+}
+
+func (*IndentTests) TestIndent(c *check.C) {
+	for _, t := range []struct {
+		src, prefix, indent, want string
+	}{
+		{`[]`, "", "  ", `[]`},
+		{`[1 2.50M 0x1F]`, "", "  ", "[\n  1\n  2.50M\n  0x1F\n]"},
+		{"{:a 1 ; one\n :b [#_ 0 x {}]}", "", "  ", "{\n  :a 1,\n  :b [\n    x\n    {}\n  ]\n}"},
+		{`#{(f)}`, ">", "\t", "#{\n>\t(\n>\t\tf\n>\t)\n>}"},
+		{`#my/tag [1] ^:m x`, "", " ", "#my/tag [\n 1\n]\n^:m x"},
+		{`#:p{:x #inst "2014-03-14T15:59:59Z"}`, "", "  ", "#:p{\n  :x #inst \"2014-03-14T15:59:59Z\"\n}"},
+	} {
+		var buf bytes.Buffer
+		err := Indent(&buf, []byte(t.src), t.prefix, t.indent)
+		c.Check(err, check.IsNil, check.Commentf("%s", t.src))
+		c.Check(buf.String(), check.Equals, t.want, check.Commentf("%s", t.src))
+	}
+
+	buf := bytes.NewBufferString("keep")
+	c.Check(Indent(buf, []byte(`{:a [1}`), "", "  "), check.ErrorMatches, `edn: \[ closed by }`)
+	c.Check(buf.String(), check.Equals, "keep")
+}
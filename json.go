@@ -0,0 +1,456 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A JSONNumbers value says how FromJSON converts JSON numbers.
+type JSONNumbers int
+
+const (
+	// NumbersAuto converts integers that fit in an int64 to int64 and
+	// other numbers to float64.
+	NumbersAuto JSONNumbers = iota
+
+	// NumbersFloat converts every number to float64, as encoding/json
+	// does.
+	NumbersFloat
+
+	// NumbersExact converts integers that fit in an int64 to int64,
+	// larger ones to *big.Int, written with the N suffix, and other
+	// numbers to *big.Float, written with the M suffix, with enough
+	// precision to keep all of their digits.
+	NumbersExact
+)
+
+type jsonOptions struct {
+	stringKeys bool
+	numbers    JSONNumbers
+	tags       JSONTags
+	colons     bool
+	strictKeys bool
+}
+
+// A JSONOption configures FromJSON or ToJSON. Each option documents
+// which of them it affects.
+type JSONOption func(*jsonOptions)
+
+// JSONStringKeys is a JSONOption that keeps the keys of JSON objects
+// as strings. By default they become keywords, as KeywordizeMapKeys
+// makes them.
+func JSONStringKeys(on bool) JSONOption {
+	return func(o *jsonOptions) { o.stringKeys = on }
+}
+
+// JSONNumberMode is a JSONOption that sets how numbers are converted.
+// The default is NumbersAuto.
+func JSONNumberMode(mode JSONNumbers) JSONOption {
+	return func(o *jsonOptions) { o.numbers = mode }
+}
+
+// FromJSON reads a JSON document from r and returns it as an EDN
+// value: objects become maps, arrays Vecs, and null nil. Strings and
+// booleans are kept, and numbers are converted as the JSONNumberMode
+// option says. FromJSON fails if r does not hold exactly one JSON
+// value.
+//
+// Object keys become keywords unless the JSONStringKeys option is set;
+// an object whose keys all make valid keywords becomes a KMap, and
+// otherwise a map[interface{}]interface{} in which the keys that do
+// not stay strings.
+func FromJSON(r io.Reader, opts ...JSONOption) (interface{}, error) {
+	var o jsonOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("edn: invalid JSON: %v", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("edn: invalid JSON: data after the top-level value")
+	}
+	v, err := fromJSON(v, o.numbers)
+	if err != nil {
+		return nil, err
+	}
+	if !o.stringKeys {
+		v = KeywordizeMapKeys(v, "")
+	}
+	return v, nil
+}
+
+func fromJSON(v interface{}, mode JSONNumbers) (interface{}, error) {
+	var err error
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, xv := range x {
+			if x[k], err = fromJSON(xv, mode); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		vec := make(Vec, len(x))
+		for i, xv := range x {
+			if vec[i], err = fromJSON(xv, mode); err != nil {
+				return nil, err
+			}
+		}
+		return vec, nil
+	case json.Number:
+		return jsonNumber(x, mode)
+	}
+	return v, nil
+}
+
+func jsonNumber(n json.Number, mode JSONNumbers) (interface{}, error) {
+	s := string(n)
+	if mode != NumbersFloat {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+	}
+	if mode != NumbersExact {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("edn: JSON number %s out of range", s)
+		}
+		return f, nil
+	}
+	if !strings.ContainsAny(s, ".eE") {
+		i, _ := new(big.Int).SetString(s, 10)
+		return i, nil
+	}
+	// Four bits a digit are more than enough to keep every digit.
+	f, _, err := big.ParseFloat(s, 10, uint(4*len(s)), big.ToNearestEven)
+	if err != nil {
+		return nil, fmt.Errorf("edn: JSON number %s out of range", s)
+	}
+	return f, nil
+}
+
+// A JSONTags value says how ToJSON converts tagged literals.
+type JSONTags int
+
+const (
+	// TagsUnwrap converts a tagged literal to the JSON for its value,
+	// so that #inst "2014-03-14T15:59:59Z" becomes the string.
+	TagsUnwrap JSONTags = iota
+
+	// TagsWrap converts a tagged literal to an object holding its tag
+	// and value, as in {"tag": "inst", "value": "2014-03-14T15:59:59Z"}.
+	TagsWrap
+)
+
+// JSONTagMode is a JSONOption that sets how ToJSON converts tagged
+// literals. The default is TagsUnwrap.
+func JSONTagMode(mode JSONTags) JSONOption {
+	return func(o *jsonOptions) { o.tags = mode }
+}
+
+// JSONKeywordColons is a JSONOption that makes ToJSON keep the leading
+// colon of keywords, so that :db/id becomes ":db/id" rather than
+// "db/id".
+func JSONKeywordColons(on bool) JSONOption {
+	return func(o *jsonOptions) { o.colons = on }
+}
+
+// JSONStrictKeys is a JSONOption that makes ToJSON fail on map keys
+// that are neither strings nor keywords. By default such keys are
+// written as strings holding their EDN text, so that 1 becomes "1" and
+// [1 2] becomes "[1 2]".
+func JSONStrictKeys(on bool) JSONOption {
+	return func(o *jsonOptions) { o.strictKeys = on }
+}
+
+// ToJSON writes the EDN forms in ednData to w as JSON values, each
+// followed by a newline, as a json.Encoder writes them. Vectors, lists
+// and sets become arrays, maps objects, nil null, and keywords, symbols
+// and characters strings. Integers and decimals keep their digits, and
+// ratios become floats. Metadata and discarded forms are dropped.
+//
+// ToJSON works on the text, so it needs no Go types for the tags it
+// meets. It fails with a *SyntaxError if ednData is not well-formed
+// EDN, including numbers that are not valid literals or are too large
+// for a float64, and with an error if a value has no JSON equivalent,
+// such as ##Inf. Nothing is written to w if it fails.
+func ToJSON(w io.Writer, ednData []byte, opts ...JSONOption) error {
+	j := &jsonWriter{c: &compactor{src: ednData, skip: 1}}
+	for _, opt := range opts {
+		opt(&j.o)
+	}
+	for off := 0; ; {
+		next, err := j.value(off)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		j.buf.WriteByte('\n')
+		off = next
+	}
+	_, err := w.Write(j.buf.Bytes())
+	return err
+}
+
+type jsonWriter struct {
+	c   *compactor // for reading tokens, skipping discarded forms
+	o   jsonOptions
+	buf bytes.Buffer
+}
+
+// value writes the form at or after src[off] and returns the offset
+// just past it. It returns io.EOF if there are no more forms.
+func (j *jsonWriter) value(off int) (int, error) {
+	tok, off, err := j.c.token(off)
+	if err != nil {
+		return off, err
+	}
+	switch tok.kind {
+	case tokClose:
+		return off, &SyntaxError{fmt.Sprintf("unexpected %s", tok.text), int64(tok.off)}
+	case tokMeta:
+		if off, err = j.c.form(off); err == nil {
+			off, err = j.value(off)
+		}
+		return off, followed(tok, off, err)
+	case tokTag:
+		if j.o.tags == TagsUnwrap {
+			off, err = j.value(off)
+			return off, followed(tok, off, err)
+		}
+		j.buf.WriteString(`{"tag":`)
+		j.string(string(tok.text[1:]))
+		j.buf.WriteString(`,"value":`)
+		off, err = j.value(off)
+		j.buf.WriteByte('}')
+		return off, followed(tok, off, err)
+	case tokOpen:
+		if isMapOpen(tok.text) {
+			return j.object(off, tok)
+		}
+		return j.array(off, tok)
+	}
+	return off, j.atom(tok)
+}
+
+// followed turns io.EOF, met reading the form after the tag or ^ tok,
+// into a *SyntaxError.
+func followed(tok token, off int, err error) error {
+	if err == io.EOF {
+		return &SyntaxError{fmt.Sprintf("%s not followed by a value", tok.text), int64(off)}
+	}
+	return err
+}
+
+// end reports whether the collection opened by open ends at src[off],
+// and returns the offset past its closing delimiter if it does.
+func (j *jsonWriter) end(off int, open token) (bool, int, error) {
+	tok, next, err := j.c.token(off)
+	switch {
+	case err == io.EOF:
+		return false, next, &SyntaxError{fmt.Sprintf("unclosed %s", open.text), int64(next)}
+	case err != nil:
+		return false, next, err
+	case tok.kind != tokClose:
+		return false, off, nil
+	case tok.text[0] != closerFor(open.text):
+		return false, next, &SyntaxError{fmt.Sprintf("%s closed by %s", open.text, tok.text), int64(tok.off)}
+	}
+	return true, next, nil
+}
+
+func (j *jsonWriter) array(off int, open token) (int, error) {
+	j.buf.WriteByte('[')
+	for i := 0; ; i++ {
+		done, next, err := j.end(off, open)
+		if err != nil || done {
+			j.buf.WriteByte(']')
+			return next, err
+		}
+		if i > 0 {
+			j.buf.WriteByte(',')
+		}
+		if off, err = j.value(off); err != nil {
+			return off, err
+		}
+	}
+}
+
+// object writes the map opened by open, whose entries start at
+// src[off]. Keywords without a namespace among the keys of a #:ns{}
+// map are put in ns.
+func (j *jsonWriter) object(off int, open token) (int, error) {
+	ns := strings.TrimSuffix(strings.TrimPrefix(string(open.text), "#:"), "{")
+	j.buf.WriteByte('{')
+	for i := 0; ; i++ {
+		done, next, err := j.end(off, open)
+		if err != nil || done {
+			j.buf.WriteByte('}')
+			return next, err
+		}
+		if i > 0 {
+			j.buf.WriteByte(',')
+		}
+		if off, err = j.key(off, ns); err != nil {
+			return off, err
+		}
+		j.buf.WriteByte(':')
+		if off, err = j.value(off); err == io.EOF {
+			return off, &SyntaxError{fmt.Sprintf("unclosed %s", open.text), int64(off)}
+		} else if err != nil {
+			return off, err
+		}
+	}
+}
+
+func (j *jsonWriter) key(off int, ns string) (int, error) {
+	tok, next, err := j.c.token(off)
+	if err != nil {
+		return next, err
+	}
+	if tok.kind == tokAtom {
+		x, ok := atomValue(string(tok.text))
+		if !ok {
+			x = nil
+		}
+		switch x := x.(type) {
+		case string:
+			j.string(x)
+			return next, nil
+		case Keyword:
+			if ns != "" && x.Namespace() == "" {
+				x = Keyword(":" + ns + "/" + x.Name())
+			}
+			j.keyword(x)
+			return next, nil
+		}
+	}
+	// Other keys are written as their EDN text, once it is known to be
+	// valid; keys with no JSON equivalent, such as ##Inf, are allowed.
+	n := j.buf.Len()
+	next, err = j.value(off)
+	j.buf.Truncate(n)
+	if _, ok := err.(*SyntaxError); ok {
+		return next, err
+	} else if err != nil {
+		if next, err = j.c.form(off); err != nil {
+			return next, err
+		}
+	}
+	text, err := compactText(j.c.src[tok.off:next], nil)
+	if err != nil {
+		return next, err
+	}
+	if j.o.strictKeys {
+		return next, fmt.Errorf("edn: cannot convert map key %s to JSON", text)
+	}
+	j.string(text)
+	return next, nil
+}
+
+func (j *jsonWriter) atom(tok token) error {
+	s := string(tok.text)
+	if numberLike(s) {
+		return j.number(tok)
+	}
+	x, ok := atomValue(s)
+	switch {
+	case !ok && strings.HasPrefix(s, "##"):
+		return fmt.Errorf("edn: cannot convert %s to JSON", s)
+	case !ok:
+		return &SyntaxError{fmt.Sprintf("invalid %s", s), int64(tok.off)}
+	}
+	switch x := x.(type) {
+	case nil:
+		j.buf.WriteString("null")
+	case bool:
+		j.buf.WriteString(strconv.FormatBool(x))
+	case string:
+		j.string(x)
+	case Char:
+		j.string(string(rune(x)))
+	case Keyword:
+		j.keyword(x)
+	case Symbol:
+		j.string(string(x))
+	}
+	return nil
+}
+
+func (j *jsonWriter) keyword(k Keyword) {
+	s := strings.TrimPrefix(string(k), ":")
+	if j.o.colons {
+		s = ":" + s
+	}
+	j.string(s)
+}
+
+func (j *jsonWriter) string(s string) {
+	enc := json.NewEncoder(&j.buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	j.buf.Truncate(j.buf.Len() - 1) // the newline Encode adds
+}
+
+// The numeric literals of EDN, and hexadecimal integers as Clojure
+// reads them.
+var (
+	ednInt     = regexp.MustCompile(`^[+-]?(0|[1-9][0-9]*)N?$`)
+	ednHexInt  = regexp.MustCompile(`^[+-]?0[xX][0-9a-fA-F]+N?$`)
+	ednFloat   = regexp.MustCompile(`^[+-]?(0|[1-9][0-9]*)(\.[0-9]*)?([eE][+-]?[0-9]+)?M?$`)
+	ednRatio   = regexp.MustCompile(`^[+-]?[0-9]+/[0-9]+$`)
+	jsonDotEnd = regexp.MustCompile(`\.($|[eE])`)
+)
+
+// number writes the numeric literal in tok, which may have an N or M
+// suffix, be in hexadecimal, or be a ratio. Integers keep all their
+// digits; other numbers must fit in a float64.
+func (j *jsonWriter) number(tok token) error {
+	s := string(tok.text)
+	lit := strings.TrimPrefix(strings.TrimRight(s, "NM"), "+")
+	switch {
+	case ednInt.MatchString(s):
+		j.buf.WriteString(lit)
+		return nil
+	case ednHexInt.MatchString(s):
+		i, _ := new(big.Int).SetString(lit, 0)
+		j.buf.WriteString(i.String())
+		return nil
+	case ednFloat.MatchString(s):
+		if f, err := strconv.ParseFloat(lit, 64); err != nil && math.IsInf(f, 0) {
+			break
+		}
+		// 1. and 1.e5 are EDN but not JSON.
+		j.buf.WriteString(jsonDotEnd.ReplaceAllString(lit, "$1"))
+		return nil
+	case ednRatio.MatchString(s):
+		r, ok := new(big.Rat).SetString(lit)
+		if !ok {
+			return &SyntaxError{fmt.Sprintf("invalid number %s", s), int64(tok.off)}
+		}
+		f, _ := r.Float64()
+		if math.IsInf(f, 0) {
+			break
+		}
+		j.buf.WriteString(strconv.FormatFloat(f, 'g', -1, 64))
+		return nil
+	default:
+		return &SyntaxError{fmt.Sprintf("invalid number %s", s), int64(tok.off)}
+	}
+	return &SyntaxError{fmt.Sprintf("number %s out of range", s), int64(tok.off)}
+}
@@ -0,0 +1,145 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"gopkg.in/check.v1"
+	"math/big"
+	"strings"
+)
+
+type JSONTests struct{}
+
+func init() { check.Suite(&JSONTests{}) }
+
+func (*JSONTests) TestFromJSON(c *check.C) {
+	v, err := FromJSON(strings.NewReader(`{"id": 7, "tags": ["a", null, true], "price": 9.5, "db/host": "h"}`))
+	c.Assert(err, check.IsNil)
+	c.Check(v, check.DeepEquals, KMap{
+		"id":      int64(7),
+		"tags":    Vec{"a", nil, true},
+		"price":   9.5,
+		"db/host": "h",
+	})
+	b, err := MarshalCanonical(v)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, `{:db/host "h" :id 7 :price 9.5 :tags ["a" nil true]}`)
+
+	v, err = FromJSON(strings.NewReader(`{"a b": {"c": 1}, "d": 2}`))
+	c.Assert(err, check.IsNil)
+	c.Check(v, check.DeepEquals, map[interface{}]interface{}{
+		"a b":  KMap{"c": int64(1)},
+		K("d"): int64(2),
+	})
+
+	v, err = FromJSON(strings.NewReader(`{"a": [{"b": 1}]}`), JSONStringKeys(true))
+	c.Assert(err, check.IsNil)
+	c.Check(v, check.DeepEquals, map[string]interface{}{"a": Vec{map[string]interface{}{"b": int64(1)}}})
+}
+
+func (*JSONTests) TestFromJSONNumbers(c *check.C) {
+	const src = `[1, 2.5, 12345678901234567890, 0.1]`
+	v, err := FromJSON(strings.NewReader(src), JSONNumberMode(NumbersFloat))
+	c.Assert(err, check.IsNil)
+	c.Check(v, check.DeepEquals, Vec{1.0, 2.5, 12345678901234567890.0, 0.1})
+
+	v, err = FromJSON(strings.NewReader(src))
+	c.Assert(err, check.IsNil)
+	c.Check(v, check.DeepEquals, Vec{int64(1), 2.5, 12345678901234567890.0, 0.1})
+
+	v, err = FromJSON(strings.NewReader(src), JSONNumberMode(NumbersExact))
+	c.Assert(err, check.IsNil)
+	c.Check(v.(Vec)[2], check.FitsTypeOf, new(big.Int))
+	b, err := Marshal(v)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, `[1 2.5M 12345678901234567890N 0.1M]`)
+
+	_, err = FromJSON(strings.NewReader(`1e400`))
+	c.Check(err, check.ErrorMatches, `edn: JSON number 1e400 out of range`)
+}
+
+func (*JSONTests) TestFromJSONErrors(c *check.C) {
+	_, err := FromJSON(strings.NewReader(`{"a": }`))
+	c.Check(err, check.ErrorMatches, `edn: invalid JSON: .*`)
+	_, err = FromJSON(strings.NewReader(`1 2`))
+	c.Check(err, check.ErrorMatches, `edn: invalid JSON: data after the top-level value`)
+	_, err = FromJSON(strings.NewReader(``))
+	c.Check(err, check.ErrorMatches, `edn: invalid JSON: EOF`)
+}
+
+func (*JSONTests) TestToJSON(c *check.C) {
+	for _, t := range []struct {
+		src, want string
+	}{
+		{``, ``},
+		{`{:id 7, :db/host "h<1>", "s" [1 2.5 nil true]}`, `{"id":7,"db/host":"h<1>","s":[1,2.5,null,true]}`},
+		{`#{:a} (b c) \x \newline`, "[\"a\"]\n[\"b\",\"c\"]\n\"x\"\n\"\\n\""},
+		{`[12345678901234567890N 1.50M +3 0x1F -1/4 1. 1.e2 2M 0 -0.5e-3]`, `[12345678901234567890,1.50,3,31,-0.25,1,1e2,2,0,-0.5e-3]`},
+		{`#inst "2014-03-14T15:59:59Z"`, `"2014-03-14T15:59:59Z"`},
+		{`[1 #_ 2 ^:private [3] #_ #_ 4 5]`, `[1,[3]]`},
+		{`#:person{:name "a" :db/id 1 :_/x 2}`, `{"person/name":"a","db/id":1,"_/x":2}`},
+		{`{1 :one [1 2] :pair nil :none \c "c" ##Inf :inf [##NaN] 0}`, `{"1":"one","[1 2]":"pair","nil":"none","\\c":"c","##Inf":"inf","[##NaN]":0}`},
+		{"\"two\nlines\\t\"", `"two\nlines\t"`},
+	} {
+		var buf bytes.Buffer
+		err := ToJSON(&buf, []byte(t.src))
+		c.Check(err, check.IsNil, check.Commentf("%s", t.src))
+		want := t.want
+		if want != "" {
+			want += "\n"
+		}
+		c.Check(buf.String(), check.Equals, want, check.Commentf("%s", t.src))
+	}
+}
+
+func (*JSONTests) TestToJSONOptions(c *check.C) {
+	var buf bytes.Buffer
+	err := ToJSON(&buf, []byte(`{:at #inst "2014-03-14T15:59:59Z" :tags #{:db/a}}`),
+		JSONTagMode(TagsWrap), JSONKeywordColons(true))
+	c.Assert(err, check.IsNil)
+	c.Check(buf.String(), check.Equals, `{":at":{"tag":"inst","value":"2014-03-14T15:59:59Z"},":tags":[":db/a"]}`+"\n")
+
+	buf.Reset()
+	err = ToJSON(&buf, []byte(`{:a 1 "b" 2 [3] 4}`), JSONStrictKeys(true))
+	c.Check(err, check.ErrorMatches, `edn: cannot convert map key \[3\] to JSON`)
+}
+
+func (*JSONTests) TestToJSONErrors(c *check.C) {
+	var buf bytes.Buffer
+	c.Check(ToJSON(&buf, []byte(`[1 ##Inf]`)), check.ErrorMatches, `edn: cannot convert ##Inf to JSON`)
+	for _, t := range []struct {
+		src, err string
+	}{
+		{`{:a [1}`, `edn: \[ closed by }`},
+		{`[1 2`, `edn: unclosed \[`},
+		{`{:a`, `edn: unclosed {`},
+		{`[1])`, `edn: unexpected \)`},
+		{`[#tag]`, `edn: unexpected \]`},
+		{`#tag`, `edn: #tag not followed by a value`},
+		{`[1 #_]`, `edn: unexpected \]`},
+		{`[08]`, `edn: invalid number 08`},
+		{`017`, `edn: invalid number 017`},
+		{`1.5.2`, `edn: invalid number 1.5.2`},
+		{`1/0`, `edn: invalid number 1/0`},
+		{`1e400`, `edn: number 1e400 out of range`},
+		{`-1e400M`, `edn: number -1e400M out of range`},
+		{`{: 1}`, `edn: invalid :`},
+		{`{08 1}`, `edn: invalid number 08`},
+		{`{[1 08] 1}`, `edn: invalid number 08`},
+		{`{[##Inf (] 1}`, `edn: \( closed by \]`},
+	} {
+		err := ToJSON(&buf, []byte(t.src))
+		c.Check(err, check.FitsTypeOf, &SyntaxError{}, check.Commentf("%s", t.src))
+		c.Check(err, check.ErrorMatches, t.err, check.Commentf("%s", t.src))
+	}
+	c.Check(buf.Len(), check.Equals, 0)
+
+	v, err := FromJSON(strings.NewReader(`{"a": [1, "x", null]}`))
+	c.Assert(err, check.IsNil)
+	b, err := Marshal(v)
+	c.Assert(err, check.IsNil)
+	c.Assert(ToJSON(&buf, b), check.IsNil)
+	c.Check(buf.String(), check.Equals, `{"a":[1,"x",null]}`+"\n")
+}
@@ -0,0 +1,279 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// A Merger combines and filters maps. Keys are matched with Equal,
+// optionally also treating strings and keywords with the same text as
+// the same key. The zero Merger is ready to use; the package functions
+// Merge, DeepMerge and SelectKeys use it.
+//
+// The maps a Merger works with are map[interface{}]interface{}, KMap,
+// SMap, map[string]interface{} and *OrderedMap. Other values, including
+// HashMaps, are not merged into, but replaced. Nil maps are skipped.
+type Merger struct {
+	// KeywordStrings makes a string key and a keyword key with the
+	// same text, such as "port" and :port, the same key, as when
+	// layering JSON-shaped settings over EDN ones. The key of the
+	// earlier map is kept.
+	KeywordStrings bool
+}
+
+// Merge returns a copy of the first non-nil map with the entries of
+// the later ones added in order, as Clojure's merge does; where maps
+// share a key, the last value wins. It returns nil if there are no
+// non-nil maps, and fails if an argument is not a map, or has a key the
+// result's type of map cannot hold, such as a number key merged into a
+// KMap.
+func (mg Merger) Merge(maps ...interface{}) (interface{}, error) {
+	return mg.merge(false, maps)
+}
+
+// DeepMerge is like Merge, except that where maps share a key whose
+// values are both maps, the values are deep-merged in turn, and where
+// the values are both sets of the same type, the result is their union.
+func (mg Merger) DeepMerge(maps ...interface{}) (interface{}, error) {
+	return mg.merge(true, maps)
+}
+
+// SelectKeys returns a map of the same type as m holding only the
+// entries of m whose keys match one of keys, as Clojure's select-keys
+// does.
+func (mg Merger) SelectKeys(m interface{}, keys ...interface{}) (interface{}, error) {
+	if !isMergeable(m) {
+		return nil, fmt.Errorf("edn: cannot select keys of %T", m)
+	}
+	out := emptyLike(m)
+	for _, ent := range mergeEntries(m) {
+		for _, k := range keys {
+			if mg.sameKey(ent.k, k) {
+				if err := mg.put(out, ent.k, ent.v); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// Merge calls Merger{}.Merge.
+func Merge(maps ...interface{}) (interface{}, error) {
+	return Merger{}.Merge(maps...)
+}
+
+// DeepMerge calls Merger{}.DeepMerge.
+func DeepMerge(maps ...interface{}) (interface{}, error) {
+	return Merger{}.DeepMerge(maps...)
+}
+
+// SelectKeys calls Merger{}.SelectKeys.
+func SelectKeys(m interface{}, keys ...interface{}) (interface{}, error) {
+	return Merger{}.SelectKeys(m, keys...)
+}
+
+func (mg Merger) merge(deep bool, maps []interface{}) (interface{}, error) {
+	var out interface{}
+	for _, m := range maps {
+		if isNilMap(m) {
+			continue
+		}
+		if !isMergeable(m) {
+			return nil, fmt.Errorf("edn: cannot merge %T", m)
+		}
+		if out == nil {
+			out = emptyLike(m)
+		}
+		for _, ent := range mergeEntries(m) {
+			v := ent.v
+			if deep {
+				if old, ok := mg.lookup(out, ent.k); ok {
+					var err error
+					if v, err = mg.mergeValues(old, v); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if err := mg.put(out, ent.k, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out, nil
+}
+
+// mergeValues returns the deep merge of the values old and v of a key.
+func (mg Merger) mergeValues(old, v interface{}) (interface{}, error) {
+	switch {
+	case isMergeable(old) && isMergeable(v):
+		return mg.merge(true, []interface{}{old, v})
+	case old != nil && v != nil && reflect.TypeOf(old) == reflect.TypeOf(v) && isSetType(reflect.TypeOf(v)):
+		a, b := reflect.ValueOf(old), reflect.ValueOf(v)
+		u := reflect.MakeMapWithSize(a.Type(), a.Len()+b.Len())
+		for _, s := range []reflect.Value{a, b} {
+			iter := s.MapRange()
+			for iter.Next() {
+				u.SetMapIndex(iter.Key(), iter.Value())
+			}
+		}
+		return u.Interface(), nil
+	}
+	return v, nil
+}
+
+func isMergeable(m interface{}) bool {
+	switch m.(type) {
+	case map[interface{}]interface{}, KMap, SMap, map[string]interface{}, *OrderedMap:
+		return true
+	}
+	return false
+}
+
+func isNilMap(m interface{}) bool {
+	if m == nil {
+		return true
+	}
+	v := reflect.ValueOf(m)
+	return (v.Kind() == reflect.Map || v.Kind() == reflect.Ptr) && v.IsNil()
+}
+
+// emptyLike returns an empty map of the same type as m.
+func emptyLike(m interface{}) interface{} {
+	switch m.(type) {
+	case KMap:
+		return KMap{}
+	case SMap:
+		return SMap{}
+	case map[string]interface{}:
+		return map[string]interface{}{}
+	case *OrderedMap:
+		return new(OrderedMap)
+	}
+	return map[interface{}]interface{}{}
+}
+
+type mergeEntry struct {
+	k, v interface{}
+}
+
+// mergeEntries returns the entries of m, with the keys of KMaps and
+// SMaps as keywords and symbols.
+func mergeEntries(m interface{}) []mergeEntry {
+	var ents []mergeEntry
+	switch p := m.(type) {
+	case *OrderedMap:
+		for _, k := range p.keys {
+			ents = append(ents, mergeEntry{k, p.vals[k]})
+		}
+		return ents
+	case *HashMap:
+		m = *p
+	}
+	for _, ent := range mapEntries(reflect.ValueOf(m)) {
+		ents = append(ents, mergeEntry{valueOrNil(ent.k), valueOrNil(ent.v)})
+	}
+	return ents
+}
+
+func valueOrNil(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	return v.Interface()
+}
+
+// sameKey reports whether a and b are the same map key.
+func (mg Merger) sameKey(a, b interface{}) bool {
+	if mg.KeywordStrings {
+		a, b = keywordOfString(a), keywordOfString(b)
+	}
+	return Equal(a, b)
+}
+
+func keywordOfString(k interface{}) interface{} {
+	if s, ok := k.(string); ok {
+		return Keyword(s)
+	}
+	return k
+}
+
+// lookup returns the value of key k in out.
+func (mg Merger) lookup(out, k interface{}) (interface{}, bool) {
+	if x, ok := get(out, k); ok {
+		return x, true
+	}
+	if mg.KeywordStrings {
+		switch k := k.(type) {
+		case string:
+			return get(out, Keyword(k))
+		case Keyword:
+			return get(out, strings.TrimPrefix(string(k), ":"))
+		}
+	}
+	return nil, false
+}
+
+// put sets key k of out, which emptyLike made, to v, keeping the key
+// already there if there is a matching one.
+func (mg Merger) put(out, k, v interface{}) error {
+	switch m := out.(type) {
+	case map[interface{}]interface{}:
+		if isComparable(k) {
+			if _, ok := m[k]; ok {
+				m[k] = v
+				return nil
+			}
+		}
+		for mk := range m {
+			if mg.sameKey(mk, k) {
+				m[mk] = v
+				return nil
+			}
+		}
+		if !isComparable(k) {
+			return fmt.Errorf("edn: cannot use %#v as a map key", k)
+		}
+		m[k] = v
+		return nil
+	case KMap:
+		if s, ok := k.(string); ok && mg.KeywordStrings {
+			k = Keyword(s)
+		}
+		if kw, ok := k.(Keyword); ok {
+			m[strings.TrimPrefix(string(kw), ":")] = v
+			return nil
+		}
+	case SMap:
+		if sym, ok := k.(Symbol); ok {
+			m[string(sym)] = v
+			return nil
+		}
+	case map[string]interface{}:
+		if kw, ok := k.(Keyword); ok && mg.KeywordStrings {
+			k = strings.TrimPrefix(string(kw), ":")
+		}
+		if s, ok := k.(string); ok {
+			m[s] = v
+			return nil
+		}
+	case *OrderedMap:
+		for _, mk := range m.keys {
+			if mg.sameKey(mk, k) {
+				m.vals[mk] = v
+				return nil
+			}
+		}
+		if !isComparable(k) {
+			return fmt.Errorf("edn: cannot use %#v as a map key", k)
+		}
+		m.Set(k, v)
+		return nil
+	}
+	return fmt.Errorf("edn: cannot merge key %#v into %T", k, out)
+}
@@ -0,0 +1,115 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type MergeTests struct{}
+
+func init() { check.Suite(&MergeTests{}) }
+
+func (*MergeTests) TestMerge(c *check.C) {
+	a := KMap{"host": "localhost", "port": 80}
+	b := map[interface{}]interface{}{K("port"): 8080, K("debug"): true}
+	got, err := Merge(nil, a, KMap(nil), b)
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, KMap{"host": "localhost", "port": 8080, "debug": true})
+	c.Check(a, check.DeepEquals, KMap{"host": "localhost", "port": 80})
+
+	got, err = Merge()
+	c.Check(got, check.IsNil)
+	c.Check(err, check.IsNil)
+
+	_, err = Merge(a, map[interface{}]interface{}{1: 2})
+	c.Check(err, check.ErrorMatches, `edn: cannot merge key 1 into edn.KMap`)
+	_, err = Merge(a, Vec{1})
+	c.Check(err, check.ErrorMatches, `edn: cannot merge edn.Vec`)
+
+	// Without KeywordStrings, "port" and :port are different keys.
+	got, err = Merge(map[interface{}]interface{}{K("port"): 80}, map[string]interface{}{"port": 8080})
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, map[interface{}]interface{}{K("port"): 80, "port": 8080})
+}
+
+func (*MergeTests) TestMergeKeywordStrings(c *check.C) {
+	mg := Merger{KeywordStrings: true}
+	got, err := mg.Merge(
+		map[interface{}]interface{}{K("port"): 80},
+		map[string]interface{}{"port": 8080},
+	)
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, map[interface{}]interface{}{K("port"): 8080})
+
+	got, err = mg.Merge(KMap{"port": 80}, map[string]interface{}{"port": 8080, "host": "h"})
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, KMap{"port": 8080, "host": "h"})
+
+	got, err = mg.Merge(map[string]interface{}{"port": 80}, KMap{"port": 8080})
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, map[string]interface{}{"port": 8080})
+}
+
+func (*MergeTests) TestDeepMerge(c *check.C) {
+	base := KMap{
+		"db":    KMap{"host": "localhost", "pool": KMap{"min": 1, "max": 4}},
+		"tags":  NewSet(K("a")),
+		"hosts": Vec{"x"},
+	}
+	over := KMap{
+		"db":    KMap{"pool": KMap{"max": 16}},
+		"tags":  NewSet(K("b")),
+		"hosts": Vec{"y"},
+	}
+	got, err := DeepMerge(base, over)
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, KMap{
+		"db":    KMap{"host": "localhost", "pool": KMap{"min": 1, "max": 16}},
+		"tags":  NewSet(K("a"), K("b")),
+		"hosts": Vec{"y"},
+	})
+	c.Check(base["db"], check.DeepEquals, KMap{"host": "localhost", "pool": KMap{"min": 1, "max": 4}})
+	c.Check(base["tags"], check.DeepEquals, NewSet(K("a")))
+
+	// A map over a non-map replaces it.
+	got, err = DeepMerge(KMap{"db": "off"}, KMap{"db": KMap{"host": "h"}})
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, KMap{"db": KMap{"host": "h"}})
+
+	got, err = Merger{KeywordStrings: true}.DeepMerge(
+		KMap{"db": KMap{"host": "localhost", "port": 5432}},
+		map[string]interface{}{"db": map[string]interface{}{"port": 6543}},
+	)
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, KMap{"db": KMap{"host": "localhost", "port": 6543}})
+}
+
+func (*MergeTests) TestDeepMergeOrderedMap(c *check.C) {
+	var a, b OrderedMap
+	a.Set(K("x"), 1)
+	a.Set(K("y"), KMap{"z": 1})
+	b.Set(K("y"), KMap{"w": 2})
+	b.Set(K("v"), 3)
+	got, err := DeepMerge(&a, &b)
+	c.Assert(err, check.IsNil)
+	om := got.(*OrderedMap)
+	c.Check(om.Keys(), check.DeepEquals, []interface{}{K("x"), K("y"), K("v")})
+	y, _ := om.Get(K("y"))
+	c.Check(y, check.DeepEquals, KMap{"z": 1, "w": 2})
+}
+
+func (*MergeTests) TestSelectKeys(c *check.C) {
+	m := map[interface{}]interface{}{K("a"): 1, K("b"): 2, "c": 3}
+	got, err := SelectKeys(m, K(":a"), "c", K("missing"))
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, map[interface{}]interface{}{K("a"): 1, "c": 3})
+
+	got, err = Merger{KeywordStrings: true}.SelectKeys(KMap{"a": 1, "b": 2}, "a")
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, KMap{"a": 1})
+
+	_, err = SelectKeys(Vec{1}, 0)
+	c.Check(err, check.ErrorMatches, `edn: cannot select keys of edn.Vec`)
+}
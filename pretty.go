@@ -0,0 +1,251 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// DefaultPrettyWidth is a customary terminal width for Pretty.
+const DefaultPrettyWidth = 80
+
+// MarshalPretty is like Marshal but pretty-prints the result, as Pretty
+// does, to fit within width columns.
+func MarshalPretty(v interface{}, width int) ([]byte, error) {
+	b, err := Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := Pretty(&buf, b, width); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Pretty appends to dst a pretty-printed form of the EDN-encoded src,
+// fitted to width columns in the manner of Clojure's pprint: a form is
+// written on one line if it fits, and otherwise its elements are
+// written one per line, aligned after its opening delimiter. Map
+// entries stay on the line of their key. Atoms wider than the remaining
+// space are never split, so some lines may still exceed width.
+//
+// Comments, discarded forms and the original whitespace of src are
+// dropped. If src holds several forms, each is written on its own line.
+func Pretty(dst *bytes.Buffer, src []byte, width int) error {
+	for off, first := 0, true; ; first = false {
+		n, next, err := parseForm(src, off)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !first {
+			dst.WriteByte('\n')
+		}
+		p := &prettyPrinter{dst: dst, width: width}
+		p.node(n)
+		off = next
+	}
+}
+
+// A ppKind classifies the nodes of the tree Pretty lays out.
+type ppKind int
+
+const (
+	ppAtom   ppKind = iota
+	ppColl          // a collection; text is its opening delimiter
+	ppTagged        // a tagged element; text is the tag
+	ppMeta          // kids are the metadata and the value
+)
+
+type ppNode struct {
+	kind  ppKind
+	text  []byte
+	kids  []*ppNode
+	width int // width of the node written on one line
+}
+
+func (n *ppNode) isMap() bool {
+	return n.kind == ppColl && (string(n.text) == "{" || bytes.HasPrefix(n.text, []byte("#:")))
+}
+
+// parseForm parses the form starting at or after src[off] into a tree,
+// and returns the offset just past it. It returns io.EOF if there are
+// no more forms. Discarded forms are skipped.
+func parseForm(src []byte, off int) (*ppNode, int, error) {
+	return parseFormWith(&compactor{src: src, skip: 1}, off)
+}
+
+// parseFormWith is parseForm reading tokens with c, which skips
+// discarded forms without writing anything.
+func parseFormWith(c *compactor, off int) (*ppNode, int, error) {
+	tok, off, err := c.token(off)
+	if err != nil {
+		return nil, off, err
+	}
+	n := &ppNode{text: tok.text}
+	switch tok.kind {
+	case tokAtom:
+		n.width = utf8.RuneCount(tok.text)
+	case tokClose:
+		return nil, off, &SyntaxError{fmt.Sprintf("unexpected %s", tok.text), int64(tok.off)}
+	case tokTag, tokMeta:
+		n.kind = ppTagged
+		nkids := 1
+		if tok.kind == tokMeta {
+			n.kind = ppMeta
+			nkids = 2
+		}
+		for i := 0; i < nkids; i++ {
+			kid, next, err := parseFormWith(c, off)
+			if err == io.EOF {
+				return nil, next, &SyntaxError{fmt.Sprintf("%s not followed by a value", tok.text), int64(next)}
+			}
+			if err != nil {
+				return nil, next, err
+			}
+			n.kids = append(n.kids, kid)
+			off = next
+		}
+		if n.kind == ppMeta {
+			n.width = 1 + n.kids[0].width + 1 + n.kids[1].width
+		} else {
+			n.width = utf8.RuneCount(n.text) + 1 + n.kids[0].width
+		}
+	case tokOpen:
+		n.kind = ppColl
+		closer := closerFor(tok.text)
+		for {
+			t, next, err := c.token(off)
+			if err == io.EOF {
+				return nil, next, &SyntaxError{fmt.Sprintf("unclosed %s", tok.text), int64(next)}
+			}
+			if err != nil {
+				return nil, next, err
+			}
+			if t.kind == tokClose {
+				if t.text[0] != closer {
+					return nil, next, &SyntaxError{fmt.Sprintf("%s closed by %s", tok.text, t.text), int64(t.off)}
+				}
+				off = next
+				break
+			}
+			kid, next, err := parseFormWith(c, off)
+			if err != nil {
+				return nil, next, err
+			}
+			n.kids = append(n.kids, kid)
+			off = next
+		}
+		n.width = utf8.RuneCount(n.text) + 1
+		for i, kid := range n.kids {
+			n.width += kid.width + len(n.sep(i))
+		}
+	}
+	return n, off, nil
+}
+
+// sep returns the separator written before the i'th element of the
+// collection n when it is written on one line.
+func (n *ppNode) sep(i int) string {
+	switch {
+	case i == 0:
+		return ""
+	case n.isMap() && i%2 == 0:
+		return ", "
+	}
+	return " "
+}
+
+type prettyPrinter struct {
+	dst   *bytes.Buffer
+	width int
+	col   int // current column
+}
+
+func (p *prettyPrinter) write(b []byte) {
+	p.dst.Write(b)
+	p.col += utf8.RuneCount(b)
+}
+
+func (p *prettyPrinter) writeString(s string) {
+	p.dst.WriteString(s)
+	p.col += utf8.RuneCountInString(s)
+}
+
+func (p *prettyPrinter) newline(indent int) {
+	p.dst.WriteByte('\n')
+	for i := 0; i < indent; i++ {
+		p.dst.WriteByte(' ')
+	}
+	p.col = indent
+}
+
+// flat writes n on one line.
+func (p *prettyPrinter) flat(n *ppNode) {
+	switch n.kind {
+	case ppAtom:
+		p.write(n.text)
+	case ppTagged:
+		p.write(n.text)
+		p.writeString(" ")
+		p.flat(n.kids[0])
+	case ppMeta:
+		p.writeString("^")
+		p.flat(n.kids[0])
+		p.writeString(" ")
+		p.flat(n.kids[1])
+	case ppColl:
+		p.write(n.text)
+		for i, kid := range n.kids {
+			p.writeString(n.sep(i))
+			p.flat(kid)
+		}
+		p.writeString(string(closerFor(n.text)))
+	}
+}
+
+// node writes n, breaking it across lines if it does not fit.
+func (p *prettyPrinter) node(n *ppNode) {
+	if p.col+n.width <= p.width {
+		p.flat(n)
+		return
+	}
+	switch n.kind {
+	case ppAtom:
+		p.write(n.text)
+	case ppTagged:
+		p.write(n.text)
+		p.writeString(" ")
+		p.node(n.kids[0])
+	case ppMeta:
+		p.writeString("^")
+		p.node(n.kids[0])
+		p.writeString(" ")
+		p.node(n.kids[1])
+	case ppColl:
+		p.write(n.text)
+		indent := p.col
+		isMap := n.isMap()
+		for i, kid := range n.kids {
+			switch {
+			case i == 0:
+			case isMap && i%2 == 1:
+				p.writeString(" ")
+			case isMap:
+				p.writeString(",")
+				p.newline(indent)
+			default:
+				p.newline(indent)
+			}
+			p.node(kid)
+		}
+		p.writeString(string(closerFor(n.text)))
+	}
+}
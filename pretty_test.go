@@ -0,0 +1,72 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"gopkg.in/check.v1"
+)
+
+type PrettyTests struct{}
+
+func init() { check.Suite(&PrettyTests{}) }
+
+func (*PrettyTests) TestPretty(c *check.C) {
+	for _, t := range []struct {
+		src   string
+		width int
+		want  string
+	}{
+		{`[1 2 3]`, 80, `[1 2 3]`},
+		{`[1 2 3]`, 6, "[1\n 2\n 3]"},
+		{`(defn f [x] (+ x 1))`, 12, "(defn\n f\n [x]\n (+ x 1))"},
+		{`{:a 1, :b [10 20 30]}`, 16, "{:a 1,\n :b [10 20 30]}"},
+		{`{:a 1, :b [10 20 30]}`, 10, "{:a 1,\n :b [10\n     20\n     30]}"},
+		{`#{"abc" "def"}`, 8, "#{\"abc\"\n  \"def\"}"},
+		{`#:p{:a 1 :b 2}`, 10, "#:p{:a 1,\n    :b 2}"},
+		{`#inst "2014-03-14T15:59:59Z"`, 10, `#inst "2014-03-14T15:59:59Z"`},
+		{`#my/tag [1 2]`, 10, "#my/tag [1\n         2]"},
+		{`^{:a 1} [x y]`, 8, "^{:a 1} [x\n         y]"},
+		{"[\\( \\space ##Inf] ; comment\n:k", 80, "[\\( \\space ##Inf]\n:k"},
+		{`{"é" "ü"}`, 9, `{"é" "ü"}`},
+		{`{:a #_ 1 2}`, 80, `{:a 2}`},
+		{`{:a #_ 1 2 :b 3}`, 6, "{:a 2,\n :b 3}"},
+		{`{#_ :x :a 1, :b #_ #_ 2 3 4}`, 80, `{:a 1, :b 4}`},
+		{`[1 #_ #_ 2 3 4]`, 80, `[1 4]`},
+		{`#_ 1 [#_ x] #_ 2`, 80, `[]`},
+		{``, 80, ``},
+	} {
+		var buf bytes.Buffer
+		c.Check(Pretty(&buf, []byte(t.src), t.width), check.IsNil)
+		c.Check(buf.String(), check.Equals, t.want, check.Commentf("%q at width %d", t.src, t.width))
+	}
+}
+
+func (*PrettyTests) TestPrettySyntaxErrors(c *check.C) {
+	for _, t := range []struct {
+		src, err string
+	}{
+		{`[1 2`, `edn: unclosed \[`},
+		{`[1 2)`, `edn: \[ closed by \)`},
+		{`)`, `edn: unexpected \)`},
+		{`"abc`, `edn: unterminated string`},
+		{`#tag`, `edn: #tag not followed by a value`},
+		{`[1 #_]`, `edn: unexpected \]`},
+		{`{:a #_`, `edn: #_ not followed by a value`},
+		{`#:ns[`, `edn: namespaced map prefix not followed by {`},
+	} {
+		var buf bytes.Buffer
+		c.Check(Pretty(&buf, []byte(t.src), 80), check.ErrorMatches, t.err)
+	}
+}
+
+func (*PrettyTests) TestMarshalPretty(c *check.C) {
+	v := []interface{}{KMap{"name": "widget"}, List{S("f"), 1}}
+	b, err := MarshalPretty(v, 16)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, "[{:name \"widget\"}\n (f 1)]")
+	b, err = MarshalPretty(v, DefaultPrettyWidth)
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, `[{:name "widget"} (f 1)]`)
+}
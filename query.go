@@ -0,0 +1,226 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Query returns the values in v that path selects. A path is a
+// sequence of steps, written in EDN and separated by whitespace, each
+// applied to every value the steps before it selected:
+//
+//	:name, "name", sym    the value under the key, as in GetIn
+//	3                     the element at the index, or under the key 3
+//	*                     every element of a collection: the values of
+//	                      a map, or the elements of a vector, list or set
+//	**                    the value itself and every value below it
+//	(has :k)              the value, if it is a map with the key :k
+//	(= :k x)              the value, if its value under :k is Equal to x
+//
+// For example, ":orders * (= :status :open) :id" selects the ids of the
+// open orders, and "** (has :email) :email" every email in v. Matches
+// are returned in the order they are found; the elements of maps and
+// sets are visited in the order a Zipper visits them. Query fails if
+// path is not a valid path.
+func Query(v interface{}, path string) ([]interface{}, error) {
+	steps, err := parseQuery(path)
+	if err != nil {
+		return nil, err
+	}
+	vs := []interface{}{v}
+	for _, s := range steps {
+		var next []interface{}
+		for _, x := range vs {
+			next = s.apply(x, next)
+		}
+		vs = next
+	}
+	return vs, nil
+}
+
+// A queryStep selects values from each value it is applied to.
+type queryStep interface {
+	apply(v interface{}, out []interface{}) []interface{}
+}
+
+type keyStep struct{ k interface{} }
+
+func (s keyStep) apply(v interface{}, out []interface{}) []interface{} {
+	if x, ok := get(v, s.k); ok {
+		out = append(out, x)
+	}
+	return out
+}
+
+type wildStep struct{}
+
+func (wildStep) apply(v interface{}, out []interface{}) []interface{} {
+	keys, _ := childKeys(v)
+	for _, k := range keys {
+		x, _ := get(v, k)
+		out = append(out, x)
+	}
+	return out
+}
+
+type descStep struct{}
+
+func (descStep) apply(v interface{}, out []interface{}) []interface{} {
+	out = append(out, v)
+	keys, _ := childKeys(v)
+	for _, k := range keys {
+		x, _ := get(v, k)
+		out = descStep{}.apply(x, out)
+	}
+	return out
+}
+
+type hasStep struct{ k interface{} }
+
+func (s hasStep) apply(v interface{}, out []interface{}) []interface{} {
+	if _, ok := get(v, s.k); ok && isDiffMap(v) {
+		out = append(out, v)
+	}
+	return out
+}
+
+type eqStep struct{ k, x interface{} }
+
+func (s eqStep) apply(v interface{}, out []interface{}) []interface{} {
+	if x, ok := get(v, s.k); ok && isDiffMap(v) && Equal(x, s.x) {
+		out = append(out, v)
+	}
+	return out
+}
+
+func parseQuery(path string) ([]queryStep, error) {
+	src := []byte(path)
+	var steps []queryStep
+	for off := 0; ; {
+		tok, next, err := nextToken(src, off)
+		if err == io.EOF {
+			return steps, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		off = next
+		switch {
+		case tok.kind == tokAtom && string(tok.text) == "*":
+			steps = append(steps, wildStep{})
+		case tok.kind == tokAtom && string(tok.text) == "**":
+			steps = append(steps, descStep{})
+		case tok.kind == tokAtom:
+			k, err := parseAtom(tok)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, keyStep{k})
+		case tok.kind == tokOpen && string(tok.text) == "(":
+			var s queryStep
+			if s, off, err = parsePredicate(src, off, tok); err != nil {
+				return nil, err
+			}
+			steps = append(steps, s)
+		default:
+			return nil, &SyntaxError{fmt.Sprintf("unexpected %s in query", tok.text), int64(tok.off)}
+		}
+	}
+}
+
+// parsePredicate parses the rest of the predicate step opened by open,
+// from src[off], and returns the offset just past it.
+func parsePredicate(src []byte, off int, open token) (queryStep, int, error) {
+	var args []interface{}
+	for {
+		tok, next, err := nextToken(src, off)
+		if err == io.EOF {
+			return nil, next, &SyntaxError{"unclosed ( in query", int64(next)}
+		}
+		if err != nil {
+			return nil, next, err
+		}
+		off = next
+		if tok.kind == tokClose && tok.text[0] == ')' {
+			break
+		}
+		if tok.kind != tokAtom {
+			return nil, off, &SyntaxError{fmt.Sprintf("unexpected %s in query predicate", tok.text), int64(tok.off)}
+		}
+		x, err := parseAtom(tok)
+		if err != nil {
+			return nil, off, err
+		}
+		args = append(args, x)
+	}
+	switch {
+	case len(args) == 2 && args[0] == Symbol("has"):
+		return hasStep{args[1]}, off, nil
+	case len(args) == 3 && args[0] == Symbol("="):
+		return eqStep{args[1], args[2]}, off, nil
+	}
+	return nil, off, &SyntaxError{"query predicate must be (has k) or (= k x)", int64(open.off)}
+}
+
+// parseAtom returns the value of an atom in a query: nil, a boolean, an
+// integer, a float, a string, a character, a keyword or a symbol.
+func parseAtom(tok token) (interface{}, error) {
+	s := string(tok.text)
+	if s[0] == ':' {
+		return ParseKeyword(s)
+	}
+	if numberLike(s) {
+		if i, err := strconv.Atoi(strings.TrimPrefix(s, "+")); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f, nil
+		}
+	} else if x, ok := atomValue(s); ok {
+		return x, nil
+	}
+	return nil, &SyntaxError{fmt.Sprintf("invalid %s in query", s), int64(tok.off)}
+}
+
+// atomValue returns the value of an atom that is not a number: nil, a
+// boolean, a string, a character, a keyword or a symbol, and whether s
+// is one.
+func atomValue(s string) (interface{}, bool) {
+	switch {
+	case s == "nil":
+		return nil, true
+	case s == "true" || s == "false":
+		return s == "true", true
+	case s[0] == ':':
+		k, err := ParseKeyword(s)
+		return k, err == nil
+	case s[0] == '"':
+		// EDN string escapes are a subset of Go's, but EDN strings may
+		// span lines.
+		s = strings.NewReplacer("\n", `\n`, "\r", `\r`).Replace(s)
+		x, err := strconv.Unquote(s)
+		return x, err == nil
+	case s[0] == '\\':
+		for c, name := range charNames {
+			if name == s {
+				return Char(c), true
+			}
+		}
+		if r := []rune(s[1:]); len(r) == 1 {
+			return Char(r[0]), true
+		}
+		if len(s) == 6 && s[1] == 'u' {
+			if r, err := strconv.ParseUint(s[2:], 16, 16); err == nil {
+				return Char(r), true
+			}
+		}
+	case validSymbol(s):
+		return Symbol(s), true
+	}
+	return nil, false
+}
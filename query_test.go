@@ -0,0 +1,88 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type QueryTests struct{}
+
+func init() { check.Suite(&QueryTests{}) }
+
+var queryDoc = KMap{
+	"orders": Vec{
+		KMap{"id": 1, "status": K("open"), "buyer": KMap{"email": "a@x"}},
+		KMap{"id": 2, "status": K("shipped")},
+		KMap{"id": 3, "status": K("open"), "note": "gift"},
+	},
+	"admin": map[interface{}]interface{}{
+		"email":  "root@x",
+		S("sym"): NewSet(1, 2),
+		7:        Char('a'),
+	},
+}
+
+func (*QueryTests) TestQuery(c *check.C) {
+	for _, t := range []struct {
+		path string
+		want []interface{}
+	}{
+		{``, []interface{}{queryDoc}},
+		{`:orders 1 :id`, []interface{}{2}},
+		{`:orders * :id`, []interface{}{1, 2, 3}},
+		{`:orders * (= :status :open) :id`, []interface{}{1, 3}},
+		{`:orders * (has :note) :id`, []interface{}{3}},
+		{`:orders * :buyer :email`, []interface{}{"a@x"}},
+		{`** (has "email") "email"`, []interface{}{"root@x", "a@x"}},
+		{`:admin sym *`, []interface{}{1, 2}},
+		{`:admin 7`, []interface{}{Char('a')}},
+		{`:admin * (= :x nil)`, nil},
+		{`:missing *`, nil},
+		{`:orders 9`, nil},
+	} {
+		got, err := Query(queryDoc, t.path)
+		c.Check(err, check.IsNil, check.Commentf("%s", t.path))
+		c.Check(got, check.DeepEquals, t.want, check.Commentf("%s", t.path))
+	}
+
+	got, err := Query(queryDoc, `** (has :email) :email`)
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.DeepEquals, []interface{}{"a@x"})
+	got, err = Query(queryDoc, `**`)
+	c.Assert(err, check.IsNil)
+	c.Check(got, check.HasLen, 20)
+}
+
+func (*QueryTests) TestParseAtoms(c *check.C) {
+	v := map[interface{}]interface{}{
+		nil: 0, true: 1, "a\tb": 2, Char('\n'): 3, Char('é'): 4, -1.5: 5, K("ns/k"): 6, S("a.b/c"): 7,
+	}
+	got, err := Query(v, `nil`)
+	c.Check(err, check.IsNil)
+	c.Check(got, check.DeepEquals, []interface{}{0})
+	for path, want := range map[string]int{
+		`true`: 1, `"a\tb"`: 2, `\newline`: 3, `\u00e9`: 4, `\é`: 4, `-1.5`: 5, `:ns/k`: 6, `a.b/c`: 7,
+	} {
+		got, err := Query(v, path)
+		c.Check(err, check.IsNil, check.Commentf("%s", path))
+		c.Check(got, check.DeepEquals, []interface{}{want}, check.Commentf("%s", path))
+	}
+}
+
+func (*QueryTests) TestQueryErrors(c *check.C) {
+	for _, t := range []struct {
+		path, err string
+	}{
+		{`:a [0]`, `edn: unexpected \[ in query`},
+		{`(has :a`, `edn: unclosed \( in query`},
+		{`(has [:a])`, `edn: unexpected \[ in query predicate`},
+		{`(first :a)`, `edn: query predicate must be \(has k\) or \(= k x\)`},
+		{`:a :`, `edn: invalid keyword ":"`},
+		{`"open`, `edn: unterminated string`},
+	} {
+		_, err := Query(nil, t.path)
+		c.Check(err, check.ErrorMatches, t.err, check.Commentf("%s", t.path))
+	}
+}
@@ -0,0 +1,135 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"fmt"
+	"io"
+)
+
+// A SyntaxError is a description of an EDN syntax error.
+type SyntaxError struct {
+	msg    string // description of error
+	Offset int64  // error occurred after reading Offset bytes
+}
+
+func (e *SyntaxError) Error() string { return "edn: " + e.msg }
+
+// A tokenKind classifies the tokens of EDN text.
+type tokenKind int
+
+const (
+	tokAtom  tokenKind = iota // string, character, number, keyword, symbol, ##Inf...
+	tokOpen                   // ( [ { #{ or #:ns{
+	tokClose                  // ) ] or }
+	tokTag                    // #tag, or the discard marker #_
+	tokMeta                   // ^
+)
+
+// A token is a lexical element of EDN text.
+type token struct {
+	kind tokenKind
+	text []byte
+	off  int // offset of text in the input
+}
+
+// isDelimiter reports whether c ends an atom.
+func isDelimiter(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\f', ',', '(', ')', '[', ']', '{', '}', '"', ';':
+		return true
+	}
+	return false
+}
+
+// nextToken returns the first token at or after src[off], skipping
+// whitespace, commas and comments, and the offset just past it. It
+// returns io.EOF if there are no more tokens.
+func nextToken(src []byte, off int) (token, int, error) {
+	for off < len(src) {
+		switch c := src[off]; {
+		case c == ';':
+			for off < len(src) && src[off] != '\n' {
+				off++
+			}
+		case c == ',' || c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			off++
+		default:
+			return scanToken(src, off)
+		}
+	}
+	return token{}, off, io.EOF
+}
+
+func scanToken(src []byte, start int) (token, int, error) {
+	tok := func(kind tokenKind, end int) (token, int, error) {
+		return token{kind, src[start:end], start}, end, nil
+	}
+	atomEnd := func(i int) int {
+		for i < len(src) && !isDelimiter(src[i]) {
+			i++
+		}
+		return i
+	}
+	switch c := src[start]; c {
+	case '(', '[', '{':
+		return tok(tokOpen, start+1)
+	case ')', ']', '}':
+		return tok(tokClose, start+1)
+	case '^':
+		return tok(tokMeta, start+1)
+	case '"':
+		for i := start + 1; i < len(src); i++ {
+			switch src[i] {
+			case '\\':
+				i++
+			case '"':
+				return tok(tokAtom, i+1)
+			}
+		}
+		return token{}, len(src), &SyntaxError{"unterminated string", int64(len(src))}
+	case '\\':
+		// The character right after the backslash belongs to the
+		// literal even if it is a delimiter, as in \( or \space.
+		if start+1 == len(src) {
+			return token{}, len(src), &SyntaxError{"unterminated character literal", int64(len(src))}
+		}
+		return tok(tokAtom, atomEnd(start+2))
+	case '#':
+		if start+1 == len(src) {
+			return token{}, len(src), &SyntaxError{"unexpected end of input after #", int64(len(src))}
+		}
+		switch src[start+1] {
+		case '{':
+			return tok(tokOpen, start+2)
+		case '_':
+			return tok(tokTag, start+2)
+		case '#':
+			return tok(tokAtom, atomEnd(start+2))
+		case ':':
+			end := atomEnd(start + 2)
+			if end == len(src) || src[end] != '{' {
+				return token{}, end, &SyntaxError{"namespaced map prefix not followed by {", int64(end)}
+			}
+			return tok(tokOpen, end+1)
+		}
+		end := atomEnd(start + 1)
+		if end == start+1 {
+			return token{}, end, &SyntaxError{fmt.Sprintf("invalid character %q after #", src[end]), int64(end)}
+		}
+		return tok(tokTag, end)
+	}
+	return tok(tokAtom, atomEnd(start+1))
+}
+
+// closerFor returns the closing delimiter matching the opening one.
+func closerFor(open []byte) byte {
+	switch open[len(open)-1] {
+	case '(':
+		return ')'
+	case '[':
+		return ']'
+	}
+	return '}'
+}
@@ -0,0 +1,253 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"reflect"
+	"strings"
+)
+
+// A Schema describes the EDN values a service accepts, such as the maps
+// of a request, for Validate to check. Schemas are built from Pred,
+// Keys, CollOf, EnumOf, Maybe and And, and the predicates IsString,
+// IsInt and so on:
+//
+//	var order = edn.Keys(
+//		edn.Req(edn.K("id"), edn.IsInt),
+//		edn.Req(edn.K("status"), edn.EnumOf(edn.K("open"), edn.K("shipped"))),
+//		edn.Opt(edn.K("items"), edn.CollOf(edn.Keys(
+//			edn.Req(edn.K("sku"), edn.IsString),
+//		))),
+//	)
+//
+// Values are classified as Equal does: any Go integer type is an
+// integer, a KMap is a map with keyword keys, and pointers and Meta
+// values are looked through.
+type Schema interface {
+	// check appends to errs the ways v, found at path, fails the schema.
+	check(v reflect.Value, path []interface{}, errs ValidationErrors) ValidationErrors
+}
+
+// A ValidationError describes how a value fails a Schema.
+type ValidationError struct {
+	Path []interface{} // where the value is, as in GetIn
+	Msg  string        // what is wrong with it, such as "is required"
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Path) == 0 {
+		return "edn: value " + e.Msg
+	}
+	return "edn: " + docKey(e.Path) + " " + e.Msg
+}
+
+// ValidationErrors are all the ways a value fails a Schema, in the
+// order they were found.
+type ValidationErrors []*ValidationError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, len(errs))
+	for i, e := range errs {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Validate checks v against s. It returns nil if v conforms, and
+// otherwise the ValidationErrors found, with the path to each bad
+// value. Validate reports every error rather than stopping at the
+// first, so that a rejected request can be told all of its problems.
+func Validate(s Schema, v interface{}) error {
+	if errs := s.check(reflect.ValueOf(v), nil, nil); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func fail(errs ValidationErrors, path []interface{}, msg string) ValidationErrors {
+	return append(errs, &ValidationError{appendPath(path), msg})
+}
+
+type predSchema struct {
+	name string
+	f    func(interface{}) bool
+}
+
+// Pred returns a Schema accepting the values for which f returns true.
+// The name describes them in errors, which read "must be " + name.
+func Pred(name string, f func(v interface{}) bool) Schema {
+	return &predSchema{name, f}
+}
+
+func (s *predSchema) check(v reflect.Value, path []interface{}, errs ValidationErrors) ValidationErrors {
+	var x interface{}
+	if v = ednValue(v); v.IsValid() && v.CanInterface() {
+		x = v.Interface()
+	}
+	if !s.f(x) {
+		errs = fail(errs, path, "must be "+s.name)
+	}
+	return errs
+}
+
+// classPred returns a Pred accepting non-nil values of the classes cs.
+func classPred(name string, cs ...valueClass) Schema {
+	return Pred(name, func(x interface{}) bool {
+		v := reflect.ValueOf(x)
+		if !v.IsValid() {
+			return false
+		}
+		c := classOf(v)
+		for _, want := range cs {
+			if c == want {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Predicates for the kinds of EDN values.
+var (
+	IsBool    = classPred("a boolean", classBool)
+	IsInt     = classPred("an integer", classInt)
+	IsNumber  = classPred("a number", classInt, classFloat, classDecimal)
+	IsString  = classPred("a string", classString)
+	IsKeyword = classPred("a keyword", classKeyword)
+	IsSymbol  = classPred("a symbol", classSymbol)
+	IsInst    = classPred("an instant", classInst)
+	IsAny     = Pred("anything", func(interface{}) bool { return true })
+)
+
+// A Field is an entry of a Keys schema.
+type Field struct {
+	Key      interface{}
+	Schema   Schema
+	Optional bool
+}
+
+// Req returns a Field for the required key k, whose value must conform
+// to s.
+func Req(k interface{}, s Schema) Field {
+	return Field{Key: k, Schema: s}
+}
+
+// Opt returns a Field for the optional key k, whose value must conform
+// to s if it is present.
+func Opt(k interface{}, s Schema) Field {
+	return Field{Key: k, Schema: s, Optional: true}
+}
+
+type keysSchema []Field
+
+// Keys returns a Schema accepting maps that have the required keys of
+// fields, and whose values for the keys of fields conform to their
+// schemas. Keys are matched with Equal, so K("id") names both the
+// keyword key :id and the KMap key "id". Other keys are allowed.
+func Keys(fields ...Field) Schema {
+	return keysSchema(fields)
+}
+
+func (s keysSchema) check(v reflect.Value, path []interface{}, errs ValidationErrors) ValidationErrors {
+	v = ednValue(v)
+	if !v.IsValid() || classOf(v) != classMap {
+		return fail(errs, path, "must be a map")
+	}
+	ents := mapEntries(v)
+	for _, f := range s {
+		k := reflect.ValueOf(f.Key)
+		found := false
+		for _, ent := range ents {
+			if equalValues(ent.k, k) {
+				errs = f.Schema.check(ent.v, appendPath(path, f.Key), errs)
+				found = true
+				break
+			}
+		}
+		if !found && !f.Optional {
+			errs = fail(errs, appendPath(path, f.Key), "is required")
+		}
+	}
+	return errs
+}
+
+type collSchema struct {
+	elem Schema
+}
+
+// CollOf returns a Schema accepting vectors, lists and sets, including
+// Go slices and arrays, whose elements all conform to elem.
+func CollOf(elem Schema) Schema {
+	return &collSchema{elem}
+}
+
+func (s *collSchema) check(v reflect.Value, path []interface{}, errs ValidationErrors) ValidationErrors {
+	v = ednValue(v)
+	if !v.IsValid() {
+		return fail(errs, path, "must be a collection")
+	}
+	switch classOf(v) {
+	case classSeq:
+		for i := 0; i < v.Len(); i++ {
+			errs = s.elem.check(v.Index(i), appendPath(path, i), errs)
+		}
+	case classSet:
+		for _, x := range sortedValues(v.MapKeys()) {
+			errs = s.elem.check(x, appendPath(path, x.Interface()), errs)
+		}
+	default:
+		errs = fail(errs, path, "must be a collection")
+	}
+	return errs
+}
+
+// EnumOf returns a Schema accepting only the keywords kws.
+func EnumOf(kws ...Keyword) Schema {
+	names := make([]string, len(kws))
+	for i, k := range kws {
+		names[i] = ":" + strings.TrimPrefix(string(k), ":")
+	}
+	return Pred("one of "+strings.Join(names, ", "), func(x interface{}) bool {
+		for _, k := range kws {
+			if Equal(x, k) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+type maybeSchema struct {
+	s Schema
+}
+
+// Maybe returns a Schema accepting nil as well as the values s accepts.
+func Maybe(s Schema) Schema {
+	return &maybeSchema{s}
+}
+
+func (s *maybeSchema) check(v reflect.Value, path []interface{}, errs ValidationErrors) ValidationErrors {
+	if !ednValue(v).IsValid() {
+		return errs
+	}
+	return s.s.check(v, path, errs)
+}
+
+type andSchema []Schema
+
+// And returns a Schema accepting the values all of schemas accept. It
+// reports the errors of the first schema a value fails.
+func And(schemas ...Schema) Schema {
+	return andSchema(schemas)
+}
+
+func (s andSchema) check(v reflect.Value, path []interface{}, errs ValidationErrors) ValidationErrors {
+	for _, sub := range s {
+		n := len(errs)
+		if errs = sub.check(v, path, errs); len(errs) > n {
+			break
+		}
+	}
+	return errs
+}
@@ -0,0 +1,71 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type SchemaTests struct{}
+
+func init() { check.Suite(&SchemaTests{}) }
+
+var orderSchema = Keys(
+	Req(K("id"), And(IsInt, Pred("positive", func(x interface{}) bool { return Compare(x, 0) > 0 }))),
+	Req(K("status"), EnumOf(K("open"), K(":shipped"))),
+	Opt(K("note"), Maybe(IsString)),
+	Opt(K("items"), CollOf(Keys(
+		Req(K("sku"), IsString),
+		Req(K("qty"), IsNumber),
+	))),
+	Opt(K("tags"), CollOf(IsKeyword)),
+)
+
+func (*SchemaTests) TestValid(c *check.C) {
+	for _, v := range []interface{}{
+		KMap{"id": 7, "status": K("open")},
+		map[interface{}]interface{}{
+			K("id"):     uint8(1),
+			K("status"): K(":shipped"),
+			K("note"):   nil,
+			K("items"):  Vec{KMap{"sku": "a-1", "qty": 2.5}},
+			K("tags"):   NewSet(K("gift")),
+		},
+		&KMap{"id": int64(3), "status": K("open"), "extra": true, "items": []KMap{}},
+	} {
+		c.Check(Validate(orderSchema, v), check.IsNil, check.Commentf("%v", v))
+	}
+}
+
+func (*SchemaTests) TestInvalid(c *check.C) {
+	err := Validate(orderSchema, KMap{
+		"id":     -1,
+		"note":   7,
+		"items":  List{KMap{"sku": "a-1", "qty": 1}, KMap{"qty": "two"}, 3},
+		"tags":   NewSet(K("x"), "y"),
+		"status": "open",
+	})
+	c.Assert(err, check.FitsTypeOf, ValidationErrors{})
+	errs := err.(ValidationErrors)
+	var msgs []string
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+	c.Check(msgs, check.DeepEquals, []string{
+		"edn: [:id] must be positive",
+		"edn: [:status] must be one of :open, :shipped",
+		"edn: [:note] must be a string",
+		"edn: [:items 1 :sku] is required",
+		"edn: [:items 1 :qty] must be a number",
+		"edn: [:items 2] must be a map",
+		`edn: [:tags "y"] must be a keyword`,
+	})
+	c.Check(errs[3].Path, check.DeepEquals, []interface{}{K("items"), 1, K("sku")})
+
+	c.Check(Validate(orderSchema, Vec{}), check.ErrorMatches, `edn: value must be a map`)
+	c.Check(Validate(CollOf(IsAny), 1), check.ErrorMatches, `edn: value must be a collection`)
+	c.Check(Validate(IsInt, nil), check.ErrorMatches, `edn: value must be an integer`)
+	c.Check(Validate(Keys(Req("a", IsAny), Req("b", IsAny)), map[string]int{}), check.ErrorMatches,
+		"edn: \\[\"a\"\\] is required\nedn: \\[\"b\"\\] is required")
+}
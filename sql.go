@@ -0,0 +1,30 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"database/sql/driver"
+	"reflect"
+)
+
+// SQL wraps a value for storing as EDN text in a database column:
+//
+//	db.Exec("UPDATE jobs SET spec = $1 WHERE id = $2", edn.SQL[Spec]{spec}, id)
+type SQL[T any] struct {
+	V T
+}
+
+// Value implements driver.Valuer. It returns the EDN encoding of s.V as
+// a string, or nil, stored as NULL, if s.V is a nil interface or
+// pointer.
+func (s SQL[T]) Value() (driver.Value, error) {
+	if v := reflect.ValueOf(s.V); !v.IsValid() || v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil, nil
+	}
+	b, err := Marshal(s.V)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,40 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"database/sql/driver"
+	"gopkg.in/check.v1"
+	"math"
+)
+
+type SQLTests struct{}
+
+func init() { check.Suite(&SQLTests{}) }
+
+func (*SQLTests) TestValue(c *check.C) {
+	type spec struct {
+		Name  string `edn:"name"`
+		Tries int    `edn:"tries"`
+	}
+	var _ driver.Valuer = SQL[spec]{}
+
+	v, err := SQL[spec]{spec{"nightly", 3}}.Value()
+	c.Assert(err, check.IsNil)
+	c.Check(v, check.Equals, `{:name "nightly", :tries 3}`)
+
+	v, err = SQL[*spec]{}.Value()
+	c.Assert(err, check.IsNil)
+	c.Check(v, check.IsNil)
+	v, err = SQL[interface{}]{}.Value()
+	c.Assert(err, check.IsNil)
+	c.Check(v, check.IsNil)
+
+	v, err = SQL[Vec]{Vec{1, K("a")}}.Value()
+	c.Assert(err, check.IsNil)
+	c.Check(v, check.Equals, `[1 :a]`)
+
+	_, err = SQL[float64]{math.NaN()}.Value()
+	c.Check(err, check.NotNil)
+}
@@ -4,37 +4,410 @@
 package edn
 
 import (
+	"bufio"
+	"errors"
 	"io"
+	"reflect"
+	"strings"
+	"sync"
 )
 
-var encodeStatePool = make(chan *encodeState, 8)
+var encodeStatePool sync.Pool
+
+// maxPooledSize is the largest buffer capacity kept for reuse, so that
+// encoding one huge value does not pin its memory indefinitely.
+const maxPooledSize = 64 << 10
 
 func newEncodeState() *encodeState {
-	select {
-	case e := <-encodeStatePool:
+	if v := encodeStatePool.Get(); v != nil {
+		e := v.(*encodeState)
 		e.Reset()
 		return e
-	default:
-		return new(encodeState)
 	}
+	return new(encodeState)
 }
 
 func putEncodeState(e *encodeState) {
-	select {
-	case encodeStatePool <- e:
-	default:
+	if e.Cap() > maxPooledSize {
+		return
 	}
+	e.w = nil
+	encodeStatePool.Put(e)
 }
 
 // An Encoder writes EDN objects to an output stream.
 type Encoder struct {
-	w   io.Writer
-	err error
+	w    io.Writer // bw, if it is not nil
+	err  error
+	opts encOpts
+
+	dst io.Writer     // the writer passed to NewEncoder
+	bw  *bufio.Writer // buffers output to dst
+
+	sep         string // written between values
+	trailingSep bool   // write sep after each value, not before
+	started     bool   // whether a value has been written
+	afterLine   bool   // whether the stream ends with a comment line
+	streaming   bool   // write large values out as they are encoded
+
+	colls []openColl // collections begun but not yet ended
+}
+
+// NewEncoder returns a new encoder that writes to w, configured by
+// applying opts in order. Each Encoder carries its own settings, so
+// different parts of a program can encode with different policies:
+//
+//	enc := edn.NewEncoder(w, edn.SortMapKeys(true), edn.MaxDepth(64))
+func NewEncoder(w io.Writer, opts ...EncoderOption) *Encoder {
+	enc := &Encoder{w: w, dst: w, opts: defaultEncOpts, sep: "\n", trailingSep: true}
+	for _, opt := range opts {
+		opt(enc)
+	}
+	return enc
+}
+
+// An EncoderOption configures an Encoder created by NewEncoder. Each
+// option has the same effect as the Encoder method of the same name.
+type EncoderOption func(*Encoder)
+
+// DurationTag is an EncoderOption that calls SetDurationTag.
+func DurationTag(tag string) EncoderOption {
+	return func(enc *Encoder) { enc.SetDurationTag(tag) }
+}
+
+// BytesTag is an EncoderOption that calls SetBytesTag.
+func BytesTag(tag string) EncoderOption {
+	return func(enc *Encoder) { enc.SetBytesTag(tag) }
+}
+
+// ByteVectors is an EncoderOption that calls SetByteVectors.
+func ByteVectors(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetByteVectors(on) }
+}
+
+// NilCollections is an EncoderOption that calls SetNilCollections.
+func NilCollections(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetNilCollections(on) }
+}
+
+// ValidateSymbols is an EncoderOption that calls SetValidateSymbols.
+func ValidateSymbols(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetValidateSymbols(on) }
+}
+
+// Stringers is an EncoderOption that calls SetStringers.
+func Stringers(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetStringers(on) }
+}
+
+// CheckKeys is an EncoderOption that calls SetCheckKeys.
+func CheckKeys(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetCheckKeys(on) }
+}
+
+// Parallelism is an EncoderOption that calls SetParallelism.
+func Parallelism(n int) EncoderOption {
+	return func(enc *Encoder) { enc.SetParallelism(n) }
+}
+
+// ASCIIOnly is an EncoderOption that calls SetASCIIOnly.
+func ASCIIOnly(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetASCIIOnly(on) }
+}
+
+// SpecialFloats is an EncoderOption that calls SetSpecialFloats.
+func SpecialFloats(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetSpecialFloats(on) }
+}
+
+// KeywordizeKeys is an EncoderOption that calls SetKeywordizeKeys.
+func KeywordizeKeys(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetKeywordizeKeys(on) }
+}
+
+// NamespacedMaps is an EncoderOption that calls SetNamespacedMaps.
+func NamespacedMaps(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetNamespacedMaps(on) }
 }
 
-// NewEncoder returns a new encoder that writes to w.
-func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w: w}
+// EncodeErrors is an EncoderOption that calls SetEncodeErrors.
+func EncodeErrors(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetEncodeErrors(on) }
+}
+
+// MaxDepth is an EncoderOption that calls SetMaxDepth.
+func MaxDepth(depth int) EncoderOption {
+	return func(enc *Encoder) { enc.SetMaxDepth(depth) }
+}
+
+// SortMapKeys is an EncoderOption that calls SetSortMapKeys.
+func SortMapKeys(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetSortMapKeys(on) }
+}
+
+// SortSets is an EncoderOption that calls SetSortSets.
+func SortSets(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetSortSets(on) }
+}
+
+// Separator is an EncoderOption that calls SetSeparator.
+func Separator(sep string) EncoderOption {
+	return func(enc *Encoder) { enc.SetSeparator(sep) }
+}
+
+// TrailingSeparator is an EncoderOption that calls SetTrailingSeparator.
+func TrailingSeparator(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetTrailingSeparator(on) }
+}
+
+// BufferSize is an EncoderOption that calls SetBufferSize.
+func BufferSize(size int) EncoderOption {
+	return func(enc *Encoder) { enc.SetBufferSize(size) }
+}
+
+// Streaming is an EncoderOption that calls SetStreaming.
+func Streaming(on bool) EncoderOption {
+	return func(enc *Encoder) { enc.SetStreaming(on) }
+}
+
+// TagWriter is an EncoderOption that calls the Encoder's
+// RegisterTagWriter method, so that the writer is used by that
+// Encoder alone.
+func TagWriter(t reflect.Type, tag string, fn func(v interface{}) (interface{}, error)) EncoderOption {
+	return func(enc *Encoder) { enc.RegisterTagWriter(t, tag, fn) }
+}
+
+// SetSeparator sets the text written between encoded values, a newline
+// unless changed. An empty separator suits embedding a single value in
+// a larger document; note that consecutive values, such as two numbers,
+// may then run together.
+func (enc *Encoder) SetSeparator(sep string) {
+	enc.sep = sep
+}
+
+// SetTrailingSeparator controls whether the separator is written after
+// every value, as it is by default, or only between values, so that the
+// stream does not end with a separator.
+func (enc *Encoder) SetTrailingSeparator(on bool) {
+	enc.trailingSep = on
+}
+
+// SetBufferSize makes the encoder buffer its output in a buffer of
+// size bytes, so that many small values are passed to the underlying
+// writer in few Write calls, each of which may otherwise cost a system
+// call on files and network connections. Buffered output must be
+// written out with Flush or Close. A size of 0, the default, disables
+// buffering, flushing any output already buffered.
+func (enc *Encoder) SetBufferSize(size int) {
+	if enc.bw != nil {
+		enc.Flush()
+	}
+	enc.w, enc.bw = enc.dst, nil
+	if size > 0 {
+		enc.bw = bufio.NewWriterSize(enc.dst, size)
+		enc.w = enc.bw
+	}
+}
+
+// Flush writes any buffered output to the underlying writer. It
+// returns the first error encountered by the encoder, if any.
+func (enc *Encoder) Flush() error {
+	if enc.err != nil {
+		return enc.err
+	}
+	if enc.bw != nil {
+		enc.err = enc.bw.Flush()
+	}
+	return enc.err
+}
+
+// Close flushes any buffered output and then, if the underlying writer
+// is an io.Closer, closes it.
+func (enc *Encoder) Close() error {
+	err := enc.Flush()
+	if c, ok := enc.dst.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// SetCheckKeys controls whether the encoder checks that the keys of
+// each map and the elements of each set have distinct encodings, and
+// fails with a DuplicateKeyError otherwise. Distinct Go values can
+// collide once encoded, as int 1 and uint 1 do in a map[interface{}]
+// bool, or the keys "a" and ":a" of a KMap. The check encodes every key
+// an extra time. Maps and sets written with sorted keys are always
+// checked, since sorting already does that work.
+func (enc *Encoder) SetCheckKeys(on bool) {
+	enc.opts.checkKeys = on
+}
+
+// Reset makes the encoder write to w, keeping its settings but
+// forgetting its sticky error, any collections begun with Begin, and
+// any buffered output not yet flushed. It lets a pool of Encoders be
+// reused across connections instead of allocating one per request.
+func (enc *Encoder) Reset(w io.Writer) {
+	enc.dst, enc.w = w, w
+	if enc.bw != nil {
+		enc.bw.Reset(w)
+		enc.w = enc.bw
+	}
+	enc.err = nil
+	enc.started, enc.afterLine = false, false
+	enc.colls = enc.colls[:0]
+}
+
+// SetStreaming controls whether large values are written to the stream
+// piecemeal, as they are encoded, rather than only once the whole value
+// has been encoded in memory. Streaming bounds the memory Encode needs
+// for values such as long slices and maps, at the cost of leaving a
+// partial value in the stream when encoding fails part way; that error
+// is then returned by every later call, as with write errors.
+func (enc *Encoder) SetStreaming(on bool) {
+	enc.streaming = on
+}
+
+// SetDurationTag sets the tag written before time.Duration values,
+// DefaultDurationTag unless changed. An empty tag makes the encoder
+// write durations as integer nanoseconds.
+func (enc *Encoder) SetDurationTag(tag string) {
+	enc.opts.durationTag = tag
+}
+
+// SetBytesTag sets the tag written before the base64 strings that
+// []byte values are encoded as, DefaultBytesTag unless changed. #base64
+// is not among the tags the EDN specification defines, so readers
+// generally need a handler registered for whichever tag is chosen, such
+// as #bytes; an empty tag makes the encoder write plain base64 strings.
+func (enc *Encoder) SetBytesTag(tag string) {
+	enc.opts.bytesTag = tag
+}
+
+// SetByteVectors controls whether []byte values are written as vectors
+// of integers, so []byte{1, 2, 3} is written as [1 2 3], rather than as
+// tagged base64 strings. Vectors are understood by every reader but are
+// several times larger, so they suit small payloads.
+func (enc *Encoder) SetByteVectors(on bool) {
+	enc.opts.byteVectors = on
+}
+
+// SetNilCollections controls whether nil maps and slices are written as
+// nil instead of as empty collections, for consumers that distinguish a
+// missing collection from an empty one. Nil []byte values are always
+// written as nil, and nil List values as (). The "emitnil" and
+// "emitempty" struct tag options override this for individual fields.
+func (enc *Encoder) SetNilCollections(on bool) {
+	enc.opts.nilCollections = on
+}
+
+// SetValidateSymbols controls whether keywords and symbols are checked
+// against the EDN grammar before they are written, so that values such
+// as Keyword("first name") or Symbol("x y") make Encode return an
+// UnsupportedValueError instead of producing output that reads back
+// as something else, or not at all. This covers map keys written as
+// keywords and the keys of structs as well. It is off by default.
+func (enc *Encoder) SetValidateSymbols(on bool) {
+	enc.opts.validateSymbols = on
+}
+
+// SetStringers controls whether values implementing fmt.Stringer are
+// written as EDN strings of their String method's result. Types that
+// implement Marshaler or encoding.TextMarshaler, or that this package
+// encodes specially, such as time.Duration and the math/big types, keep
+// their usual encoding. This is meant for readable dumps of values
+// holding types that are otherwise encoded poorly, such as structs with
+// only unexported fields, and is off by default.
+func (enc *Encoder) SetStringers(on bool) {
+	enc.opts.stringers = on
+}
+
+// SetParallelism makes the encoder split the elements of slices and
+// arrays with thousands of elements into n chunks, encode the chunks
+// concurrently in n goroutines, and join the results in order. This can
+// shorten encoding of large exports on multi-core machines, but it calls
+// MarshalEDN, MarshalText and tag writers from several goroutines at
+// once, and holds every chunk in memory until all are done. A value of
+// 0 or 1, the default, encodes everything in the calling goroutine.
+func (enc *Encoder) SetParallelism(n int) {
+	enc.opts.parallelism = n
+}
+
+// SetASCIIOnly controls whether non-ASCII characters in strings are
+// written as \uXXXX escapes, so that the output is pure ASCII and
+// survives transports and log pipelines that mangle other bytes.
+// Characters outside the Basic Multilingual Plane are written as
+// surrogate pairs, as Java and Clojure readers expect. Keywords and
+// symbols have no escape syntax and are written unchanged.
+func (enc *Encoder) SetASCIIOnly(on bool) {
+	enc.opts.asciiOnly = on
+}
+
+// SetSpecialFloats controls whether infinite and NaN floating-point
+// values are written as the symbolic values ##Inf, ##-Inf and ##NaN
+// understood by Clojure 1.9 and later. By default such values make
+// Encode return an UnsupportedValueError, since the EDN specification
+// does not define them.
+func (enc *Encoder) SetSpecialFloats(on bool) {
+	enc.opts.specialFloats = on
+}
+
+// SetKeywordizeKeys controls whether the keys of maps of type
+// map[string]T are written as keywords, as they always are for KMap,
+// so map[string]int{"a": 1} is written as {:a 1}. Maps keyed by other
+// types, including interface{}, are not affected.
+func (enc *Encoder) SetKeywordizeKeys(on bool) {
+	enc.opts.keywordizeKeys = on
+}
+
+// SetNamespacedMaps controls whether maps and structs whose keys are all
+// keywords sharing a namespace are written in the compact namespaced
+// map form, so {:person/name "Ann", :person/age 3} is written as
+// #:person{:name "Ann", :age 3}. Clojure 1.9 and later read this form.
+func (enc *Encoder) SetNamespacedMaps(on bool) {
+	enc.opts.namespaceMaps = on
+}
+
+// SetEncodeErrors controls whether values implementing the error
+// interface are written as #error tagged maps, mirroring the shape of
+// Clojure's Throwable->map:
+//
+//	#error {:message "open x: no such file", :type "*fs.PathError",
+//	        :cause #error {:message "no such file", :type "syscall.Errno"}}
+//
+// The :cause entry is present when the error wraps another. Types that
+// implement Marshaler, or have a registered tag writer, keep that
+// encoding. The option is off by default, leaving errors to be encoded
+// like any other value.
+func (enc *Encoder) SetEncodeErrors(on bool) {
+	enc.opts.encodeErrors = on
+}
+
+// SetMaxDepth limits how deeply maps, sets, vectors, lists and structs
+// may nest in an encoded value. Encode returns a *DepthError for values
+// that nest more deeply, such as self-referential structures, instead
+// of recursing until the goroutine stack is exhausted. A depth of 0,
+// the default, means no limit.
+func (enc *Encoder) SetMaxDepth(depth int) {
+	enc.opts.maxDepth = depth
+}
+
+// SetSortMapKeys controls whether map entries are written in a stable
+// order, sorted by the encoded text of their keys, rather than in Go's
+// randomized map iteration order. Sorting costs an extra pass over each
+// map's keys, so it is off by default. The fields of structs are then
+// sorted the same way, so that a struct and the equal map are written
+// alike.
+func (enc *Encoder) SetSortMapKeys(on bool) {
+	enc.opts.sortMapKeys = on
+}
+
+// SetSortSets controls whether set elements are written in a stable
+// order, sorted by their encoded text, rather than in Go's randomized
+// map iteration order.
+func (enc *Encoder) SetSortSets(on bool) {
+	enc.opts.sortSets = on
 }
 
 // Encode writes the EDN encoding of v to the stream.
@@ -46,22 +419,240 @@ func (enc *Encoder) Encode(v interface{}) error {
 		return enc.err
 	}
 	e := newEncodeState()
+	enc.beginValue(e)
+	e.opts = enc.opts
 	err := e.marshal(v)
 	if err != nil {
+		if e.flushed {
+			// Part of the value is already in the stream.
+			enc.started = true
+			enc.err = err
+		}
 		return err
 	}
+	enc.endValue(e)
+	err = enc.write(e)
+	putEncodeState(e)
+	return err
+}
+
+// beginValue prepares e for encoding a value, writing to it what
+// precedes the value in the stream.
+func (enc *Encoder) beginValue(e *encodeState) {
+	e.w, e.flushed = nil, false
+	if enc.streaming {
+		e.w = enc.w
+	}
+	switch {
+	case enc.afterLine:
+	case len(enc.colls) > 0:
+		top := enc.colls[len(enc.colls)-1]
+		switch {
+		case top.n == 0:
+		case top.isMap && top.n%2 == 0:
+			e.WriteString(", ")
+		default:
+			e.WriteByte(' ')
+		}
+	case !enc.trailingSep && enc.started:
+		e.WriteString(enc.sep)
+	}
+	enc.afterLine = false
+}
+
+// endValue writes to e what follows a value in the stream.
+//
+// By default each value is terminated with a newline.
+// This makes the output look a little nicer
+// when debugging, and some kind of space
+// is required if the encoded value was a number,
+// so that the reader knows there aren't more
+// digits coming.
+func (enc *Encoder) endValue(e *encodeState) {
+	if len(enc.colls) > 0 {
+		enc.colls[len(enc.colls)-1].n++
+		return
+	}
+	if enc.trailingSep {
+		e.WriteString(enc.sep)
+	}
+}
+
+// An openColl is a collection started with one of the Encoder's Begin
+// methods.
+type openColl struct {
+	close byte
+	isMap bool
+	n     int // number of elements written
+}
+
+// BeginMap starts writing a map whose entries are written one at a time,
+// so that a map too large to hold in memory can still be encoded. Until
+// the matching call to End, each value passed to Encode, or collection
+// written with the Begin methods, is an element of the map, alternately
+// a key and its value:
+//
+//	enc.BeginMap()
+//	for rows.Next() {
+//		enc.Encode(row.ID)
+//		enc.Encode(row)
+//	}
+//	enc.End()
+//
+// Elements are separated by spaces, and map entries by commas, rather
+// than by the Encoder's separator, which is only written after the
+// whole map.
+func (enc *Encoder) BeginMap() error {
+	return enc.begin("{", '}', true)
+}
+
+// BeginVector is like BeginMap, but starts a vector.
+func (enc *Encoder) BeginVector() error {
+	return enc.begin("[", ']', false)
+}
+
+// BeginList is like BeginMap, but starts a list.
+func (enc *Encoder) BeginList() error {
+	return enc.begin("(", ')', false)
+}
+
+// BeginSet is like BeginMap, but starts a set. The Encoder does not
+// check that its elements are distinct.
+func (enc *Encoder) BeginSet() error {
+	return enc.begin("#{", '}', false)
+}
+
+func (enc *Encoder) begin(open string, close byte, isMap bool) error {
+	if enc.err != nil {
+		return enc.err
+	}
+	e := newEncodeState()
+	enc.beginValue(e)
+	e.WriteString(open)
+	err := enc.write(e)
+	putEncodeState(e)
+	if err == nil {
+		enc.colls = append(enc.colls, openColl{close: close, isMap: isMap})
+	}
+	return err
+}
 
-	// Terminate each value with a newline.
-	// This makes the output look a little nicer
-	// when debugging, and some kind of space
-	// is required if the encoded value was a number,
-	// so that the reader knows there aren't more
-	// digits coming.
-	e.WriteByte('\n')
+// End closes the collection started by the last call to BeginMap,
+// BeginVector, BeginList or BeginSet that has not been ended yet.
+func (enc *Encoder) End() error {
+	if enc.err != nil {
+		return enc.err
+	}
+	if len(enc.colls) == 0 {
+		return errors.New("edn: End without a matching Begin")
+	}
+	top := enc.colls[len(enc.colls)-1]
+	if top.isMap && top.n%2 != 0 {
+		return errors.New("edn: End of a map with a key but no value")
+	}
+	enc.colls = enc.colls[:len(enc.colls)-1]
+	e := newEncodeState()
+	e.WriteByte(top.close)
+	enc.endValue(e)
+	err := enc.write(e)
+	putEncodeState(e)
+	return err
+}
 
-	if _, err = enc.w.Write(e.Bytes()); err != nil {
+// write writes the contents of e to the stream, recording any error.
+func (enc *Encoder) write(e *encodeState) error {
+	if _, err := enc.w.Write(e.Bytes()); err != nil {
 		enc.err = err
+		return err
+	}
+	enc.started = true
+	return nil
+}
+
+// Comment writes text to the stream as a comment, each of its lines
+// prefixed with a semicolon, so that generated files can carry notes
+// for their readers:
+//
+//	enc.Comment("Generated by tool; do not edit.")
+//
+// The comment starts on a new line and is followed by a newline.
+func (enc *Encoder) Comment(text string) error {
+	if enc.err != nil {
+		return enc.err
+	}
+	e := newEncodeState()
+	atLineStart := enc.afterLine || enc.trailingSep && strings.HasSuffix(enc.sep, "\n")
+	if enc.started && !atLineStart {
+		e.WriteByte('\n')
+	}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		e.WriteByte(';')
+		if line != "" {
+			e.WriteByte(' ')
+			e.WriteString(line)
+		}
+		e.WriteByte('\n')
+	}
+	err := enc.write(e)
+	enc.afterLine = true
+	putEncodeState(e)
+	return err
+}
+
+// EncodeChan receives values from the channel ch until it is closed and
+// writes them to the stream as a single EDN list, separated from other
+// values as by Encode.
+// Each value is encoded and written as soon as it is received, so the
+// list can be consumed while the producer is still running.
+//
+// ch must be a channel that can be received from. If a value cannot be
+// encoded, EncodeChan stops receiving and returns the error. If part of
+// the list is already in the stream, the list is left unterminated and
+// the error is returned by every later call as well, as with write
+// errors.
+func (enc *Encoder) EncodeChan(ch interface{}) error {
+	if enc.err != nil {
+		return enc.err
+	}
+	v := reflect.ValueOf(ch)
+	if !v.IsValid() || v.Kind() == reflect.Chan && v.IsNil() {
+		return &UnsupportedValueError{v, "nil channel"}
+	}
+	if v.Kind() != reflect.Chan || v.Type().ChanDir()&reflect.RecvDir == 0 {
+		return &UnsupportedTypeError{v.Type()}
+	}
+
+	e := newEncodeState()
+	enc.beginValue(e)
+	e.WriteByte('(')
+	for first := true; ; first = false {
+		x, ok := v.Recv()
+		if !ok {
+			break
+		}
+		if !first {
+			e.WriteByte(' ')
+		}
+		e.opts = enc.opts
+		if err := e.marshal(x.Interface()); err != nil {
+			if !first || e.flushed {
+				// Part of the list is already in the stream.
+				enc.started = true
+				enc.err = err
+			}
+			putEncodeState(e)
+			return err
+		}
+		if err := enc.write(e); err != nil {
+			putEncodeState(e)
+			return err
+		}
+		e.Reset()
 	}
+	e.WriteByte(')')
+	enc.endValue(e)
+	err := enc.write(e)
 	putEncodeState(e)
 	return err
 }
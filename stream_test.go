@@ -5,15 +5,21 @@ package edn
 
 import (
 	"bytes"
-	. "gopkg.in/check.v1"
+	"errors"
+	"fmt"
+	"gopkg.in/check.v1"
 	"io/ioutil"
+	"math"
+	"os"
+	"slices"
 	str "strings"
 	"testing"
+	"time"
 )
 
 type StreamTests struct{}
 
-func init() { Suite(&StreamTests{}) }
+func init() { check.Suite(&StreamTests{}) }
 
 // Test values for the stream test.
 // One of each EDN kind.
@@ -42,7 +48,7 @@ false
 3.14
 `
 
-func (*StreamTests) TestEncoder(c *C) {
+func (*StreamTests) TestEncoder(c *check.C) {
 	for i := 1; i <= len(streamTest); i++ {
 		var buf bytes.Buffer
 		enc := NewEncoder(&buf)
@@ -52,10 +58,560 @@ func (*StreamTests) TestEncoder(c *C) {
 			}
 		}
 		written := str.TrimRight(buf.String(), "\n")
-		c.Check(str.Split(written, "\n"), DeepEquals, str.Split(streamEncoded, "\n")[0:i])
+		c.Check(str.Split(written, "\n"), check.DeepEquals, str.Split(streamEncoded, "\n")[0:i])
 	}
 }
 
+func (*StreamTests) TestEncoderSeparator(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, Separator(", "))
+	c.Assert(enc.Encode(1), check.IsNil)
+	c.Assert(enc.Encode("a"), check.IsNil)
+	c.Check(buf.String(), check.Equals, `1, "a", `)
+
+	buf.Reset()
+	enc = NewEncoder(&buf, TrailingSeparator(false))
+	c.Assert(enc.Encode(1), check.IsNil)
+	c.Check(buf.String(), check.Equals, "1")
+	c.Check(enc.Encode(math.NaN()), check.NotNil)
+	ch := make(chan int, 2)
+	ch <- 2
+	ch <- 3
+	close(ch)
+	c.Assert(enc.EncodeChan(ch), check.IsNil)
+	enc.SetSeparator(" ")
+	c.Assert(enc.Encode(K("k")), check.IsNil)
+	c.Check(buf.String(), check.Equals, "1\n(2 3) :k")
+}
+
+func (*StreamTests) TestEncoderComment(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	c.Assert(enc.Comment("Generated.\n\nDo not edit."), check.IsNil)
+	c.Assert(enc.Encode(1), check.IsNil)
+	c.Assert(enc.Comment("two"), check.IsNil)
+	c.Assert(enc.Encode(2), check.IsNil)
+	c.Check(buf.String(), check.Equals, "; Generated.\n;\n; Do not edit.\n1\n; two\n2\n")
+
+	buf.Reset()
+	enc = NewEncoder(&buf, Separator(" "), TrailingSeparator(false))
+	c.Assert(enc.Encode(1), check.IsNil)
+	c.Assert(enc.Encode(2), check.IsNil)
+	c.Assert(enc.Comment("three"), check.IsNil)
+	c.Assert(enc.Encode(3), check.IsNil)
+	c.Assert(enc.Encode(4), check.IsNil)
+	c.Check(buf.String(), check.Equals, "1 2\n; three\n3 4")
+}
+
+func (*StreamTests) TestEncoderBeginEnd(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	c.Assert(enc.BeginMap(), check.IsNil)
+	c.Assert(enc.Encode(K("a")), check.IsNil)
+	c.Assert(enc.BeginVector(), check.IsNil)
+	c.Assert(enc.Encode(1), check.IsNil)
+	c.Check(enc.Encode(math.NaN()), check.NotNil)
+	c.Assert(enc.BeginSet(), check.IsNil)
+	c.Assert(enc.End(), check.IsNil)
+	c.Assert(enc.BeginList(), check.IsNil)
+	c.Assert(enc.Encode("x"), check.IsNil)
+	c.Assert(enc.End(), check.IsNil)
+	c.Assert(enc.End(), check.IsNil)
+	c.Assert(enc.Encode(K("b")), check.IsNil)
+	c.Check(enc.End(), check.ErrorMatches, "edn: End of a map with a key but no value")
+	c.Assert(enc.Encode(map[string]int{"c": 2}), check.IsNil)
+	c.Assert(enc.End(), check.IsNil)
+	c.Check(enc.End(), check.ErrorMatches, "edn: End without a matching Begin")
+	c.Assert(enc.Encode(3), check.IsNil)
+	c.Check(buf.String(), check.Equals, "{:a [1 #{} (\"x\")], :b {\"c\" 2}}\n3\n")
+}
+
+func (*StreamTests) TestEncoderDurationTag(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetDurationTag("time/duration")
+	c.Assert(enc.Encode(2*time.Second), check.IsNil)
+	enc.SetDurationTag("")
+	c.Assert(enc.Encode(2*time.Second), check.IsNil)
+	c.Check(buf.String(), check.Equals, "#time/duration \"2s\"\n2000000000\n")
+}
+
+func (*StreamTests) TestNewEncoderOptions(c *check.C) {
+	var a, b bytes.Buffer
+	encA := NewEncoder(&a, SortMapKeys(true), KeywordizeKeys(true), DurationTag(""))
+	encB := NewEncoder(&b, SortMapKeys(true), MaxDepth(1))
+	v := []interface{}{map[string]int{"b": 1, "a": 2}, time.Second}
+	c.Assert(encA.Encode(v), check.IsNil)
+	c.Check(a.String(), check.Equals, "[{:a 2, :b 1} 1000000000]\n")
+	c.Check(encB.Encode(v), check.ErrorMatches, "edn: exceeded max depth of 1")
+	c.Assert(encB.Encode(map[string]int{"b": 1, "a": 2}), check.IsNil)
+	c.Check(b.String(), check.Equals, `{"a" 2, "b" 1}`+"\n")
+}
+
+func (*StreamTests) TestEncoderBytesTag(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, BytesTag("bytes"))
+	c.Assert(enc.Encode([]byte("hi")), check.IsNil)
+	enc.SetBytesTag("")
+	c.Assert(enc.Encode([]byte("hi")), check.IsNil)
+	c.Check(buf.String(), check.Equals, "#bytes \"aGk=\"\n\"aGk=\"\n")
+}
+
+func (*StreamTests) TestEncoderByteVectors(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, ByteVectors(true))
+	c.Assert(enc.Encode([][]byte{{1, 2, 255}, {}, nil}), check.IsNil)
+	c.Check(buf.String(), check.Equals, "[[1 2 255] [] nil]\n")
+}
+
+type nilColls struct {
+	M  map[string]int
+	S  []int
+	Ks Set    `edn:",emitnil"`
+	E  []bool `edn:",emitempty"`
+	B  []byte `edn:",emitempty"`
+}
+
+func (*StreamTests) TestEncoderNilCollections(c *check.C) {
+	var buf bytes.Buffer
+	v := []interface{}{nilColls{}, List(nil), []interface{}(nil), map[string]interface{}(nil), Set(nil)}
+	c.Assert(NewEncoder(&buf).Encode(v), check.IsNil)
+	c.Assert(NewEncoder(&buf, NilCollections(true)).Encode(v), check.IsNil)
+	c.Check(buf.String(), check.Equals,
+		`[{:m {}, :s [], :ks nil, :e [], :b #base64 ""} () [] {} #{}]`+"\n"+
+			`[{:m nil, :s nil, :ks nil, :e [], :b #base64 ""} () nil nil nil]`+"\n")
+}
+
+func (*StreamTests) TestEncoderValidateSymbols(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, ValidateSymbols(true))
+	c.Assert(enc.Encode([]interface{}{K("a/b"), S("c"), KMap{"d": 1}, Point{}}), check.IsNil)
+	c.Check(buf.String(), check.Equals, "[:a/b c {:d 1} {:x 0, :y 0}]\n")
+	for _, v := range []interface{}{
+		K("a b"), []interface{}{S("nil")}, KMap{"first name": 1},
+		struct {
+			F int `edn:"f g"`
+		}{},
+	} {
+		c.Check(enc.Encode(v), check.ErrorMatches, `edn: unsupported value: invalid (keyword|symbol) .*`)
+	}
+	c.Check(NewEncoder(&buf).Encode(K("a b")), check.IsNil)
+
+	buf.Reset()
+	enc = NewEncoder(&buf, ValidateSymbols(true), KeywordizeKeys(true))
+	for _, v := range []interface{}{map[string]interface{}{"a b": 1}, map[string]int{"a b": 1}} {
+		c.Check(enc.Encode(v), check.ErrorMatches, `edn: unsupported value: invalid keyword .*`)
+	}
+	c.Check(buf.String(), check.Equals, "")
+	c.Assert(enc.Encode(map[string]interface{}{"a": 1}), check.IsNil)
+	c.Check(buf.String(), check.Equals, "{:a 1}\n")
+}
+
+type opaque struct{ id int }
+
+func (o opaque) String() string { return fmt.Sprintf("opaque-%d", o.id) }
+
+type level int
+
+func (l *level) String() string { return "lvl" }
+
+func (*StreamTests) TestEncoderStringers(c *check.C) {
+	var buf bytes.Buffer
+	var nilLevel *level
+	lvl := level(3)
+	v := []interface{}{opaque{1}, &opaque{2}, &lvl, nilLevel, level(4), time.Second, UUID{}}
+	c.Assert(NewEncoder(&buf).Encode(v), check.IsNil)
+	c.Assert(NewEncoder(&buf, Stringers(true)).Encode(v), check.IsNil)
+	c.Check(buf.String(), check.Equals,
+		`[{} {} 3 nil 4 #go/duration "1s" #uuid "00000000-0000-0000-0000-000000000000"]`+"\n"+
+			`["opaque-1" "opaque-2" "lvl" nil 4 #go/duration "1s" #uuid "00000000-0000-0000-0000-000000000000"]`+"\n")
+}
+
+func (*StreamTests) TestEncoderSortMapKeys(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetSortMapKeys(true)
+	v := map[interface{}]interface{}{
+		"b": 1, "a": 2, K("z"): KMap{"y": 1, "x": 2, "w": 3},
+		10: nil, 9: nil, S("sym"): Set{}.Add(1),
+	}
+	for i := 0; i < 5; i++ {
+		c.Assert(enc.Encode(v), check.IsNil)
+		c.Check(buf.String(), check.Equals, `{"a" 2, "b" 1, 10 nil, 9 nil, :z {:w 3, :x 2, :y 1}, sym #{1}}`+"\n")
+		buf.Reset()
+	}
+}
+
+func (*StreamTests) TestEncoderSortSets(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetSortSets(true)
+	v := []interface{}{
+		Set{}.Add(3, 1, 2, K("b"), K("a"), "s", nil),
+		map[string]Set{"k": Set{}.Add("z", "y")},
+	}
+	for i := 0; i < 5; i++ {
+		c.Assert(enc.Encode(v), check.IsNil)
+		c.Check(buf.String(), check.Equals, `[#{"s" 1 2 3 :a :b nil} {"k" #{"y" "z"}}]`+"\n")
+		buf.Reset()
+	}
+}
+
+func (*StreamTests) TestEncoderMaxDepth(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetMaxDepth(3)
+	c.Check(enc.Encode([]interface{}{KMap{"a": Set{}.Add(1)}}), check.IsNil)
+	c.Check(enc.Encode([]interface{}{1, []interface{}{2, map[int]int{}}}), check.IsNil)
+	err := enc.Encode([][][][]int{{{{1}}}})
+	c.Check(err, check.DeepEquals, &DepthError{3})
+	c.Check(err, check.ErrorMatches, "edn: exceeded max depth of 3")
+
+	// A self-referential value fails instead of overflowing the stack.
+	cyclic := []interface{}{nil}
+	cyclic[0] = cyclic
+	enc.SetMaxDepth(1000)
+	c.Check(enc.Encode(cyclic), check.DeepEquals, &DepthError{1000})
+
+	// A failed encoding leaves nothing behind for the next one.
+	buf.Reset()
+	enc.SetMaxDepth(1)
+	c.Check(enc.Encode([]int{1}), check.IsNil)
+	c.Check(buf.String(), check.Equals, "[1]\n")
+}
+
+func (*StreamTests) TestEncoderASCIIOnly(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetASCIIOnly(true)
+	c.Assert(enc.Encode([]interface{}{"ß\"\n", "€ 😀", "bad \xff", coolnessText("ы")}), check.IsNil)
+	c.Check(buf.String(), check.Equals, `["\u00df\"\n" "\u20ac \ud83d\ude00" "bad \ufffd" "\u044b"]`+"\n")
+}
+
+type coolnessText string
+
+func (t coolnessText) MarshalText() ([]byte, error) {
+	return []byte(t), nil
+}
+
+func (*StreamTests) TestEncoderSpecialFloats(c *check.C) {
+	v := []interface{}{math.Inf(1), float32(math.Inf(-1)), math.NaN(), 1.5}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	_, ok := enc.Encode(v).(*UnsupportedValueError)
+	c.Check(ok, check.Equals, true)
+	enc.SetSpecialFloats(true)
+	c.Assert(enc.Encode(v), check.IsNil)
+	c.Check(buf.String(), check.Equals, "[##Inf ##-Inf ##NaN 1.5]\n")
+}
+
+type person struct {
+	Name string `edn:"person/name"`
+	Age  int    `edn:"person/age,omitempty"`
+}
+
+func (*StreamTests) TestEncoderNamespacedMaps(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetNamespacedMaps(true)
+	enc.SetSortMapKeys(true)
+	for _, p := range []struct {
+		v    interface{}
+		want string
+	}{
+		{KMap{"person/name": "Ann", ":person/age": 3}, `#:person{:age 3, :name "Ann"}`},
+		{map[Keyword]int{"a/x": 1, "a/y": 2}, `#:a{:x 1, :y 2}`},
+		{map[interface{}]int{K("a/x"): 1, K("a/y/z"): 2}, `#:a{:x 1, :y/z 2}`},
+		{person{"Ann", 0}, `#:person{:name "Ann"}`},
+		// Mixed namespaces, plain keywords and non-keyword keys
+		// keep the ordinary form.
+		{KMap{"a/x": 1, "b/x": 2}, `{:a/x 1, :b/x 2}`},
+		{KMap{"a/x": 1, "y": 2}, `{:a/x 1, :y 2}`},
+		{map[interface{}]int{K("a/x"): 1, "a/y": 2}, `{"a/y" 2, :a/x 1}`},
+		{map[Symbol]int{"a/x": 1}, `{a/x 1}`},
+		{KMap{"/": 1}, `{:/ 1}`},
+		{KMap{}, `{}`},
+		{Point{1, 2}, `{:x 1, :y 2}`},
+	} {
+		buf.Reset()
+		c.Assert(enc.Encode(p.v), check.IsNil)
+		c.Check(buf.String(), check.Equals, p.want+"\n")
+	}
+}
+
+func (*StreamTests) TestEncoderKeywordizeKeys(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetKeywordizeKeys(true)
+	enc.SetSortMapKeys(true)
+	v := map[string]interface{}{
+		"b":     map[string]int{"ns/x": 1},
+		":a":    map[interface{}]int{"s": 1},
+		"other": map[Symbol]int{"sym": 1},
+		"smap":  SMap{"?e": 1},
+	}
+	c.Assert(enc.Encode(v), check.IsNil)
+	c.Check(buf.String(), check.Equals, `{:a {"s" 1}, :b {:ns/x 1}, :other {sym 1}, :smap {?e 1}}`+"\n")
+
+	buf.Reset()
+	enc.SetKeywordizeKeys(false)
+	c.Assert(enc.Encode(map[string]int{"a": 1}), check.IsNil)
+	c.Check(buf.String(), check.Equals, `{"a" 1}`+"\n")
+}
+
+// chunkWriter records each Write call separately.
+type chunkWriter struct {
+	chunks []string
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	w.chunks = append(w.chunks, string(p))
+	return len(p), nil
+}
+
+func (*StreamTests) TestEncoderEncodeChan(c *check.C) {
+	ch := make(chan interface{})
+	go func() {
+		ch <- 1
+		ch <- KMap{"a": Vec{"b"}}
+		ch <- nil
+		close(ch)
+	}()
+	var w chunkWriter
+	enc := NewEncoder(&w)
+	c.Assert(enc.EncodeChan(ch), check.IsNil)
+	c.Assert(enc.Encode(2), check.IsNil)
+	c.Check(w.chunks, check.DeepEquals, []string{"(1", ` {:a ["b"]}`, " nil", ")\n", "2\n"})
+
+	empty := make(chan int)
+	close(empty)
+	var buf bytes.Buffer
+	enc = NewEncoder(&buf)
+	c.Assert(enc.EncodeChan((<-chan int)(empty)), check.IsNil)
+	c.Check(buf.String(), check.Equals, "()\n")
+
+	_, ok := enc.EncodeChan(make(chan<- int)).(*UnsupportedTypeError)
+	c.Check(ok, check.Equals, true)
+	_, ok = enc.EncodeChan([]int{1}).(*UnsupportedTypeError)
+	c.Check(ok, check.Equals, true)
+	var nilCh chan int
+	_, ok = enc.EncodeChan(nilCh).(*UnsupportedValueError)
+	c.Check(ok, check.Equals, true)
+	_, ok = enc.EncodeChan(nil).(*UnsupportedValueError)
+	c.Check(ok, check.Equals, true)
+
+	bad := make(chan interface{}, 2)
+	bad <- 1
+	bad <- make(chan int)
+	close(bad)
+	buf.Reset()
+	err := enc.EncodeChan(bad)
+	c.Check(err, check.FitsTypeOf, &UnsupportedTypeError{})
+	c.Check(buf.String(), check.Equals, "(1")
+	c.Check(enc.Encode(1), check.Equals, err)
+
+	// A failure before anything was written leaves the Encoder usable.
+	bad = make(chan interface{}, 1)
+	bad <- make(chan int)
+	close(bad)
+	buf.Reset()
+	enc = NewEncoder(&buf)
+	c.Check(enc.EncodeChan(bad), check.FitsTypeOf, &UnsupportedTypeError{})
+	c.Assert(enc.Encode(1), check.IsNil)
+	c.Check(buf.String(), check.Equals, "1\n")
+}
+
+type codedError struct {
+	Code int
+}
+
+func (e codedError) Error() string { return fmt.Sprintf("code %d", e.Code) }
+
+type closeRecorder struct {
+	chunkWriter
+	closed bool
+}
+
+func (w *closeRecorder) Close() error {
+	w.closed = true
+	return nil
+}
+
+func (*StreamTests) TestEncoderBufferSize(c *check.C) {
+	var w closeRecorder
+	enc := NewEncoder(&w, BufferSize(64))
+	for i := 0; i < 3; i++ {
+		c.Assert(enc.Encode(i), check.IsNil)
+	}
+	c.Check(w.chunks, check.IsNil)
+	c.Assert(enc.Flush(), check.IsNil)
+	c.Check(w.chunks, check.DeepEquals, []string{"0\n1\n2\n"})
+
+	// Values larger than the buffer are written through.
+	c.Assert(enc.Encode(str.Repeat("x", 100)), check.IsNil)
+	c.Check(len(w.chunks), check.Equals, 2)
+	c.Assert(enc.Encode(K("k")), check.IsNil)
+	c.Assert(enc.Close(), check.IsNil)
+	c.Check(w.chunks[len(w.chunks)-1], check.Equals, ":k\n")
+	c.Check(w.closed, check.Equals, true)
+
+	// Turning buffering off flushes.
+	var buf bytes.Buffer
+	enc = NewEncoder(&buf, BufferSize(64))
+	c.Assert(enc.Encode(1), check.IsNil)
+	enc.SetBufferSize(0)
+	c.Check(buf.String(), check.Equals, "1\n")
+	c.Assert(enc.Encode(2), check.IsNil)
+	c.Check(buf.String(), check.Equals, "1\n2\n")
+	c.Check(enc.Close(), check.IsNil)
+}
+
+func (*StreamTests) TestEncoderCheckKeys(c *check.C) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, CheckKeys(true))
+	c.Assert(enc.Encode(map[interface{}]int{1: 1, 2: 2}), check.IsNil)
+	c.Check(enc.Encode(map[interface{}]bool{1: true, uint(1): false}), check.ErrorMatches,
+		`edn: duplicate map key 1 in map\[interface \{\}\]bool`)
+
+	enc = NewEncoder(&buf, CheckKeys(true))
+	err := enc.Encode(Set{}.Add(Keyword("a"), Keyword(":a")))
+	c.Check(err, check.ErrorMatches, `edn: duplicate set key :a in edn.Set`)
+	c.Check(err.(*DuplicateKeyError).Key, check.Equals, ":a")
+
+	enc = NewEncoder(&buf, CheckKeys(true), KeywordizeKeys(true))
+	c.Check(enc.Encode(map[string]interface{}{"a": 1, ":a": 2}), check.FitsTypeOf, &DuplicateKeyError{})
+
+	// Sorted keys are checked regardless.
+	enc = NewEncoder(&buf, SortMapKeys(true))
+	c.Check(enc.Encode(KMap{"k": 1, ":k": 2}), check.ErrorMatches, "edn: duplicate map key :k in edn.KMap")
+	_, err = MarshalCanonical(map[interface{}]int{int8(3): 1, int64(3): 2})
+	c.Check(err, check.FitsTypeOf, &DuplicateKeyError{})
+}
+
+func (*StreamTests) TestEncoderReset(c *check.C) {
+	var first, second bytes.Buffer
+	enc := NewEncoder(&first, Separator(" "), BufferSize(64))
+	c.Assert(enc.Encode(K("a")), check.IsNil)
+	c.Assert(enc.BeginVector(), check.IsNil)
+	c.Check(enc.Encode(make(chan int)), check.NotNil)
+
+	enc.Reset(&second)
+	c.Assert(enc.Encode(K("b")), check.IsNil)
+	c.Assert(enc.Encode(2), check.IsNil)
+	c.Assert(enc.End(), check.ErrorMatches, "edn: End without a matching Begin")
+
+	enc.Reset(&second)
+	c.Assert(enc.Encode(3), check.IsNil)
+	c.Assert(enc.Flush(), check.IsNil)
+	c.Check(first.String(), check.Equals, "")
+	c.Check(second.String(), check.Equals, "3 ")
+}
+
+func (*StreamTests) TestEncoderParallelism(c *check.C) {
+	v := make([]interface{}, 3*minParallelLen+7)
+	for i := range v {
+		v[i] = []interface{}{i, Keyword(fmt.Sprint("k", i))}
+	}
+	want, err := Marshal([]interface{}{v, [2]int{1, 2}})
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf, Parallelism(4), Streaming(true))
+	c.Assert(enc.Encode([]interface{}{v, [2]int{1, 2}}), check.IsNil)
+	c.Check(buf.String(), check.Equals, string(want)+"\n")
+
+	// The chunks of a []interface{} are encoded concurrently.
+	m := make(meeting)
+	v2 := make([]interface{}, 2*minParallelLen)
+	v2[0], v2[minParallelLen] = m, m
+	buf.Reset()
+	c.Assert(NewEncoder(&buf, Parallelism(2)).Encode(v2), check.IsNil)
+	c.Check(str.Count(buf.String(), "met"), check.Equals, 2)
+
+	// The first failing element, in order, is reported.
+	v[len(v)-1] = math.Inf(1)
+	v[2*minParallelLen] = math.NaN()
+	c.Check(NewEncoder(&buf, Parallelism(4)).Encode(v), check.ErrorMatches, "edn: unsupported value: NaN")
+	v[2*minParallelLen] = []interface{}{[]interface{}{}}
+	c.Check(NewEncoder(&buf, Parallelism(4), MaxDepth(2)).Encode(v), check.ErrorMatches, "edn: exceeded max depth of 2")
+}
+
+// A meeting is written as met if another goroutine writes it at the
+// same time, and as late otherwise.
+type meeting chan bool
+
+func (m meeting) MarshalEDN() ([]byte, error) {
+	select {
+	case m <- true:
+	case <-m:
+	case <-time.After(time.Second):
+		return []byte("late"), nil
+	}
+	return []byte("met"), nil
+}
+
+func (*StreamTests) TestEncoderStreaming(c *check.C) {
+	v := make([]map[string]string, 2000)
+	for i := range v {
+		v[i] = map[string]string{"k": str.Repeat("x", 64)}
+	}
+	want, err := Marshal(v)
+	c.Assert(err, check.IsNil)
+
+	var w chunkWriter
+	enc := NewEncoder(&w, Streaming(true))
+	c.Assert(enc.Encode(v), check.IsNil)
+	c.Check(len(w.chunks) > 2, check.Equals, true)
+	for _, chunk := range w.chunks[:len(w.chunks)-1] {
+		c.Check(len(chunk) >= flushSize, check.Equals, true)
+	}
+	c.Check(str.Join(w.chunks, ""), check.Equals, string(want)+"\n")
+
+	// Iterators are written as they are produced, too.
+	w.chunks = nil
+	c.Assert(enc.Encode(slices.Values(make([]int, 100000))), check.IsNil)
+	c.Check(len(w.chunks) > 2, check.Equals, true)
+	for _, chunk := range w.chunks {
+		c.Check(len(chunk) < 2*flushSize, check.Equals, true)
+	}
+	c.Check(str.Join(w.chunks, ""), check.Equals, "("+str.TrimSuffix(str.Repeat("0 ", 100000), " ")+")\n")
+
+	// A failure after part of the value was written sticks.
+	bad := append([]interface{}{v}, math.NaN())
+	w.chunks = nil
+	err = enc.Encode(bad)
+	c.Check(err, check.NotNil)
+	c.Check(len(w.chunks) > 0, check.Equals, true)
+	c.Check(enc.Encode(1), check.Equals, err)
+
+	// Small values are written in one piece.
+	w.chunks = nil
+	enc = NewEncoder(&w, Streaming(true))
+	c.Check(enc.Encode(math.NaN()), check.NotNil)
+	c.Assert(enc.Encode([]int{1, 2}), check.IsNil)
+	c.Check(w.chunks, check.DeepEquals, []string{"[1 2]\n"})
+}
+
+func (*StreamTests) TestEncoderEncodeErrors(c *check.C) {
+	var nilErr *os.PathError
+	v := []interface{}{
+		errors.New("boom"),
+		fmt.Errorf("wrapped: %w", codedError{7}),
+		struct{ Err error }{},
+		nilErr,
+	}
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	c.Assert(enc.Encode(v), check.IsNil)
+	c.Check(buf.String(), check.Equals, `[{} {} {:err nil} nil]`+"\n")
+
+	buf.Reset()
+	enc.SetEncodeErrors(true)
+	c.Assert(enc.Encode(v), check.IsNil)
+	c.Check(buf.String(), check.Equals, `[#error {:message "boom", :type "*errors.errorString"} `+
+		`#error {:message "wrapped: code 7", :type "*fmt.wrapError", `+
+		`:cause #error {:message "code 7", :type "edn.codedError"}} {:err nil} nil]`+"\n")
+}
+
 func BenchmarkEncoderEncode(b *testing.B) {
 	b.ReportAllocs()
 	type T struct {
@@ -0,0 +1,43 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type TagsTests struct{}
+
+func init() { check.Suite(&TagsTests{}) }
+
+func (*TagsTests) TestTagParsing(c *check.C) {
+	name, opts := parseTag("field,foobar,foo")
+	c.Check(name, check.Equals, "field")
+	for _, tt := range []struct {
+		opt  string
+		want bool
+	}{
+		{"foobar", true},
+		{"foo", true},
+		{"bar", false},
+		{"field", false},
+	} {
+		c.Check(opts.Contains(tt.opt), check.Equals, tt.want, check.Commentf("option %q", tt.opt))
+	}
+	name, opts = parseTag("-")
+	c.Check(name, check.Equals, "-")
+	c.Check(opts.Contains(""), check.Equals, false)
+}
+
+func (*TagsTests) TestTagOptionValues(c *check.C) {
+	_, opts := parseTag("field,omitempty,key=string")
+	v, ok := opts.Get("key")
+	c.Check(v, check.Equals, "string")
+	c.Check(ok, check.Equals, true)
+	_, ok = opts.Get("omitempty")
+	c.Check(ok, check.Equals, false)
+	_, ok = opts.Get("ke")
+	c.Check(ok, check.Equals, false)
+	c.Check(opts.Contains("key"), check.Equals, false)
+}
@@ -0,0 +1,180 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"net"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// A tagWriter writes values of one Go type as a tagged element.
+type tagWriter struct {
+	tag string
+	fn  func(v interface{}) (interface{}, error)
+}
+
+var tagWriters struct {
+	sync.RWMutex
+	m map[reflect.Type]*tagWriter
+}
+
+// fastTypes are the types encodeState.fastValue writes without looking
+// for tag writers, so it must not be used once one has a writer.
+var fastTypes = []reflect.Type{
+	reflect.TypeOf(false),
+	reflect.TypeOf(0),
+	reflect.TypeOf(int64(0)),
+	reflect.TypeOf(0.0),
+	stringType,
+	keywordType,
+	reflect.TypeOf([]interface{}(nil)),
+	reflect.TypeOf(map[string]interface{}(nil)),
+}
+
+// hasFastTypeWriter reports whether writers has a writer for one of
+// fastTypes.
+func hasFastTypeWriter(writers map[reflect.Type]*tagWriter) bool {
+	for _, t := range fastTypes {
+		if writers[t] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// fastTypeWriters is set while a writer is registered globally for one
+// of fastTypes.
+var fastTypeWriters atomic.Bool
+
+// RegisterTagWriter arranges for values of type t to be written as the
+// tagged element #tag followed by the EDN encoding of fn's result. It
+// lets types the caller does not own, such as those from third-party
+// packages, be written as domain tagged literals:
+//
+//	edn.RegisterTagWriter(reflect.TypeOf(geo.Point{}), "geo/point",
+//		func(v interface{}) (interface{}, error) {
+//			p := v.(geo.Point)
+//			return []float64{p.Lat, p.Lng}, nil
+//		})
+//
+// A registered writer takes precedence over every other way of encoding
+// t, including the Marshaler and encoding.TextMarshaler interfaces.
+// If fn returns nil, the value is written as nil, without the tag.
+// Registering a type again replaces its writer; a nil fn removes it.
+// Errors returned by fn are reported as a *MarshalerError.
+//
+// Writers are registered by default for url.URL as #go/url, and for
+// net.IP and netip.Addr as #go/ip, and for regexp.Regexp as #regex, each
+// with the value's string form; these may be replaced or removed like
+// any other.
+//
+// RegisterTagWriter is meant to be called during initialization. It
+// panics if t is nil or tag is empty.
+func RegisterTagWriter(t reflect.Type, tag string, fn func(v interface{}) (interface{}, error)) {
+	tag = strings.TrimPrefix(tag, "#")
+	if t == nil || tag == "" && fn != nil {
+		panic("edn: RegisterTagWriter needs a type and a tag")
+	}
+	tagWriters.Lock()
+	if tagWriters.m == nil {
+		tagWriters.m = make(map[reflect.Type]*tagWriter)
+	}
+	if fn == nil {
+		delete(tagWriters.m, t)
+	} else {
+		tagWriters.m[t] = &tagWriter{tag, fn}
+	}
+	fastTypeWriters.Store(hasFastTypeWriter(tagWriters.m))
+	tagWriters.Unlock()
+
+	// Encoders for t, and for every type containing it, may already be
+	// cached; drop them all so they are rebuilt with the new writer.
+	encoderCache.m.Clear()
+}
+
+// RegisterTagWriter is like the package-level RegisterTagWriter, but
+// the writer is only used by enc, where it takes precedence over any
+// writer registered for t globally. A nil fn makes enc encode t as if
+// no writer were registered for it at all.
+func (enc *Encoder) RegisterTagWriter(t reflect.Type, tag string, fn func(v interface{}) (interface{}, error)) {
+	tag = strings.TrimPrefix(tag, "#")
+	if t == nil || tag == "" && fn != nil {
+		panic("edn: RegisterTagWriter needs a type and a tag")
+	}
+	// Encoders built by the old set may embed the old writer, so start
+	// from an empty cache.
+	c := &encoderSet{writers: make(map[reflect.Type]*tagWriter)}
+	if old := enc.opts.encs; old != nil {
+		for t, w := range old.writers {
+			c.writers[t] = w
+		}
+	}
+	c.writers[t] = &tagWriter{tag, fn}
+	c.fastTypeWriters = hasFastTypeWriter(c.writers)
+	enc.opts.encs = c
+}
+
+// fastPathOK reports whether encodeState.fastValue may be used, that is
+// whether no tag writer applies to any of fastTypes.
+func (c *encoderSet) fastPathOK() bool {
+	return !c.fastTypeWriters && !fastTypeWriters.Load()
+}
+
+func (c *encoderSet) lookupTagWriter(t reflect.Type) (*tagWriter, bool) {
+	if w, ok := c.writers[t]; ok {
+		return w, w.fn != nil
+	}
+	tagWriters.RLock()
+	w, ok := tagWriters.m[t]
+	tagWriters.RUnlock()
+	return w, ok
+}
+
+func (w *tagWriter) encode(e *encodeState, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			e.WriteString("nil")
+			return
+		}
+	}
+	r, err := w.fn(v.Interface())
+	if err != nil {
+		e.error(&MarshalerError{v.Type(), err, "tag writer"})
+	}
+	if r == nil {
+		e.WriteString("nil")
+		return
+	}
+	e.WriteByte('#')
+	e.WriteString(w.tag)
+	e.WriteByte(' ')
+	e.reflectValue(reflect.ValueOf(r))
+}
+
+func init() {
+	RegisterTagWriter(reflect.TypeOf(url.URL{}), "go/url", func(v interface{}) (interface{}, error) {
+		u := v.(url.URL)
+		return u.String(), nil
+	})
+	RegisterTagWriter(reflect.TypeOf(net.IP{}), "go/ip", func(v interface{}) (interface{}, error) {
+		return v.(net.IP).String(), nil
+	})
+	RegisterTagWriter(reflect.TypeOf(netip.Addr{}), "go/ip", func(v interface{}) (interface{}, error) {
+		if a := v.(netip.Addr); a.IsValid() {
+			return a.String(), nil
+		}
+		return nil, nil
+	})
+	RegisterTagWriter(reflect.TypeOf(regexp.Regexp{}), "regex", func(v interface{}) (interface{}, error) {
+		re := v.(regexp.Regexp)
+		return re.String(), nil
+	})
+}
@@ -0,0 +1,117 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"bytes"
+	"errors"
+	"gopkg.in/check.v1"
+	"net"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"regexp"
+)
+
+type TagWriterTests struct{}
+
+func init() { check.Suite(&TagWriterTests{}) }
+
+type geoPoint struct {
+	lat, lng float64
+}
+
+func (*TagWriterTests) TestRegisterTagWriter(c *check.C) {
+	t := reflect.TypeOf(geoPoint{})
+	defer RegisterTagWriter(t, "", nil)
+
+	// Before registration, an unexported-field struct is an empty map.
+	checkMarshal(c, pair{[]geoPoint{{1, 2}}, "[{}]"})
+
+	RegisterTagWriter(t, "geo/point", func(v interface{}) (interface{}, error) {
+		p := v.(geoPoint)
+		if p.lat > 90 {
+			return nil, errors.New("bad latitude")
+		}
+		return []float64{p.lat, p.lng}, nil
+	})
+	var nilPtr *geoPoint
+	checkMarshal(
+		c,
+		pair{geoPoint{1.5, -2}, "#geo/point [1.5 -2]"},
+		pair{&geoPoint{0, 0}, "#geo/point [0 0]"},
+		pair{nilPtr, "nil"},
+		// Previously cached container encoders pick up the writer.
+		pair{[]geoPoint{{1, 2}}, "[#geo/point [1 2]]"},
+	)
+	_, err := Marshal(geoPoint{91, 0})
+	c.Check(err, check.ErrorMatches, "edn: error calling tag writer for type edn.geoPoint: bad latitude")
+
+	// A writer overrides the type's own marshaling methods.
+	RegisterTagWriter(reflect.TypeOf(money{}), "#usd", func(v interface{}) (interface{}, error) {
+		return v.(money).cents, nil
+	})
+	checkMarshal(c, pair{money{5}, "#usd 5"})
+	RegisterTagWriter(reflect.TypeOf(money{}), "", nil)
+	checkMarshal(c, pair{money{5}, "#money/usd 5M"})
+
+	c.Check(func() { RegisterTagWriter(t, "", func(interface{}) (interface{}, error) { return nil, nil }) }, check.PanicMatches, ".*needs a type and a tag")
+}
+
+func (*TagWriterTests) TestEncoderTagWriter(c *check.C) {
+	var buf bytes.Buffer
+	pt := TagWriter(reflect.TypeOf(geoPoint{}), "geo/point", func(v interface{}) (interface{}, error) {
+		return []float64{v.(geoPoint).lat, v.(geoPoint).lng}, nil
+	})
+	noRegex := TagWriter(reflect.TypeOf(regexp.Regexp{}), "", nil)
+	enc := NewEncoder(&buf, pt, noRegex)
+	v := []interface{}{geoPoint{1, 2}, map[string]*geoPoint{"p": {3, 4}}, regexp.MustCompile("a")}
+	c.Assert(enc.Encode(v), check.IsNil)
+	c.Check(buf.String(), check.Equals, `[#geo/point [1 2] {"p" #geo/point [3 4]} "a"]`+"\n")
+
+	// Neither writer leaks into Marshal or other Encoders.
+	checkMarshal(c, pair{v, `[{} {"p" {}} #regex "a"]`})
+	buf.Reset()
+	c.Assert(NewEncoder(&buf).Encode(v), check.IsNil)
+	c.Check(buf.String(), check.Equals, `[{} {"p" {}} #regex "a"]`+"\n")
+}
+
+func (*TagWriterTests) TestBuiltinTagWriters(c *check.C) {
+	u, _ := url.Parse("https://example.com/a?b=c#d")
+	var nilURL *url.URL
+	checkMarshal(
+		c,
+		pair{u, `#go/url "https://example.com/a?b=c#d"`},
+		pair{*u, `#go/url "https://example.com/a?b=c#d"`},
+		pair{nilURL, "nil"},
+		pair{net.IPv4(10, 0, 0, 1), `#go/ip "10.0.0.1"`},
+		pair{net.ParseIP("2001:db8::1"), `#go/ip "2001:db8::1"`},
+		pair{net.IP(nil), "nil"},
+		pair{netip.MustParseAddr("192.168.1.1"), `#go/ip "192.168.1.1"`},
+		pair{netip.Addr{}, "nil"},
+		// *netip.Addr is itself a TextMarshaler, but the writer wins.
+		pair{&netip.Addr{}, "nil"},
+		pair{struct{ Peer netip.Addr }{netip.IPv6Loopback()}, `{:peer #go/ip "::1"}`},
+	)
+
+	// The built-in writers can be overridden.
+	t := reflect.TypeOf(net.IP{})
+	RegisterTagWriter(t, "inet", func(v interface{}) (interface{}, error) {
+		return []byte(v.(net.IP).To4()), nil
+	})
+	defer RegisterTagWriter(t, "go/ip", func(v interface{}) (interface{}, error) {
+		return v.(net.IP).String(), nil
+	})
+	checkMarshal(c, pair{net.IPv4(1, 2, 3, 4), `#inet #base64 "AQIDBA=="`})
+}
+
+func (*TagWriterTests) TestRegexpTagWriter(c *check.C) {
+	var nilRe *regexp.Regexp
+	checkMarshal(
+		c,
+		pair{regexp.MustCompile(`^\d+"x"$`), `#regex "^\\d+\"x\"$"`},
+		pair{nilRe, "nil"},
+		pair{map[string]*regexp.Regexp{"id": regexp.MustCompile("[a-z]+")}, `{"id" #regex "[a-z]+"}`},
+	)
+}
@@ -0,0 +1,38 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// This test is in its own package because it needs a type named UUID,
+// which package edn already declares.
+package edn_test
+
+import (
+	"fmt"
+	"github.com/paxan/go-edn"
+	"gopkg.in/check.v1"
+)
+
+type UUIDMarshalerTests struct{}
+
+func init() { check.Suite(&UUIDMarshalerTests{}) }
+
+// UUID is shaped like the UUID types the encoder writes as #uuid, but
+// says how it is written.
+type UUID [16]byte
+
+func (u UUID) String() string { return fmt.Sprintf("%x", u[:]) }
+
+func (u UUID) MarshalEDN() ([]byte, error) {
+	return []byte(fmt.Sprintf("#my/id %d", u[15])), nil
+}
+
+func (*UUIDMarshalerTests) TestMarshalerBeatsUUIDShape(c *check.C) {
+	id := UUID{15: 7}
+	for _, v := range []interface{}{id, &id, []UUID{id}} {
+		b, err := edn.Marshal(v)
+		c.Assert(err, check.IsNil)
+		c.Check(string(b), check.Matches, `\[?#my/id 7\]?`)
+	}
+	b, err := edn.Marshal(edn.UUID{15: 7})
+	c.Assert(err, check.IsNil)
+	c.Check(string(b), check.Equals, `#uuid "00000000-0000-0000-0000-000000000007"`)
+}
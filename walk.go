@@ -0,0 +1,263 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"strings"
+)
+
+// Walk traverses v one level deep, in the manner of Clojure's
+// clojure.walk/walk. If v is a collection, Walk applies inner to each
+// of its elements, rebuilds a collection of the same type from the
+// results, and returns outer applied to it. Otherwise it returns
+// outer(v).
+//
+// The collections walked are []interface{}, Vec, List, Set, HashMap,
+// OrderedMap, map[interface{}]interface{}, and the elements of Tagged
+// and Meta values. The keys and values of maps are walked separately;
+// the string keys of KMap, SMap and map[string]interface{} are kept as
+// they are, and only their values are walked. Other values, including
+// Go slices and maps of other types, are leaves. Walk panics if inner
+// returns a Set element or map key that cannot be one.
+func Walk(inner, outer func(interface{}) interface{}, v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		if v == nil {
+			break
+		}
+		return outer(walkSlice(inner, v))
+	case Vec:
+		if v == nil {
+			break
+		}
+		return outer(Vec(walkSlice(inner, v)))
+	case List:
+		if v == nil {
+			break
+		}
+		return outer(List(walkSlice(inner, v)))
+	case Set:
+		if v == nil {
+			break
+		}
+		w := make(Set, len(v))
+		for x := range v {
+			w[inner(x)] = struct{}{}
+		}
+		return outer(w)
+	case map[interface{}]interface{}:
+		if v == nil {
+			break
+		}
+		w := make(map[interface{}]interface{}, len(v))
+		for k, x := range v {
+			w[inner(k)] = inner(x)
+		}
+		return outer(w)
+	case KMap:
+		if v == nil {
+			break
+		}
+		return outer(KMap(walkValues(inner, v)))
+	case SMap:
+		if v == nil {
+			break
+		}
+		return outer(SMap(walkValues(inner, v)))
+	case map[string]interface{}:
+		if v == nil {
+			break
+		}
+		return outer(walkValues(inner, v))
+	case HashMap:
+		var w HashMap
+		v.Range(func(k, x interface{}) bool {
+			if err := w.Set(inner(k), inner(x)); err != nil {
+				panic(err)
+			}
+			return true
+		})
+		return outer(w)
+	case *HashMap:
+		w := Walk(inner, identity, *v).(HashMap)
+		return outer(&w)
+	case OrderedMap:
+		var w OrderedMap
+		for _, k := range v.keys {
+			w.Set(inner(k), inner(v.vals[k]))
+		}
+		return outer(w)
+	case *OrderedMap:
+		w := Walk(inner, identity, *v).(OrderedMap)
+		return outer(&w)
+	case Tagged:
+		return outer(Tagged{v.Tag, inner(v.Value)})
+	case Meta:
+		return outer(Meta{inner(v.Value), v.Meta})
+	}
+	return outer(v)
+}
+
+func walkSlice(inner func(interface{}) interface{}, v []interface{}) []interface{} {
+	w := make([]interface{}, len(v))
+	for i, x := range v {
+		w[i] = inner(x)
+	}
+	return w
+}
+
+func walkValues(inner func(interface{}) interface{}, v map[string]interface{}) map[string]interface{} {
+	w := make(map[string]interface{}, len(v))
+	for k, x := range v {
+		w[k] = inner(x)
+	}
+	return w
+}
+
+// Prewalk applies f to v and then walks the result, replacing each
+// element with the result of Prewalk applied to it, as Clojure's
+// prewalk does. It returns the rebuilt value. Since f sees each
+// collection before its elements, it can replace a subtree without the
+// subtree being visited.
+func Prewalk(f func(interface{}) interface{}, v interface{}) interface{} {
+	return Walk(func(x interface{}) interface{} { return Prewalk(f, x) }, identity, f(v))
+}
+
+// Postwalk walks v depth first, applying f to each element after its
+// own elements have been replaced, and returns f applied to the
+// rebuilt v, as Clojure's postwalk does.
+//
+// For example, this turns every string in v into a keyword:
+//
+//	edn.Postwalk(func(x interface{}) interface{} {
+//		if s, ok := x.(string); ok {
+//			return edn.Keyword(s)
+//		}
+//		return x
+//	}, v)
+func Postwalk(f func(interface{}) interface{}, v interface{}) interface{} {
+	return Walk(func(x interface{}) interface{} { return Postwalk(f, x) }, f, v)
+}
+
+func identity(x interface{}) interface{} { return x }
+
+// KeywordizeMapKeys returns v with the string keys of its maps, at any
+// depth, replaced by keywords, as Clojure's keywordize-keys does. If ns
+// is not empty, keys without a namespace are put in it, so that "id"
+// becomes :ns/id. Strings that do not make valid keywords are kept.
+//
+// A map[string]interface{} whose keys all convert becomes a KMap, and
+// otherwise a map[interface{}]interface{}. Unlike the KeywordizeKeys
+// encoder option, which only changes how keys are written,
+// KeywordizeMapKeys changes the value itself.
+func KeywordizeMapKeys(v interface{}, ns string) interface{} {
+	f := func(k interface{}) interface{} {
+		s, ok := k.(string)
+		if !ok {
+			return k
+		}
+		var kw Keyword
+		var err error
+		if ns != "" && !strings.Contains(s, "/") {
+			kw, err = NewKeyword(ns, s)
+		} else {
+			kw, err = ParseKeyword(s)
+		}
+		if err != nil {
+			return k
+		}
+		return kw
+	}
+	return Postwalk(func(x interface{}) interface{} {
+		m, ok := x.(map[string]interface{})
+		if !ok || m == nil {
+			return rekey(x, f)
+		}
+		km := make(KMap, len(m))
+		for k, mx := range m {
+			kw, ok := f(k).(Keyword)
+			if !ok {
+				return rekey(toInterfaceMap(m), f)
+			}
+			km[strings.TrimPrefix(string(kw), ":")] = mx
+		}
+		return km
+	}, v)
+}
+
+// StringifyMapKeys returns v with the keyword keys of its maps, at any
+// depth, replaced by strings, as Clojure's stringify-keys does. If
+// qualified is true, a key keeps its namespace, so that :db/id becomes
+// "db/id"; otherwise it becomes "id", and where two keys of a map then
+// collide, which value survives is unspecified. KMaps become
+// map[string]interface{}.
+func StringifyMapKeys(v interface{}, qualified bool) interface{} {
+	f := func(k interface{}) interface{} {
+		kw, ok := k.(Keyword)
+		if !ok {
+			return k
+		}
+		if qualified {
+			return strings.TrimPrefix(string(kw), ":")
+		}
+		return kw.Name()
+	}
+	return Postwalk(func(x interface{}) interface{} {
+		m, ok := x.(KMap)
+		if !ok || m == nil {
+			return rekey(x, f)
+		}
+		sm := make(map[string]interface{}, len(m))
+		for k, mx := range m {
+			sm[f(Keyword(k)).(string)] = mx
+		}
+		return sm
+	}, v)
+}
+
+// rekey returns x with f applied to its keys, if it is a map with keys
+// of any type.
+func rekey(x interface{}, f func(interface{}) interface{}) interface{} {
+	switch m := x.(type) {
+	case map[interface{}]interface{}:
+		if m == nil {
+			break
+		}
+		w := make(map[interface{}]interface{}, len(m))
+		for k, mx := range m {
+			w[f(k)] = mx
+		}
+		return w
+	case HashMap:
+		var w HashMap
+		m.Range(func(k, mx interface{}) bool {
+			if err := w.Set(f(k), mx); err != nil {
+				panic(err)
+			}
+			return true
+		})
+		return w
+	case *HashMap:
+		w := rekey(*m, f).(HashMap)
+		return &w
+	case OrderedMap:
+		var w OrderedMap
+		for _, k := range m.keys {
+			w.Set(f(k), m.vals[k])
+		}
+		return w
+	case *OrderedMap:
+		w := rekey(*m, f).(OrderedMap)
+		return &w
+	}
+	return x
+}
+
+func toInterfaceMap(m map[string]interface{}) map[interface{}]interface{} {
+	w := make(map[interface{}]interface{}, len(m))
+	for k, x := range m {
+		w[k] = x
+	}
+	return w
+}
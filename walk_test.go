@@ -0,0 +1,126 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type WalkTests struct{}
+
+func init() { check.Suite(&WalkTests{}) }
+
+func (*WalkTests) TestWalk(c *check.C) {
+	double := func(x interface{}) interface{} {
+		if n, ok := x.(int); ok {
+			return 2 * n
+		}
+		return x
+	}
+	count := func(x interface{}) interface{} {
+		switch x := x.(type) {
+		case Vec:
+			return len(x)
+		}
+		return x
+	}
+	c.Check(Walk(double, count, Vec{1, Vec{2}, "a"}), check.Equals, 3)
+	c.Check(Walk(double, identity, List{1, 2}), check.DeepEquals, List{2, 4})
+	c.Check(Walk(double, identity, NewSet(1, 2)), check.DeepEquals, NewSet(2, 4))
+	c.Check(Walk(double, identity, KMap{"a": 1}), check.DeepEquals, KMap{"a": 2})
+	c.Check(Walk(double, identity, map[interface{}]interface{}{1: 2}), check.DeepEquals, map[interface{}]interface{}{2: 4})
+	c.Check(Walk(double, identity, Tagged{"x", 1}), check.DeepEquals, Tagged{"x", 2})
+	c.Check(Walk(double, identity, []int{1}), check.DeepEquals, []int{1})
+	c.Check(Walk(double, identity, 1), check.Equals, 1)
+	c.Check(Walk(double, identity, Vec(nil)), check.DeepEquals, Vec(nil))
+
+	var om OrderedMap
+	om.Set(K("b"), 1)
+	om.Set(K("a"), 2)
+	w := Walk(double, identity, &om).(*OrderedMap)
+	c.Check(w.Keys(), check.DeepEquals, []interface{}{K("b"), K("a")})
+	v, _ := w.Get(K("a"))
+	c.Check(v, check.Equals, 4)
+	v, _ = om.Get(K("a"))
+	c.Check(v, check.Equals, 2)
+
+	var hm HashMap
+	hm.Set(Vec{1}, 1)
+	hw := Walk(identity, identity, hm).(HashMap)
+	v, _ = hw.Get([]int{1})
+	c.Check(v, check.Equals, 1)
+}
+
+func (*WalkTests) TestPostwalk(c *check.C) {
+	var order []interface{}
+	keywordize := func(x interface{}) interface{} {
+		order = append(order, x)
+		if s, ok := x.(string); ok {
+			return Keyword(s)
+		}
+		return x
+	}
+	v := Vec{"a", List{"b", 1}, WithMeta(Vec{"c"}, nil)}
+	got := Postwalk(keywordize, v)
+	c.Check(got, check.DeepEquals, Vec{K("a"), List{K("b"), 1}, WithMeta(Vec{K("c")}, nil)})
+	c.Check(order[:4], check.DeepEquals, []interface{}{"a", "b", 1, List{K("b"), 1}})
+	c.Check(v[0], check.Equals, "a")
+}
+
+func (*WalkTests) TestPrewalk(c *check.C) {
+	var seen []interface{}
+	prune := func(x interface{}) interface{} {
+		seen = append(seen, x)
+		if t, ok := x.(Tagged); ok && t.Tag == "secret" {
+			return nil
+		}
+		return x
+	}
+	got := Prewalk(prune, KMap{"user": "me", "pw": Tagged{"secret", Vec{"x"}}})
+	c.Check(got, check.DeepEquals, KMap{"user": "me", "pw": nil})
+	for _, x := range seen {
+		c.Check(x, check.Not(check.DeepEquals), Vec{"x"})
+	}
+}
+
+func (*WalkTests) TestKeywordizeMapKeys(c *check.C) {
+	v := map[string]interface{}{
+		"user": map[string]interface{}{"name": "ann", "db/id": 7},
+		"list": Vec{map[interface{}]interface{}{"a": 1, 2: "two"}},
+		"tags": Vec{"x"},
+	}
+	c.Check(KeywordizeMapKeys(v, ""), check.DeepEquals, KMap{
+		"user": KMap{"name": "ann", "db/id": 7},
+		"list": Vec{map[interface{}]interface{}{K("a"): 1, 2: "two"}},
+		"tags": Vec{"x"},
+	})
+	c.Check(KeywordizeMapKeys(map[string]interface{}{"id": 1, "db/x": 2}, "app"), check.DeepEquals,
+		KMap{"app/id": 1, "db/x": 2})
+	c.Check(KeywordizeMapKeys(map[string]interface{}{"ok": 1, "not ok": 2}, ""), check.DeepEquals,
+		map[interface{}]interface{}{K("ok"): 1, "not ok": 2})
+
+	var om OrderedMap
+	om.Set("b", 1)
+	om.Set("a", 2)
+	got := KeywordizeMapKeys(&om, "").(*OrderedMap)
+	c.Check(got.Keys(), check.DeepEquals, []interface{}{K("b"), K("a")})
+}
+
+func (*WalkTests) TestStringifyMapKeys(c *check.C) {
+	v := KMap{
+		"user": map[interface{}]interface{}{K("db/id"): 7, K(":name"): "ann", 1: 1},
+		"tags": NewSet(K("x")),
+	}
+	c.Check(StringifyMapKeys(v, true), check.DeepEquals, map[string]interface{}{
+		"user": map[interface{}]interface{}{"db/id": 7, "name": "ann", 1: 1},
+		"tags": NewSet(K("x")),
+	})
+	c.Check(StringifyMapKeys(KMap{"db/id": 7}, false), check.DeepEquals, map[string]interface{}{"id": 7})
+
+	var hm HashMap
+	hm.Set(K("a"), 1)
+	want := new(HashMap)
+	want.Set("a", 1)
+	c.Check(Equal(StringifyMapKeys(&hm, true), want), check.Equals, true)
+}
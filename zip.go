@@ -0,0 +1,202 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+// A Zipper is a location in a tree of EDN values, in the manner of
+// clojure.zip. It can move down into the collection at its location,
+// up to the collection holding it, and left and right among that
+// collection's elements, and replace the value there. Zippers are
+// immutable: moving and editing return new Zippers, and the values
+// zipped over are never changed, so that an edit deep in a large tree
+// copies only the collections on its path, as AssocIn does.
+//
+// The elements of vectors and lists are visited in order, the values
+// of maps in the order of Compare on their keys, or in insertion order
+// for OrderedMaps, and the elements of sets in the order of Compare.
+// The collections that can be moved into are those GetIn can step
+// into. Moving away from an edited set element panics if the new value
+// cannot be a set element.
+//
+// For example, this replaces the second element of the vector under
+// :counts in the map v:
+//
+//	z := edn.Zip(v).Down()
+//	for z != nil && !edn.Equal(z.Key(), edn.K("counts")) {
+//		z = z.Right()
+//	}
+//	v = z.Down().Right().Replace(42).Root()
+type Zipper struct {
+	node    interface{}
+	up      *Zipper       // location of the parent, or nil at the root
+	keys    []interface{} // keys of the parent's elements, in order
+	i       int           // index of node's key in keys
+	changed bool          // whether node differs from the parent's element
+}
+
+// Zip returns a Zipper at the root of v.
+func Zip(v interface{}) *Zipper {
+	return &Zipper{node: v}
+}
+
+// Node returns the value at z.
+func (z *Zipper) Node() interface{} {
+	return z.node
+}
+
+// Key returns the map key, sequence index or set element under which
+// the parent holds the value at z, or nil at the root.
+func (z *Zipper) Key() interface{} {
+	if z.up == nil {
+		return nil
+	}
+	return z.keys[z.i]
+}
+
+// IsBranch reports whether the value at z is a collection that Down
+// can move into, even if it is empty.
+func (z *Zipper) IsBranch() bool {
+	_, ok := childKeys(z.node)
+	return ok
+}
+
+// Down returns the location of the first element of the collection at
+// z, or nil if it is not a collection or is empty.
+func (z *Zipper) Down() *Zipper {
+	keys, _ := childKeys(z.node)
+	if len(keys) == 0 {
+		return nil
+	}
+	return z.child(keys, 0)
+}
+
+// child returns the location of the element of z under keys[i].
+func (z *Zipper) child(keys []interface{}, i int) *Zipper {
+	x, _ := get(z.node, keys[i])
+	return &Zipper{node: x, up: z, keys: keys, i: i}
+}
+
+// Up returns the location of the collection holding the value at z,
+// with any edits made below it, or nil at the root.
+func (z *Zipper) Up() *Zipper {
+	if z.up == nil {
+		return nil
+	}
+	if !z.changed {
+		return z.up
+	}
+	p := *z.up
+	p.node = withElem(p.node, z.keys[z.i], z.node)
+	p.changed = true
+	return &p
+}
+
+// Left returns the location of the element before the value at z, or
+// nil if it is the first, or the root.
+func (z *Zipper) Left() *Zipper {
+	return z.sibling(z.i - 1)
+}
+
+// Right returns the location of the element after the value at z, or
+// nil if it is the last, or the root.
+func (z *Zipper) Right() *Zipper {
+	return z.sibling(z.i + 1)
+}
+
+func (z *Zipper) sibling(i int) *Zipper {
+	if z.up == nil || i < 0 || i >= len(z.keys) {
+		return nil
+	}
+	if !z.changed {
+		return z.up.child(z.keys, i)
+	}
+	keys := z.keys
+	if _, ok := z.up.node.(Set); ok {
+		// The edited element is its own key.
+		keys = append([]interface{}(nil), keys...)
+		keys[z.i] = z.node
+	}
+	return z.Up().child(keys, i)
+}
+
+// Replace returns z with its value replaced by v.
+func (z *Zipper) Replace(v interface{}) *Zipper {
+	r := *z
+	r.node = v
+	r.changed = true
+	return &r
+}
+
+// Edit returns z with its value replaced by f applied to it.
+func (z *Zipper) Edit(f func(interface{}) interface{}) *Zipper {
+	return z.Replace(f(z.node))
+}
+
+// Root returns the value at the root of z's tree, with all edits made.
+func (z *Zipper) Root() interface{} {
+	for z.up != nil {
+		z = z.Up()
+	}
+	return z.node
+}
+
+// childKeys returns the keys of the elements of v in the order a
+// Zipper visits them, and whether v is a collection.
+func childKeys(v interface{}) ([]interface{}, bool) {
+	var keys []interface{}
+	switch c := v.(type) {
+	case []interface{}, Vec, List:
+		if !isDiffSeq(c) {
+			return nil, false
+		}
+		for i := range seqOf(c) {
+			keys = append(keys, i)
+		}
+		return keys, true
+	case Set:
+		if c == nil {
+			return nil, false
+		}
+		for x := range c {
+			keys = append(keys, x)
+		}
+	case OrderedMap, *OrderedMap:
+		if isNilMap(c) {
+			return nil, false
+		}
+		for _, ent := range mergeEntries(c) {
+			keys = append(keys, ent.k)
+		}
+		return keys, true
+	default:
+		if !isDiffMap(c) {
+			return nil, false
+		}
+		for _, ent := range mergeEntries(c) {
+			keys = append(keys, ent.k)
+		}
+	}
+	SortValues(keys)
+	return keys, true
+}
+
+// withElem returns a copy of coll with the element under k replaced by
+// x. For a set, the element k is replaced by x.
+func withElem(coll, k, x interface{}) interface{} {
+	if s, ok := coll.(Set); ok {
+		w := make(Set, len(s))
+		for y := range s {
+			if !Equal(y, k) {
+				w[y] = struct{}{}
+			}
+		}
+		w[x] = struct{}{}
+		return w
+	}
+	c, err := assoc(coll, k, x)
+	if err != nil {
+		// The key was taken from coll, so assoc cannot fail.
+		panic(err)
+	}
+	return c
+}
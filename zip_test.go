@@ -0,0 +1,72 @@
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package edn
+
+import (
+	"gopkg.in/check.v1"
+)
+
+type ZipTests struct{}
+
+func init() { check.Suite(&ZipTests{}) }
+
+func (*ZipTests) TestNavigate(c *check.C) {
+	v := Vec{1, KMap{"b": 2, "a": Vec{3}}, NewSet(5, 4)}
+	z := Zip(v)
+	c.Check(z.Key(), check.IsNil)
+	c.Check(z.Up(), check.IsNil)
+	c.Check(z.Left(), check.IsNil)
+
+	z = z.Down()
+	c.Check(z.Node(), check.Equals, 1)
+	c.Check(z.Key(), check.Equals, 0)
+	c.Check(z.IsBranch(), check.Equals, false)
+	c.Check(z.Down(), check.IsNil)
+	c.Check(z.Left(), check.IsNil)
+
+	m := z.Right()
+	c.Check(m.IsBranch(), check.Equals, true)
+	a := m.Down()
+	c.Check(a.Key(), check.Equals, K("a"))
+	c.Check(a.Node(), check.DeepEquals, Vec{3})
+	c.Check(a.Down().Node(), check.Equals, 3)
+	c.Check(a.Right().Node(), check.Equals, 2)
+	c.Check(a.Right().Right(), check.IsNil)
+	c.Check(a.Up().Node(), check.DeepEquals, v[1])
+
+	s := m.Right().Down()
+	c.Check(s.Node(), check.Equals, 4)
+	c.Check(s.Right().Node(), check.Equals, 5)
+	c.Check(m.Right().Right(), check.IsNil)
+
+	c.Check(Zip(Vec{}).IsBranch(), check.Equals, true)
+	c.Check(Zip(Vec{}).Down(), check.IsNil)
+	c.Check(Zip(Vec(nil)).IsBranch(), check.Equals, false)
+}
+
+func (*ZipTests) TestEdit(c *check.C) {
+	v := Vec{1, KMap{"b": 2, "a": Vec{3}}, NewSet(5, 4)}
+	inc := func(x interface{}) interface{} { return x.(int) + 1 }
+
+	z := Zip(v).Down().Edit(inc).Right().Down().Down().Edit(inc)
+	got := z.Up().Right().Replace("two").Up().Right().Down().Right().Replace(50).Root()
+	c.Check(got, check.DeepEquals, Vec{2, KMap{"b": "two", "a": Vec{4}}, NewSet(4, 50)})
+	c.Check(v, check.DeepEquals, Vec{1, KMap{"b": 2, "a": Vec{3}}, NewSet(5, 4)})
+
+	// Moving sideways from an edited set element keeps the edit.
+	got = Zip(NewSet(1, 2)).Down().Replace(10).Right().Replace(20).Root()
+	c.Check(got, check.DeepEquals, NewSet(10, 20))
+
+	var om OrderedMap
+	om.Set(K("z"), 1)
+	om.Set(K("y"), 2)
+	got = Zip(&om).Down().Right().Replace(3).Root()
+	c.Check(got.(*OrderedMap).Keys(), check.DeepEquals, []interface{}{K("z"), K("y")})
+	y, _ := got.(*OrderedMap).Get(K("y"))
+	c.Check(y, check.Equals, 3)
+	y, _ = om.Get(K("y"))
+	c.Check(y, check.Equals, 2)
+
+	c.Check(Zip(1).Replace(2).Root(), check.Equals, 2)
+}